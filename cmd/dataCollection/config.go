@@ -0,0 +1,521 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/anonymize"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/logging"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/scheduler"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/selector"
+	"github.com/prometheus/common/model"
+	"github.com/spf13/viper"
+)
+
+//config is the configuration of the data collection. Each setting has a key in the config file, an environment variable and a command line flag, given by the tags of the field, and is taken from the first of these that is set, in the order flag, environment variable, config file and finally the default.
+//Settings tagged secret are redacted when the configuration is printed, url only redacts the password of the URL.
+type config struct {
+	ClusterName  string `key:"cluster_name" path:"prometheus.cluster_name" env:"PROMETHEUS_CLUSTER" flag:"clusterName" help:"Name of the cluster to show in Densify"`
+	ClusterLabel string `key:"cluster_label" path:"prometheus.cluster_label" env:"PROMETHEUS_CLUSTER_LABEL" flag:"clusterLabel" help:"Prometheus external label used to detect the cluster name when clusterName is not set"`
+	MultiCluster bool   `key:"multi_cluster" path:"prometheus.multi_cluster" env:"PROMETHEUS_MULTI_CLUSTER" flag:"multiCluster" help:"Collect every cluster found in the clusterLabel of the Prometheus, e.g. when it is a Thanos aggregating several clusters"`
+	TargetsFile  string `key:"prometheus_targets" path:"prometheus.targets" env:"PROMETHEUS_TARGETS" flag:"targets" help:"Path to a YAML file listing the Prometheus servers to collect in one run"`
+	TargetOutput string `key:"target_output" path:"outputs.target_output" env:"PROMETHEUS_TARGET_OUTPUT" flag:"targetOutput" help:"When collecting several clusters, merged writes them all to the same files and separate writes each cluster to its own directory"`
+	Protocol     string `key:"prometheus_protocol" path:"prometheus.protocol" env:"PROMETHEUS_PROTOCOL" flag:"protocol" help:"Which protocol to use http|https"`
+	Address      string `key:"prometheus_address" path:"prometheus.address" env:"PROMETHEUS_ADDRESS" flag:"address" help:"Name of the Prometheus Server"`
+	Port         string `key:"prometheus_port" path:"prometheus.port" env:"PROMETHEUS_PORT" flag:"port" help:"Prometheus Port"`
+
+	Interval             string `key:"interval" path:"collection.interval" env:"PROMETHEUS_INTERVAL" flag:"interval" help:"Interval to use for data collection. Can be days, hours or minutes"`
+	IntervalSize         string `key:"interval_size" path:"collection.interval_size" env:"PROMETHEUS_INTERVALSIZE" flag:"intervalSize" help:"Interval size to be used for querying, a number of intervals or a duration such as 15m or 6h. eg. default of 1 with default interval of hours queries 1 last hour of info"`
+	History              int    `key:"history" path:"collection.history" env:"PROMETHEUS_HISTORY" flag:"history" help:"Amount of time to go back for data collection works with the interval and intervalSize settings"`
+	Offset               string `key:"offset" path:"collection.offset" env:"PROMETHEUS_OFFSET" flag:"offset" help:"Amount of units (based on interval value) or duration such as 30m to offset the data collection backwards in time"`
+	SampleRate           string `key:"sample_rate" path:"collection.sample_rate" env:"PROMETHEUS_SAMPLERATE" flag:"sampleRate" help:"Rate of sample points to collect, a number of minutes or a duration such as 30s. default is 5 for 1 sample for every 5 minutes."`
+	RateWindow           string `key:"rate_window" path:"collection.rate_window" env:"PROMETHEUS_RATEWINDOW" flag:"rateWindow" help:"Window of the rates calculated by the queries, e.g. 1m. Defaults to the sample rate"`
+	IncludeList          string `key:"include_list" path:"filters.include_list" env:"PROMETHEUS_INCLUDE" flag:"includeList" help:"Comma separated list of data to include in collection (cluster, node, nodegroup, container, namespace) Ex: \"node,cluster\""`
+	NamespaceInclude     string `key:"namespace_include" path:"filters.namespaces.include" env:"NAMESPACE_INCLUDE" flag:"namespaceInclude" help:"Comma separated list of the namespaces to collect, exact names or regular expressions such as team-.*. All namespaces if empty"`
+	NamespaceExclude     string `key:"namespace_exclude" path:"filters.namespaces.exclude" env:"NAMESPACE_EXCLUDE" flag:"namespaceExclude" help:"Comma separated list of the namespaces not to collect, exact names or regular expressions such as kube-.*"`
+	NamespaceFilterNodes bool   `key:"namespace_filter_nodes" path:"filters.namespaces.nodes" env:"NAMESPACE_FILTER_NODES" flag:"namespaceFilterNodes" help:"Only count the containers of the namespaces collected in the requests and limits of the nodes, node groups and cluster"`
+	LabelSelector        string `key:"label_selector" path:"filters.label_selector" env:"LABEL_SELECTOR" flag:"labelSelector" help:"Kubernetes label selector the workloads collected must match, checked against the labels of their pods, controllers and namespaces, e.g. app.kubernetes.io/part-of=payments"`
+	ExcludeAnnotation    string `key:"exclude_annotation" path:"filters.exclude_annotation" env:"EXCLUDE_ANNOTATION" flag:"excludeAnnotation" help:"Annotation, as key=value or key for any value, that opts the workloads out of the collection when set on their pods, controllers or namespaces. Empty to collect every workload"`
+	LabelAllow           string `key:"label_allow" path:"filters.labels.allow" env:"LABEL_ALLOW" flag:"labelAllow" help:"Comma separated list of the labels and annotations written to the attributes, as regular expressions optionally prefixed with the kind of entity (container, pod, namespace, node or hpa), e.g. pod:label_team. All of them if empty"`
+	LabelDeny            string `key:"label_deny" path:"filters.labels.deny" env:"LABEL_DENY" flag:"labelDeny" help:"Comma separated list of the labels and annotations left out of the attributes, in the same form as labelAllow, e.g. annotation_.*"`
+	LabelKeepInternal    bool   `key:"label_keep_internal" path:"filters.labels.keep_internal" env:"LABEL_KEEP_INTERNAL" flag:"labelKeepInternal" help:"Keep the labels added by Prometheus and the scrape configuration, e.g. instance and job, in the attributes"`
+	LabelColumns         string `key:"label_columns" path:"outputs.label_columns" env:"LABEL_COLUMNS" flag:"labelColumns" help:"Comma separated list of the labels written to columns of their own in the attributes, as label=column optionally prefixed with the kind of entity (container, pod, namespace or node) the label is looked up in, e.g. label_team=Business Unit,namespace:label_owner=Application Owner"`
+	Debug                bool   `key:"debug" path:"outputs.log.debug" env:"PROMETHEUS_DEBUG" flag:"debug" help:"Enable debug logging"`
+
+	OAuthToken         string `key:"prometheus_oauth_token" path:"auth.oauth_token" env:"OAUTH_TOKEN" flag:"oAuthToken" help:"Path to oAuth token file required to authenticate with the Cluster where Prometheus is running."`
+	CACert             string `key:"ca_certificate" path:"auth.ca_certificate" env:"CA_CERT" flag:"caCert" help:"Path to CA certificate required to pass certificate validation if using HTTPS"`
+	OAuth2TokenURL     string `key:"prometheus_oauth2_token_url" path:"auth.oauth2.token_url" env:"OAUTH2_TOKEN_URL" flag:"oAuth2TokenURL" secret:"url" help:"OAuth2 token endpoint used to get client credentials tokens for Prometheus. Takes precedence over oAuthToken"`
+	OAuth2ClientID     string `key:"prometheus_oauth2_client_id" path:"auth.oauth2.client_id" env:"OAUTH2_CLIENT_ID" flag:"oAuth2ClientID" help:"OAuth2 client ID"`
+	OAuth2ClientSecret string `key:"prometheus_oauth2_client_secret" path:"auth.oauth2.client_secret" env:"OAUTH2_CLIENT_SECRET" flag:"oAuth2ClientSecret" help:"Path to the file containing the OAuth2 client secret"`
+	OAuth2Scopes       string `key:"prometheus_oauth2_scopes" path:"auth.oauth2.scopes" env:"OAUTH2_SCOPES" flag:"oAuth2Scopes" help:"Comma separated list of OAuth2 scopes to request"`
+
+	Daemon            bool   `key:"daemon" path:"collection.daemon" env:"DAEMON" flag:"daemon" help:"Keep running and collect on the schedule instead of collecting once"`
+	Schedule          string `key:"schedule" path:"collection.schedule" env:"DAEMON_SCHEDULE" flag:"schedule" help:"Cron schedule (minute hour day-of-month month day-of-week, in UTC) of the collections in daemon mode. Defaults to the start of every interval"`
+	DaemonCommand     string `key:"daemon_command" path:"upload.command" env:"DAEMON_COMMAND" flag:"daemonCommand" secret:"true" help:"Command run after each collection in daemon mode, e.g. to upload the data"`
+	DaemonGracePeriod int    `key:"daemon_grace_period" path:"collection.daemon_grace_period" env:"DAEMON_GRACE_PERIOD" flag:"daemonGracePeriod" help:"Seconds to wait for a collection to finish when the daemon is stopped before abandoning it"`
+
+	ListenAddress   string `key:"listen_address" path:"outputs.metrics.listen_address" env:"LISTEN_ADDRESS" flag:"listenAddress" help:"Address to serve /healthz, /readyz and the self metrics on /metrics, e.g. :8080. Disabled if empty"`
+	MetricsTextfile string `key:"metrics_textfile" path:"outputs.metrics.textfile" env:"METRICS_TEXTFILE" flag:"metricsTextfile" help:"File the self metrics are written to after each collection for the node exporter textfile collector"`
+	PushgatewayURL  string `key:"pushgateway_url" path:"outputs.metrics.pushgateway_url" env:"PUSHGATEWAY_URL" flag:"pushgateway" secret:"url" help:"URL of a Pushgateway the self metrics are pushed to after each collection"`
+
+	LogFormat                string `key:"log_format" path:"outputs.log.format" env:"LOG_FORMAT" flag:"logFormat" help:"Format of the log records, logfmt or json"`
+	LogLevel                 string `key:"log_level" path:"outputs.log.level" env:"LOG_LEVEL" flag:"logLevel" help:"Lowest level of the records logged, debug, info, warn or error. The debug setting lowers it to debug"`
+	LogOutput                string `key:"log_output" path:"outputs.log.output" env:"LOG_OUTPUT" flag:"logOutput" help:"Where the log is written, file (data/log.txt), stdout, stderr or both (file and stdout)"`
+	MissingOptionalAsWarning bool   `key:"missing_optional_as_warning" path:"collection.missing_optional_as_warning" env:"MISSING_OPTIONAL_AS_WARNING" flag:"missingOptionalAsWarning" help:"Treat optional metrics that return no data as warnings rather than making the run partial"`
+	RunTimeout               string `key:"run_timeout" path:"collection.run_timeout" env:"RUN_TIMEOUT" flag:"runTimeout" help:"Longest a collection can run before it is stopped, e.g. 50m. No limit if empty"`
+
+	Start              string `key:"start" path:"collection.start" env:"PROMETHEUS_START" flag:"start" help:"Start of an absolute time window to collect in RFC3339, e.g. 2020-01-02T15:00:00Z. Split into history windows of intervalSize"`
+	End                string `key:"end" path:"collection.end" env:"PROMETHEUS_END" flag:"end" help:"End of an absolute time window to collect in RFC3339. Defaults to now when start is set"`
+	Backfill           bool   `key:"backfill" path:"collection.backfill" env:"BACKFILL" flag:"backfill" help:"Backfill the history window by window, resuming from the checkpoint of a backfill that was stopped"`
+	BackfillCheckpoint string `key:"backfill_checkpoint" path:"collection.backfill_checkpoint" env:"BACKFILL_CHECKPOINT" flag:"backfillCheckpoint" help:"File recording the windows completed by the backfill"`
+	StateFile          string `key:"state_file" path:"collection.state_file" env:"STATE_FILE" flag:"stateFile" help:"File holding the high-water marks of the workload files, each collection only collects the data since the marks. Collects the history each time if empty"`
+	StateConfigMap     string `key:"state_configmap" path:"collection.state_configmap" env:"STATE_CONFIGMAP" flag:"stateConfigMap" help:"Name of the ConfigMap the state file is written as, written as plain JSON if empty"`
+	MaxCatchUp         string `key:"max_catch_up" path:"collection.max_catch_up" env:"MAX_CATCH_UP" flag:"maxCatchUp" help:"Longest period an incremental collection catches up on after missed collections, e.g. 7d"`
+
+	Anonymize            bool   `key:"anonymize" path:"outputs.anonymize.enabled" env:"ANONYMIZE" flag:"anonymize" help:"Replace the names of the clusters, namespaces, controllers, pods, containers, nodes and node groups in the csv files with pseudonyms"`
+	AnonymizeKeyFile     string `key:"anonymize_key_file" path:"outputs.anonymize.key_file" env:"ANONYMIZE_KEY_FILE" flag:"anonymizeKeyFile" help:"Path to the file holding the secret key of the pseudonyms"`
+	AnonymizeLabelValues bool   `key:"anonymize_label_values" path:"outputs.anonymize.label_values" env:"ANONYMIZE_LABEL_VALUES" flag:"anonymizeLabelValues" help:"Also replace the values of all the labels written to the attributes with pseudonyms"`
+	AnonymizeMappingFile string `key:"anonymize_mapping_file" path:"outputs.anonymize.mapping_file" env:"ANONYMIZE_MAPPING_FILE" flag:"anonymizeMappingFile" help:"Local file the names behind the pseudonyms are written to, kept out of the data directory"`
+}
+
+//defaultConfig returns the configuration used for the settings that aren't set.
+func defaultConfig() *config {
+	return &config{
+		ClusterLabel:             "cluster",
+		TargetOutput:             "merged",
+		Protocol:                 "http",
+		Port:                     "9090",
+		Interval:                 "hours",
+		IntervalSize:             "1",
+		History:                  1,
+		Offset:                   "0",
+		SampleRate:               "5",
+		IncludeList:              "container,node,cluster,nodegroup",
+		DaemonGracePeriod:        25,
+		LogFormat:                logging.Logfmt,
+		LogLevel:                 "info",
+		LogOutput:                "both",
+		MissingOptionalAsWarning: true,
+		BackfillCheckpoint:       "./data/backfill_checkpoint.json",
+		MaxCatchUp:               "7d",
+		AnonymizeMappingFile:     "./anonymization_mapping.csv",
+		ExcludeAnnotation:        "densify.com/exclude=true",
+	}
+}
+
+//forwarderKeys are the settings of the Forwarder, which shares the config file, so they aren't reported as unknown.
+var forwarderKeys = map[string]bool{
+	"host": true, "protocol": true, "port": true, "endpoint": true, "user": true, "password": true, "epassword": true,
+	"proxyhost": true, "proxyport": true, "proxyprotocol": true, "proxyauth": true, "proxyuser": true, "proxypassword": true, "eproxypassword": true, "proxyserver": true, "proxydomain": true,
+	"zip": true, "zipname": true, "prefix": true, "source": true, "stamp": true, "tail": true, "v2": true, "command": true, "args": true, "internal": true,
+}
+
+//levels are the entries allowed in the include list.
+var levels = []string{"container", "namespace", "node", "nodegroup", "cluster"}
+
+//logOutputs are where the log can be written, both being the log file and stdout.
+var logOutputs = []string{"file", "stdout", "stderr", "both"}
+
+//logOutputList is the list of the log outputs used in the error of an unknown output.
+const logOutputList = "file, stdout, stderr or both"
+
+//validLogOutput returns whether the output is one of the logOutputs.
+func validLogOutput(output string) bool {
+	for _, o := range logOutputs {
+		if o == output {
+			return true
+		}
+	}
+	return false
+}
+
+//configSource is where each setting was taken from, by key.
+type configSource map[string]string
+
+//loadConfig reads the configuration from the config file, the environment variables and the command line flags in args, over the defaults.
+//The config file is found from the file and path flags or the PROMETHEUS_CONFIGFILE and PROMETHEUS_CONFIGPATH environment variables. All the values that can't be read are returned as errors rather than stopping at the first one.
+func loadConfig(fs *flag.FlagSet, args []string) (*config, configSource, []error) {
+	cfg := defaultConfig()
+	source := configSource{}
+	var errs []error
+
+	configFile, configPath := "config", "./config"
+	if value, ok := os.LookupEnv("PROMETHEUS_CONFIGFILE"); ok {
+		configFile = value
+	}
+	if value, ok := os.LookupEnv("PROMETHEUS_CONFIGPATH"); ok {
+		configPath = value
+	}
+	fs.StringVar(&configFile, "file", configFile, "Name of the config file, e.g. config to find config.properties, config.yaml or config.json, or the file name with its extension")
+	fs.StringVar(&configPath, "path", configPath, "Path to where the config file is stored")
+
+	//The flags are kept as given and applied last so they override the config file and environment variables.
+	flags := map[string]string{}
+	forEachSetting(cfg, func(field reflect.Value, tag reflect.StructTag) {
+		fs.Var(&flagValue{name: tag.Get("flag"), set: flags, isBool: field.Kind() == reflect.Bool, value: fmt.Sprint(field.Interface())}, tag.Get("flag"), tag.Get("help"))
+	})
+	if err := fs.Parse(args); err != nil {
+		return cfg, source, []error{err}
+	}
+
+	if configFile != "" {
+		//The config file is found by its name with any of the extensions supported by viper, e.g. config.properties, config.yaml or config.json, unless the name has the extension.
+		v := viper.New()
+		if ext := strings.TrimPrefix(filepath.Ext(configFile), "."); contains(viper.SupportedExts, ext) {
+			v.SetConfigFile(filepath.Join(configPath, configFile))
+		} else {
+			v.SetConfigName(configFile)
+			v.AddConfigPath(configPath)
+		}
+		if err := v.ReadInConfig(); err == nil {
+			errs = append(errs, applyFile(cfg, source, v)...)
+		} else if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			errs = append(errs, fmt.Errorf("unable to read config file %s in %s: %s", configFile, configPath, err))
+		}
+	}
+
+	forEachSetting(cfg, func(field reflect.Value, tag reflect.StructTag) {
+		if value, ok := os.LookupEnv(tag.Get("env")); ok && value != "" {
+			if err := setField(field, value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid environment variable %s: %s", tag.Get("env"), err))
+			}
+			source[tag.Get("key")] = "env " + tag.Get("env")
+		}
+	})
+
+	forEachSetting(cfg, func(field reflect.Value, tag reflect.StructTag) {
+		if value, ok := flags[tag.Get("flag")]; ok {
+			if err := setField(field, value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid flag -%s: %s", tag.Get("flag"), err))
+			}
+			source[tag.Get("key")] = "flag -" + tag.Get("flag")
+		}
+	})
+	return cfg, source, errs
+}
+
+//applyFile sets the settings found in the config file, returning an error for each key that isn't a setting of the data collection or the Forwarder.
+//Each setting can be given by its key, as in config.properties, or by its path in the sections of a structured config file, e.g. prometheus.address, but not both.
+func applyFile(cfg *config, source configSource, v *viper.Viper) []error {
+	var errs []error
+	known := map[string]bool{}
+	forEachSetting(cfg, func(field reflect.Value, tag reflect.StructTag) {
+		key, path := tag.Get("key"), tag.Get("path")
+		//The sections are known too, so an empty section isn't reported.
+		known[key], known[path], known[strings.Split(path, ".")[0]] = true, true, true
+		name := key
+		if v.IsSet(path) {
+			if v.IsSet(key) {
+				errs = append(errs, fmt.Errorf("%s and %s are the same setting, only one can be set in %s", key, path, v.ConfigFileUsed()))
+				return
+			}
+			name = path
+		} else if !v.IsSet(key) {
+			return
+		}
+		if err := setField(field, fileValue(v.Get(name))); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s in %s: %s", name, v.ConfigFileUsed(), err))
+		}
+		source[key] = "file " + name
+	})
+	for _, key := range v.AllKeys() {
+		if !known[key] && !forwarderKeys[key] {
+			errs = append(errs, fmt.Errorf("unknown setting %s in %s", key, v.ConfigFileUsed()))
+		}
+	}
+	return errs
+}
+
+//fileValue returns the value read from the config file as the string form of the setting. Lists, e.g. of the include list or the OAuth2 scopes in YAML, are joined with commas.
+func fileValue(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		values := make([]string, len(list))
+		for i, v := range list {
+			values[i] = fmt.Sprint(v)
+		}
+		return strings.Join(values, ",")
+	}
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+//forEachSetting calls fn with each field of the configuration and its tags.
+func forEachSetting(cfg *config, fn func(field reflect.Value, tag reflect.StructTag)) {
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		fn(v.Field(i), v.Type().Field(i).Tag)
+	}
+}
+
+//setField parses the value into the field according to its type.
+func setField(field reflect.Value, value string) error {
+	value = strings.TrimSpace(value)
+	switch field.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q must be true or false", value)
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q must be a whole number", value)
+		}
+		field.SetInt(int64(n))
+	default:
+		field.SetString(value)
+	}
+	return nil
+}
+
+//flagValue keeps the value of a flag so it can be applied after the config file and environment variables.
+type flagValue struct {
+	name, value string
+	isBool      bool
+	set         map[string]string
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *flagValue) Set(value string) error {
+	f.value = value
+	f.set[f.name] = value
+	return nil
+}
+
+//IsBoolFlag lets the boolean flags be given without a value, e.g. -daemon.
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+//validate checks the values of the settings and the combinations of settings that can't be used together. It returns all the errors found.
+func (c *config) validate() []error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.Protocol != "http" && c.Protocol != "https" {
+		check(fmt.Errorf("invalid prometheus_protocol %q, it must be http or https", c.Protocol))
+	}
+	if c.Address == "" && c.TargetsFile == "" {
+		check(fmt.Errorf("prometheus_address is required unless prometheus_targets is set"))
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		check(fmt.Errorf("invalid prometheus_port %q, it must be a number from 1 to 65535", c.Port))
+	}
+	if u, err := url.Parse("http://" + c.Address); c.Address != "" && (err != nil || u.Host != c.Address || u.Port() != "" || strings.ContainsAny(c.Address, "@ <>")) {
+		check(fmt.Errorf("invalid prometheus_address %q, it must be a host name or IP address without a scheme, port or path", c.Address))
+	}
+	if c.TargetOutput != "merged" && c.TargetOutput != "separate" {
+		check(fmt.Errorf("invalid target_output %q, it must be merged or separate", c.TargetOutput))
+	}
+	if c.TargetsFile != "" {
+		if _, err := loadTargets(c.TargetsFile); err != nil {
+			check(fmt.Errorf("invalid prometheus_targets: %s", err))
+		}
+	}
+
+	if err := common.CheckInterval(c.Interval); err != nil {
+		check(err)
+	} else {
+		check(checkDuration("interval_size", c.IntervalSize, c.Interval, false))
+		check(checkDuration("offset", c.Offset, c.Interval, true))
+	}
+	check(checkDuration("sample_rate", c.SampleRate, "minutes", false))
+	if c.RateWindow != "" {
+		check(checkDuration("rate_window", c.RateWindow, "minutes", false))
+	}
+	if c.History < 1 {
+		check(fmt.Errorf("invalid history %d, it must be at least 1", c.History))
+	}
+	for _, level := range strings.Split(c.IncludeList, ",") {
+		if level = strings.ToLower(strings.TrimSpace(level)); level != "" && !contains(levels, level) {
+			check(fmt.Errorf("invalid include_list entry %q, it must be one of %s", level, strings.Join(levels, ", ")))
+		}
+	}
+
+	if _, err := common.NamespaceMatcher(strings.Split(c.NamespaceInclude, ","), strings.Split(c.NamespaceExclude, ",")); err != nil {
+		check(err)
+	}
+	if _, err := selector.Parse(c.LabelSelector); err != nil {
+		check(err)
+	}
+	if _, err := selector.ParseAnnotation(c.ExcludeAnnotation); err != nil {
+		check(err)
+	}
+	if _, err := common.NewLabelFilter(strings.Split(c.LabelAllow, ","), strings.Split(c.LabelDeny, ","), c.LabelKeepInternal); err != nil {
+		check(err)
+	}
+	if _, err := common.NewLabelColumns(strings.Split(c.LabelColumns, ",")); err != nil {
+		check(err)
+	}
+
+	check(checkURL("prometheus_oauth2_token_url", c.OAuth2TokenURL))
+	check(checkURL("pushgateway_url", c.PushgatewayURL))
+	if c.OAuth2TokenURL != "" && (c.OAuth2ClientID == "" || c.OAuth2ClientSecret == "") {
+		check(fmt.Errorf("prometheus_oauth2_client_id and prometheus_oauth2_client_secret are required with prometheus_oauth2_token_url"))
+	}
+
+	if c.Schedule != "" {
+		_, err := scheduler.Parse(c.Schedule)
+		check(err)
+	}
+	if c.DaemonGracePeriod < 0 {
+		check(fmt.Errorf("invalid daemon_grace_period %d, it can't be negative", c.DaemonGracePeriod))
+	}
+	if c.ListenAddress != "" {
+		if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+			check(fmt.Errorf("invalid listen_address %q, it must be host:port or :port", c.ListenAddress))
+		}
+	}
+
+	if c.LogFormat != logging.Logfmt && c.LogFormat != logging.JSON {
+		check(fmt.Errorf("invalid log_format %q, it must be logfmt or json", c.LogFormat))
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		check(err)
+	}
+	if !validLogOutput(c.LogOutput) {
+		check(fmt.Errorf("invalid log_output %q, it must be %s", c.LogOutput, logOutputList))
+	}
+	if c.RunTimeout != "" {
+		if d, err := time.ParseDuration(c.RunTimeout); err != nil || d < 0 {
+			check(fmt.Errorf("invalid run_timeout %q, it must be a duration such as 50m", c.RunTimeout))
+		}
+	}
+
+	for key, value := range map[string]string{"start": c.Start, "end": c.End} {
+		if _, err := time.Parse(time.RFC3339, value); value != "" && err != nil {
+			check(fmt.Errorf("invalid %s %q, it must be in RFC3339 format e.g. 2020-01-02T15:00:00Z", key, value))
+		}
+	}
+	if (c.Start != "" || c.End != "") && c.Daemon {
+		check(fmt.Errorf("start and end can't be used in daemon mode"))
+	}
+	if c.Backfill && c.Daemon {
+		check(fmt.Errorf("backfill can't be used in daemon mode"))
+	}
+	if c.StateFile != "" && (c.Backfill || c.Start != "" || c.End != "") {
+		check(fmt.Errorf("state_file can't be used with backfill, start or end"))
+	}
+	if _, err := model.ParseDuration(c.MaxCatchUp); c.MaxCatchUp != "" && err != nil {
+		check(fmt.Errorf("invalid max_catch_up %q, it must be a duration such as 7d", c.MaxCatchUp))
+	}
+
+	if c.Anonymize {
+		if c.AnonymizeKeyFile == "" {
+			check(fmt.Errorf("anonymize_key_file is required with anonymize"))
+		} else if _, err := anonymize.New(c.AnonymizeKeyFile, c.AnonymizeMappingFile, c.AnonymizeLabelValues); err != nil {
+			check(fmt.Errorf("invalid anonymization settings: %s", err))
+		}
+		//The data directory is sent to Densify so the mapping file would undo the anonymization.
+		if data, err := filepath.Abs(dataDir); err == nil {
+			if mapping, err := filepath.Abs(c.AnonymizeMappingFile); err == nil && strings.HasPrefix(mapping, data+string(filepath.Separator)) {
+				check(fmt.Errorf("anonymize_mapping_file %s can't be in the data directory as it is sent to Densify", c.AnonymizeMappingFile))
+			}
+		}
+	}
+	return errs
+}
+
+//checkDuration checks the setting is a duration, with plain numbers being a number of the unit. Only settings that allow zero can be 0.
+func checkDuration(key, value, unit string, allowZero bool) error {
+	d, err := common.ParseDuration(value, unit)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %s", key, err)
+	}
+	if d < 0 {
+		return fmt.Errorf("invalid %s %q, it can't be negative", key, value)
+	}
+	if d == 0 && !allowZero {
+		return fmt.Errorf("invalid %s %q, it must be greater than 0", key, value)
+	}
+	return nil
+}
+
+//checkURL checks the setting is an absolute http or https URL if it is set.
+func checkURL(key, value string) error {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid %s %q, it must be an http or https URL", key, redactURL(value))
+	}
+	return nil
+}
+
+//redactURL hides the password of a URL.
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "redacted")
+	}
+	return u.String()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+//print writes the effective configuration as a table of the keys, their values and where they were set, with secrets redacted.
+func (c *config) print(w io.Writer, source configSource) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	forEachSetting(c, func(field reflect.Value, tag reflect.StructTag) {
+		value := fmt.Sprint(field.Interface())
+		switch tag.Get("secret") {
+		case "true":
+			if value != "" {
+				value = "<redacted>"
+			}
+		case "url":
+			value = redactURL(value)
+		}
+		from := source[tag.Get("key")]
+		if from == "" {
+			from = "default"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", tag.Get("key"), strconv.Quote(value), from)
+	})
+	tw.Flush()
+}
+
+//configErrors returns the errors of an invalid configuration as a single message, one error per line.
+func configErrors(errs []error) string {
+	msg := "invalid configuration:"
+	for _, err := range errs {
+		msg += "\n  " + err.Error()
+	}
+	return msg
+}
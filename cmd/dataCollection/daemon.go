@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/scheduler"
+)
+
+//runDaemon runs the collection on the schedule until the process is stopped with SIGINT or SIGTERM. Runs never overlap, if a collection takes longer than the schedule the runs it overlaps are skipped.
+//A signal while waiting for the next run stops straight away. A signal during a collection lets it finish for up to the grace period before it is abandoned, a second signal abandons it straight away.
+func runDaemon() {
+	sched := scheduler.Every(params.IntervalSize)
+	if schedule != "" {
+		var err error
+		if sched, err = scheduler.Parse(schedule); err != nil {
+			params.Logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+	spec := sched.String()
+	params.Logger.Info("Running as a daemon with schedule " + spec)
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	for {
+		next := sched.Next(time.Now())
+		if next.IsZero() {
+			params.Logger.Error("Schedule " + spec + " never runs")
+			os.Exit(1)
+		}
+		params.Logger.Info("Next collection at " + next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case sig := <-signals:
+			timer.Stop()
+			params.Logger.Info("Received " + sig.String() + ", stopping")
+			return
+		case <-timer.C:
+		}
+
+		if stop := runScheduled(next, signals); stop {
+			return
+		}
+		if skipped := sched.Next(next); !skipped.IsZero() && skipped.Before(time.Now()) {
+			params.Logger.Warn("Collection for " + next.Format(time.RFC3339) + " ran past the next scheduled run, skipping the runs it overlapped")
+		}
+	}
+}
+
+//runScheduled runs the collection scheduled for the time given followed by the daemon command. It returns true if the daemon was asked to stop while it ran.
+func runScheduled(scheduled time.Time, signals <-chan os.Signal) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx, scheduled)
+		if daemonCommand != "" && ctx.Err() == nil {
+			runDaemonCommand(ctx)
+		}
+	}()
+
+	stop := false
+	var grace <-chan time.Time
+	for {
+		select {
+		case <-done:
+			if ctx.Err() != nil {
+				params.Logger.Warn("Collection for "+scheduled.Format(time.RFC3339)+" abandoned", "duration", time.Since(start))
+			} else {
+				params.Logger.Info("Collection for "+scheduled.Format(time.RFC3339)+" completed", "duration", time.Since(start))
+			}
+			return stop
+		case sig := <-signals:
+			if stop {
+				params.Logger.Warn("Received " + sig.String() + " again, abandoning the collection")
+				cancel()
+				continue
+			}
+			stop = true
+			grace = time.After(time.Duration(daemonGracePeriod) * time.Second)
+			params.Logger.Info("Received " + sig.String() + ", stopping once the collection finishes")
+		case <-grace:
+			params.Logger.Warn("Collection didn't finish within the grace period, abandoning it")
+			cancel()
+		}
+	}
+}
+
+//runDaemonCommand runs the command configured to be run after each collection through the shell, e.g. to upload the data.
+func runDaemonCommand(ctx context.Context) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", daemonCommand)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		params.Logger.Error("Daemon command failed: " + err.Error())
+	}
+}
@@ -2,278 +2,406 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/anonymize"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/checkpoint"
 	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/cluster"
 	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
 	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/container2"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/logging"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/namespace"
 	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/node"
 	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/nodegroup"
-	"github.com/spf13/viper"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/plan"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/selector"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/selfmetrics"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/summary"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/watermark"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
 )
 
+// version of the data collection, logged at the start and reported in the run summary
+const version = "2.2.1"
+
+// dataDir is the directory the csv files are written to, which is sent to Densify
+const dataDir = "./data"
+
 // Global structure used to store Forwarder instance parameters
 var params *common.Parameters
 
 // Parameters that allows user to control what levels they want to collect data on (cluster, node, container)
-var includeContainer, includeNode, includeNodeGroup, includeCluster bool
+var includeContainer, includeNamespace, includeNode, includeNodeGroup, includeCluster bool
+
+// Comma separated list of the levels to collect, used when a target doesn't have its own list
+var includeList string
+
+// Parameters for collecting every cluster found in the cluster label of a single Prometheus or Thanos
+var multiCluster bool
+var clusterLabel string
+
+// Parameters for collecting several Prometheus servers in one run and whether their output is merged or written to a directory per cluster
+var targetsFile, targetOutput string
+
+// Parameters for running as a daemon that collects on a schedule, the command run after each collection and how long to wait for a collection to finish when stopping
+var daemon bool
+var schedule, daemonCommand string
+var daemonGracePeriod int
+
+// Parameters for reporting the self metrics: the address serving the health checks and metrics, the node exporter textfile and the Pushgateway the metrics are written to after each collection
+var listenAddress, metricsTextfile, pushgatewayURL string
+
+//reportDir is where the log file and run summary are written. It is the data directory so they are sent to Densify for support, unless the names are anonymized as they hold the names, in which case they are kept next to the anonymization mapping file.
+var reportDir = dataDir
+
+// Parameters for the log: the format of the records, the lowest level logged and whether it is written to the log file, stdout, stderr or both the log file and stdout
+var logFormat, logLevel, logOutput string
+
+// Parameter for whether optional metrics that return no data are only warnings in the run summary rather than making the run partial
+var missingOptionalAsWarning bool
+
+// Parameter for the longest a collection can run before it is stopped, 0 for no limit
+var runTimeout time.Duration
+
+// Parameters for collecting an absolute time window instead of the intervals before now, zero if not set
+var startTime, endTime time.Time
+
+// Parameters for backfilling the history of a new cluster, which records the windows collected in the checkpoint file so a backfill that was stopped can be resumed
+var backfill bool
+var backfillCheckpoint string
+
+// Parameters for incremental collections: the state file holding the high-water marks of the workload files, the ConfigMap it is written as if set, and how far back a collection catches up after missed runs
+var stateFile, stateConfigMap string
+var maxCatchUp time.Duration
+
+// Parameters for a dry run that plans the queries without running them: whether the schema detection queries are run against Prometheus and the file the plan is written to as JSON, printed if empty
+var dryRun, dryRunDetect bool
+var dryRunOutput string
+
+//target holds the settings for one Prometheus when collecting several in one run. It uses the same names as the config file and any setting that isn't set is taken from the main configuration.
+type target struct {
+	ClusterName            string `yaml:"cluster_name"`
+	PromProtocol           string `yaml:"prometheus_protocol"`
+	PromAddr               string `yaml:"prometheus_address"`
+	PromPort               string `yaml:"prometheus_port"`
+	Include                string `yaml:"include_list"`
+	OAuthTokenPath         string `yaml:"prometheus_oauth_token"`
+	CaCertPath             string `yaml:"ca_certificate"`
+	OAuth2TokenURL         string `yaml:"prometheus_oauth2_token_url"`
+	OAuth2ClientID         string `yaml:"prometheus_oauth2_client_id"`
+	OAuth2ClientSecretPath string `yaml:"prometheus_oauth2_client_secret"`
+	OAuth2Scopes           string `yaml:"prometheus_oauth2_scopes"`
+}
 
-//initParamters will look for settings defined on the command line or in config.properties file and update accordingly. Also defines the default values for these variables.
-//Note if the value is defined both on the command line and in the config.properties the value in the config.properties will be used.
+//initParameters reads the configuration from the command line, environment variables and config file and sets the parameters of the collection. It exits listing every invalid setting rather than only the first.
+//With -validate-config it only reports whether the configuration is valid and with -print-config it prints the effective configuration, both exiting without collecting.
 func initParameters() {
-	//Set default settings
-	var clusterName string
-	var promProtocol = "http"
-	var promAddr string
-	var promPort = "9090"
-	var interval = "hours"
-	var intervalSize = 1
-	var history = 1
-	var offset int
-	var debug = false
-	var configFile = "config"
-	var configPath = "./config"
-	var sampleRate = 5
-	var include = "container,node,cluster,nodegroup"
-	var oAuthTokenPath = ""
-	var caCertPath = ""
-
-	//Temporary variables for procassing flags
-	var clusterNameTemp, promAddrTemp, promPortTemp, promProtocolTemp, intervalTemp, oAuthTokenPathTemp, caCertPathTemp string
-	var intervalSizeTemp, historyTemp, offsetTemp, sampleRateTemp int
-	var debugTemp bool
-	var includeTemp string
-
-	//Set settings using environment variables
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_CLUSTER"); ok {
-		clusterName = tempEnvVar
+	var validateConfig, printConfig bool
+	flag.BoolVar(&validateConfig, "validate-config", false, "Check the configuration and exit, listing the invalid settings with exit code 1 if it isn't valid")
+	flag.BoolVar(&printConfig, "print-config", false, "Print the effective configuration and where each setting was taken from, with secrets redacted, and exit")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the queries the collection would run, with the time range and step of each history window and the file they feed, without running them")
+	flag.BoolVar(&dryRunDetect, "dry-run-detect", false, "Run the schema detection queries of the dry run against Prometheus so the plan uses the labels and metrics found there")
+	flag.StringVar(&dryRunOutput, "dry-run-output", "", "File the plan of the dry run is written to as JSON instead of being printed")
+
+	cfg, source, errs := loadConfig(flag.CommandLine, os.Args[1:])
+	errs = append(errs, cfg.validate()...)
+	if printConfig {
+		cfg.print(os.Stdout, source)
 	}
-
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_PROTOCOL"); ok {
-		promProtocol = tempEnvVar
+	if validateConfig || printConfig {
+		if len(errs) > 0 {
+			fmt.Fprintln(os.Stderr, configErrors(errs))
+			os.Exit(1)
+		}
+		if validateConfig {
+			fmt.Println("Configuration is valid")
+		}
+		os.Exit(0)
 	}
-
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_ADDRESS"); ok {
-		promAddr = tempEnvVar
+	if len(errs) > 0 {
+		log.Fatal(configErrors(errs))
 	}
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_PORT"); ok {
-		promPort = tempEnvVar
+	clusterLabel = cfg.ClusterLabel
+	multiCluster = cfg.MultiCluster
+	targetsFile, targetOutput = cfg.TargetsFile, cfg.TargetOutput
+	daemon, schedule, daemonCommand, daemonGracePeriod = cfg.Daemon, cfg.Schedule, cfg.DaemonCommand, cfg.DaemonGracePeriod
+	listenAddress, metricsTextfile, pushgatewayURL = cfg.ListenAddress, cfg.MetricsTextfile, cfg.PushgatewayURL
+	logFormat, logLevel, logOutput = cfg.LogFormat, cfg.LogLevel, cfg.LogOutput
+	missingOptionalAsWarning = cfg.MissingOptionalAsWarning
+	backfill, backfillCheckpoint = cfg.Backfill, cfg.BackfillCheckpoint
+	stateFile, stateConfigMap = cfg.StateFile, cfg.StateConfigMap
+	includeList = cfg.IncludeList
+	if cfg.Anonymize {
+		reportDir = filepath.Dir(cfg.AnonymizeMappingFile)
 	}
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_INTERVAL"); ok {
-		interval = tempEnvVar
+	if dryRun || dryRunDetect || dryRunOutput != "" {
+		//A dry run doesn't write to the data directory, the plan is printed to stdout so the log goes to stderr.
+		dryRun, logOutput = true, "stderr"
 	}
-
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_INTERVALSIZE"); ok {
-		intervalSizeTemp, err := strconv.ParseInt(tempEnvVar, 10, 64)
-		if err == nil {
-			intervalSize = int(intervalSizeTemp)
-		}
+	logger, err := newLogger(cfg.Debug)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_SAMPLERATE"); ok {
-		sampleRateTemp, err := strconv.ParseInt(tempEnvVar, 10, 64)
-		if err == nil {
-			sampleRate = int(sampleRateTemp)
-		}
+	//The settings were validated so they parse without errors.
+	if cfg.RunTimeout != "" {
+		runTimeout, _ = time.ParseDuration(cfg.RunTimeout)
+	}
+	intervalSize, _ := common.ParseDuration(cfg.IntervalSize, cfg.Interval)
+	offset, _ := common.ParseDuration(cfg.Offset, cfg.Interval)
+	sampleRate, _ := common.ParseDuration(cfg.SampleRate, "minutes")
+	rateWindow := sampleRate
+	if cfg.RateWindow != "" {
+		rateWindow, _ = common.ParseDuration(cfg.RateWindow, "minutes")
+	}
+	if cfg.MaxCatchUp != "" {
+		catchUp, _ := model.ParseDuration(cfg.MaxCatchUp)
+		maxCatchUp = time.Duration(catchUp)
 	}
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_HISTORY"); ok {
-		historyTemp, err := strconv.ParseInt(tempEnvVar, 10, 64)
-		if err == nil {
-			history = int(historyTemp)
+	history := cfg.History
+	if cfg.Start != "" || cfg.End != "" {
+		if startTime, endTime, history, err = timeWindow(cfg.Start, cfg.End, intervalSize, history); err != nil {
+			log.Fatal(err)
 		}
 	}
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_OFFSET"); ok {
-		offsetTemp, err := strconv.ParseInt(tempEnvVar, 10, 64)
-		if err == nil {
-			offset = int(offsetTemp)
+	namespaceMatcher, _ := common.NamespaceMatcher(strings.Split(cfg.NamespaceInclude, ","), strings.Split(cfg.NamespaceExclude, ","))
+	labelSelector, _ := selector.Parse(cfg.LabelSelector)
+	excludeAnnotation, _ := selector.ParseAnnotation(cfg.ExcludeAnnotation)
+	labelFilter, _ := common.NewLabelFilter(strings.Split(cfg.LabelAllow, ","), strings.Split(cfg.LabelDeny, ","), cfg.LabelKeepInternal)
+	labelColumns, _ := common.NewLabelColumns(strings.Split(cfg.LabelColumns, ","))
+	var anonymizer *anonymize.Anonymizer
+	if cfg.Anonymize {
+		if anonymizer, err = anonymize.New(cfg.AnonymizeKeyFile, cfg.AnonymizeMappingFile, cfg.AnonymizeLabelValues); err != nil {
+			log.Fatal(err)
 		}
+		anonymizer.AddLabelColumns(labelColumns.Columns())
 	}
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_DEBUG"); ok {
-		debugTemp, err := strconv.ParseBool(tempEnvVar)
-		if err == nil {
-			debug = debugTemp
-		}
-	}
-
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_CONFIGFILE"); ok {
-		configFile = tempEnvVar
-	}
-
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_CONFIGPATH"); ok {
-		configPath = tempEnvVar
-	}
+	promURL := cfg.Protocol + "://" + cfg.Address + ":" + cfg.Port
+	params = &common.Parameters{
 
-	if tempEnvVar, ok := os.LookupEnv("PROMETHEUS_INCLUDE"); ok {
-		include = tempEnvVar
+		ClusterName:            &cfg.ClusterName,
+		PromAddress:            &cfg.Address,
+		PromURL:                &promURL,
+		Interval:               &cfg.Interval,
+		IntervalSize:           intervalSize,
+		History:                &history,
+		Offset:                 offset,
+		Logger:                 logger,
+		SampleRate:             sampleRate,
+		RateWindow:             model.Duration(rateWindow).String(),
+		OAuthTokenPath:         cfg.OAuthToken,
+		CaCertPath:             cfg.CACert,
+		OAuth2TokenURL:         cfg.OAuth2TokenURL,
+		OAuth2ClientID:         cfg.OAuth2ClientID,
+		OAuth2ClientSecretPath: cfg.OAuth2ClientSecret,
+		OAuth2Scopes:           parseScopes(cfg.OAuth2Scopes),
+		OutputDir:              dataDir,
+		Metrics:                selfmetrics.New(),
+		StartTime:              startTime,
+		MaxCatchUp:             maxCatchUp,
+		NamespaceMatcher:       namespaceMatcher,
+		NamespaceFilterNodes:   cfg.NamespaceFilterNodes,
+		Selector:               labelSelector,
+		ExcludeAnnotation:      excludeAnnotation,
+		LabelFilter:            labelFilter,
+		LabelColumns:           labelColumns,
+		Anonymizer:             anonymizer,
 	}
+	checkAuthFiles(params)
+}
 
-	if tempEnvVar, ok := os.LookupEnv("OAUTH_TOKEN"); ok {
-		oAuthTokenPath = tempEnvVar
+//timeWindow parses the start and end of an absolute time window to collect and returns them along with the number of history windows of the interval size needed to cover it.
+//If only the start is given the window ends now and if only the end is given it starts the usual history before the end.
+func timeWindow(startString, endString string, size time.Duration, history int) (time.Time, time.Time, int, error) {
+	start, end := time.Time{}, time.Now().UTC()
+	var err error
+	if endString != "" {
+		if end, err = time.Parse(time.RFC3339, endString); err != nil {
+			return start, end, 0, fmt.Errorf("invalid end %q, it must be in RFC3339 format e.g. 2020-01-02T15:00:00Z", endString)
+		}
+		end = end.UTC()
+		if end.After(time.Now()) {
+			return start, end, 0, fmt.Errorf("end %s is in the future", endString)
+		}
 	}
-
-	if tempEnvVar, ok := os.LookupEnv("CA_CERT"); ok {
-		caCertPath = tempEnvVar
+	if startString == "" {
+		return end.Add(-size * time.Duration(history)), end, history, nil
 	}
+	if start, err = time.Parse(time.RFC3339, startString); err != nil {
+		return start, end, 0, fmt.Errorf("invalid start %q, it must be in RFC3339 format e.g. 2020-01-02T15:00:00Z", startString)
+	}
+	start = start.UTC()
+	if !start.Before(end) {
+		return start, end, 0, fmt.Errorf("start %s must be before end %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+	windows := int((end.Sub(start) + size - 1) / size)
+	return start, end, windows, nil
+}
 
-	//Get the settings passed in from the command line and update the variables as required.
-	flag.StringVar(&clusterNameTemp, "clusterName", clusterName, "Name of the cluster to show in Densify")
-	flag.StringVar(&promProtocolTemp, "protocol", promProtocol, "Which protocol to use http|https")
-	flag.StringVar(&promAddrTemp, "address", promAddr, "Name of the Prometheus Server")
-	flag.StringVar(&promPortTemp, "port", promPort, "Prometheus Port")
-	flag.StringVar(&intervalTemp, "interval", interval, "Interval to use for data collection. Can be days, hours or minutes")
-	flag.IntVar(&intervalSizeTemp, "intervalSize", intervalSize, "Interval size to be used for querying. eg. default of 1 with default interval of hours queries 1 last hour of info")
-	flag.IntVar(&historyTemp, "history", history, "Amount of time to go back for data collection works with the interval and intervalSize settings")
-	flag.IntVar(&offsetTemp, "offset", offset, "Amount of units (based on interval value) to offset the data collection backwards in time")
-	flag.IntVar(&sampleRateTemp, "sampleRate", sampleRate, "Rate of sample points to collect. default is 5 for 1 sample for every 5 minutes.")
-	flag.BoolVar(&debugTemp, "debug", debug, "Enable debug logging")
-	flag.StringVar(&configFile, "file", configFile, "Name of the config file without extention. Default config")
-	flag.StringVar(&configPath, "path", configPath, "Path to where the config file is stored")
-	flag.StringVar(&includeTemp, "includeList", include, "Comma separated list of data to include in collection (cluster, node, container) Ex: \"node,cluster\"")
-	flag.StringVar(&oAuthTokenPathTemp, "oAuthToken", oAuthTokenPath, "Path to oAuth token file required to authenticate with the Cluster where Prometheus is running.")
-	flag.StringVar(&caCertPathTemp, "caCert", caCertPath, "Path to CA certificate required to pass certificate validation if using HTTPS")
-	flag.Parse()
-
-	//Set defaults for viper to use if setting not found in the config.properties file.
-	if configFile != "" {
-
-		viper.SetDefault("cluster_name", clusterName)
-		viper.SetDefault("prometheus_protocol", promProtocol)
-		viper.SetDefault("prometheus_address", promAddr)
-		viper.SetDefault("prometheus_port", promPort)
-		viper.SetDefault("interval", interval)
-		viper.SetDefault("interval_size", intervalSize)
-		viper.SetDefault("sample_rate", sampleRate)
-		viper.SetDefault("history", history)
-		viper.SetDefault("offset", offset)
-		viper.SetDefault("debug", debug)
-		viper.SetDefault("include_list", include)
-		viper.SetDefault("prometheus_oauth_token", oAuthTokenPath)
-		viper.SetDefault("ca_certificate", caCertPath)
-		// Config import setup.
-		viper.SetConfigName(configFile)
-		viper.AddConfigPath(configPath)
-		err := viper.ReadInConfig()
-		if err == nil {
+//withinRetention checks that the start of the absolute time window is still held by Prometheus. The check is skipped with a warning if the retention can't be found.
+func withinRetention(args *common.Parameters) bool {
+	retention, err := common.Retention(args)
+	if err != nil {
+		args.Logger.Warn("Unable to check the start is within the Prometheus retention: " + err.Error())
+		return true
+	}
+	if oldest := time.Now().Add(-retention); retention != 0 && args.StartTime.Before(oldest) {
+		args.Logger.Error("Start " + args.StartTime.Format(time.RFC3339) + " is before the Prometheus retention of " + model.Duration(retention).String() + ", the oldest data is from around " + oldest.UTC().Format(time.RFC3339))
+		return false
+	}
+	return true
+}
 
-			//Process the config.properties file update the variables as required.
-			clusterName = viper.GetString("cluster_name")
-			promProtocol = viper.GetString("prometheus_protocol")
-			promAddr = viper.GetString("prometheus_address")
-			promPort = viper.GetString("prometheus_port")
-			interval = viper.GetString("interval")
-			intervalSize = viper.GetInt("interval_size")
-			sampleRate = viper.GetInt("sample_rate")
-			history = viper.GetInt("history")
-			offset = viper.GetInt("offset")
-			debug = viper.GetBool("debug")
-			include = viper.GetString("include_list")
-			oAuthTokenPath = viper.GetString("prometheus_oauth_token")
-			caCertPath = viper.GetString("ca_certificate")
-		}
-	}
-
-	visitor := func(a *flag.Flag) {
-		switch a.Name {
-		case "clusterName":
-			clusterName = clusterNameTemp
-		case "protocol":
-			promProtocol = promProtocolTemp
-		case "address":
-			promAddr = promAddrTemp
-		case "port":
-			promPort = promPortTemp
-		case "interval":
-			interval = intervalTemp
-		case "intervalSize":
-			intervalSize = intervalSizeTemp
-		case "sampleRate":
-			sampleRate = sampleRateTemp
-		case "history":
-			history = historyTemp
-		case "offset":
-			offset = offsetTemp
-		case "debug":
-			debug = debugTemp
-		case "includeList":
-			include = includeTemp
-		case "oAuthToken":
-			oAuthTokenPath = oAuthTokenPathTemp
-		case "caCert":
-			caCertPath = caCertPathTemp
-		}
-	}
-
-	flag.Visit(visitor)
-
-	promURL := promProtocol + "://" + promAddr + ":" + promPort
-
-	logFile, err := os.OpenFile("./data/log.txt", os.O_WRONLY|os.O_CREATE, 0644)
+//newLogger returns the logger for the log format, level and output. The log file is written to the report directory.
+func newLogger(debug bool) (*logging.Logger, error) {
+	level, err := logging.ParseLevel(logLevel)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	if debug {
+		level = logging.DebugLevel
+	}
+
+	var out io.Writer
+	switch logOutput {
+	case "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	case "file", "both":
+		logFile, err := os.OpenFile(reportDir+"/log.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, err
+		}
+		out = logFile
+		if logOutput == "both" {
+			out = io.MultiWriter(logFile, os.Stdout)
+		}
+	default:
+		return nil, fmt.Errorf("invalid log output %q, it must be %s", logOutput, logOutputList)
 	}
+	return logging.New(out, logFormat, level)
+}
 
-	var infoLogger, warnLogger, errorLogger, debugLogger *log.Logger
+//checkAuthFiles makes sure the token, certificate and secret files exist and otherwise clears the setting so the collection is attempted without it.
+func checkAuthFiles(args *common.Parameters) {
+	if args.OAuthTokenPath != "" {
+		if _, err := os.Stat(args.OAuthTokenPath); os.IsNotExist(err) {
+			args.Logger.Info(args.OAuthTokenPath + " does not exist. Attempting to execute without using oAuth token!")
+			args.OAuthTokenPath = ""
+		}
+	}
 
-	infoLogger = log.New(logFile, "[INFO] ", log.Ldate|log.Ltime|log.Lshortfile)
-	warnLogger = log.New(logFile, "[WARN] ", log.Ldate|log.Ltime|log.Lshortfile)
-	errorLogger = log.New(logFile, "[ERROR] ", log.Ldate|log.Ltime|log.Lshortfile)
-	debugLogger = log.New(logFile, "[DEBUG] ", log.Ldate|log.Ltime|log.Lshortfile)
+	if args.CaCertPath != "" {
+		if _, err := os.Stat(args.CaCertPath); os.IsNotExist(err) {
+			args.Logger.Info(args.CaCertPath + " does not exist. Attempting to execute without trusted CA Certificate configuration!")
+			args.CaCertPath = ""
+		}
+	}
 
-	// Check if token and certificate are missing
-	if oAuthTokenPath != "" {
-		if _, err := os.Stat(oAuthTokenPath); os.IsNotExist(err) {
-			fmt.Printf("[INFO] %s does not exist. Attempting to execute without using oAuth token!\n", oAuthTokenPath)
-			infoLogger.Printf("%s does not exist. Attempting to execute without using oAuth token!\n", oAuthTokenPath)
-			oAuthTokenPath = ""
+	if args.OAuth2TokenURL != "" {
+		if _, err := os.Stat(args.OAuth2ClientSecretPath); os.IsNotExist(err) {
+			args.Logger.Info(args.OAuth2ClientSecretPath + " does not exist. Attempting to execute without using OAuth2 client credentials!")
+			args.OAuth2TokenURL = ""
+		} else if args.OAuthTokenPath != "" {
+			args.Logger.Info("OAuth2 client credentials are configured so the oAuth token will not be used.")
+			args.OAuthTokenPath = ""
 		}
 	}
+}
 
-	if caCertPath != "" {
-		if _, err := os.Stat(caCertPath); os.IsNotExist(err) {
-			fmt.Printf("[INFO] %s does not exist. Attempting to execute without trusted CA Certificate configuration!\n", caCertPath)
-			infoLogger.Printf("%s does not exist. Attempting to execute without trusted CA Certificate configuration!\n", caCertPath)
-			caCertPath = ""
+//parseScopes splits the comma separated list of OAuth2 scopes.
+func parseScopes(param string) []string {
+	var scopes []string
+	for _, scope := range strings.Split(param, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
 		}
 	}
+	return scopes
+}
 
-	if clusterName == "" {
-		clusterName = promAddr
+//resolveClusterName sets the cluster name if it isn't set. It tries to find it in Prometheus before falling back to the address, otherwise every cluster using the same Prometheus service name looks the same in Densify.
+func resolveClusterName(args *common.Parameters) {
+	if *args.ClusterName == "" {
+		*args.ClusterName = common.DetectClusterName(args, clusterLabel)
 	}
+	if *args.ClusterName == "" {
+		*args.ClusterName = *args.PromAddress
+	}
+}
 
-	params = &common.Parameters{
+//loadTargets reads the list of Prometheus servers to collect from the targets file.
+func loadTargets(path string) ([]target, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var targets []target
+	if err := yaml.UnmarshalStrict(data, &targets); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", path, err)
+	}
+	return targets, nil
+}
 
-		ClusterName:      &clusterName,
-		PromAddress:      &promAddr,
-		PromURL:          &promURL,
-		Interval:         &interval,
-		IntervalSize:     &intervalSize,
-		History:          &history,
-		Offset:           &offset,
-		Debug:            debug,
-		InfoLogger:       infoLogger,
-		WarnLogger:       warnLogger,
-		ErrorLogger:      errorLogger,
-		DebugLogger:      debugLogger,
-		SampleRate:       sampleRate,
-		SampleRateString: strconv.Itoa(sampleRate),
-		OAuthTokenPath:   oAuthTokenPath,
-		CaCertPath:       caCertPath,
+//targetParameters returns the parameters to collect the target, based on the parameters of the run with the settings of the target applied.
+func targetParameters(base *common.Parameters, t target) *common.Parameters {
+	args := base.Copy()
+	*args.ClusterName = t.ClusterName
+
+	//The address is required for each target while the protocol and port default to the main configuration.
+	u, err := url.Parse(*base.PromURL)
+	if err != nil {
+		u = &url.URL{}
 	}
-	parseIncludeParam(include)
+	protocol, port := u.Scheme, u.Port()
+	if t.PromProtocol != "" {
+		protocol = t.PromProtocol
+	}
+	if t.PromPort != "" {
+		port = t.PromPort
+	}
+	*args.PromAddress = t.PromAddr
+	*args.PromURL = protocol + "://" + t.PromAddr + ":" + port
+
+	if t.OAuthTokenPath != "" {
+		args.OAuthTokenPath = t.OAuthTokenPath
+	}
+	if t.CaCertPath != "" {
+		args.CaCertPath = t.CaCertPath
+	}
+	if t.OAuth2TokenURL != "" {
+		args.OAuth2TokenURL = t.OAuth2TokenURL
+		args.OAuth2ClientID = t.OAuth2ClientID
+		args.OAuth2ClientSecretPath = t.OAuth2ClientSecretPath
+		args.OAuth2Scopes = parseScopes(t.OAuth2Scopes)
+	}
+	checkAuthFiles(args)
+	return args
 }
 
 func parseIncludeParam(param string) {
+	includeContainer, includeNamespace, includeNode, includeNodeGroup, includeCluster = false, false, false, false, false
 	param = strings.ToLower(param)
 	for _, elem := range strings.Split(param, ",") {
 		if strings.Compare(elem, "cluster") == 0 {
@@ -284,6 +412,8 @@ func parseIncludeParam(param string) {
 			includeContainer = true
 		} else if strings.Compare(elem, "nodegroup") == 0 {
 			includeNodeGroup = true
+		} else if strings.Compare(elem, "namespace") == 0 {
+			includeNamespace = true
 		}
 	}
 }
@@ -293,44 +423,408 @@ func main() {
 
 	//Read in the command line and config file parameters and set the required variables.
 	initParameters()
-	params.InfoLogger.Println("Version 2.2.1")
-	fmt.Println("Version 2.2.1")
-
-	//Get the current time in UTC and format it. The script uses this time for all the queries this way if you have a large environment we are collecting the data as a snapshot of a specific time and not potentially getting a misaligned set of data.
-	var t time.Time
-	t = time.Now().UTC()
-	var currentTime time.Time
-	if *params.Interval == "days" {
-		currentTime = time.Date(t.Year(), t.Month(), t.Day()-*params.Offset, 0, 0, 0, 0, t.Location())
-	} else if *params.Interval == "hours" {
-		currentTime = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()-*params.Offset, 0, 0, 0, t.Location())
+	params.Logger.Info("Version " + version)
+
+	if dryRun {
+		os.Exit(planRun(time.Now().UTC()))
+	}
+
+	if listenAddress != "" {
+		go serveMetrics()
+	}
+
+	if daemon {
+		runDaemon()
+		return
+	}
+
+	//Get the current time in UTC. The run uses this time for all the queries this way if you have a large environment we are collecting the data as a snapshot of a specific time and not potentially getting a misaligned set of data.
+	ctx, cancel := signalContext()
+	code := run(ctx, time.Now().UTC())
+	cancel()
+	os.Exit(code)
+}
+
+//signalContext returns a context that is cancelled on SIGINT or SIGTERM, so the collection stops running queries and finishes writing the files it has started before exiting.
+//A second signal exits straight away.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			params.Logger.Warn("Received " + sig.String() + ", stopping the collection")
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+		sig := <-signals
+		params.Logger.Error("Received " + sig.String() + " again, exiting")
+		os.Exit(summary.FailureCode)
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+//run collects the configured Prometheus servers once, using t aligned to the start of the interval as the time of the collection. Cancelling ctx stops the queries that are still to run.
+//The run summary is written to the output directory and the exit code of the run returned.
+func run(ctx context.Context, t time.Time) int {
+	if runTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, runTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	params.Metrics.StartRun()
+	runSummary := summary.New(version)
+	currentTime := common.AlignTime(t, *params.Interval, params.Offset)
+	if !endTime.IsZero() {
+		//An absolute time window is collected as given rather than aligned to the interval.
+		currentTime = endTime
+	}
+	args := params.NewRun(ctx, currentTime)
+	args.Summary = runSummary
+	args.Logger = params.Logger.WithHook(summaryHook(runSummary))
+	if backfill {
+		args.Checkpoint = loadCheckpoint(args)
+	}
+	if stateFile != "" {
+		marks, err := watermark.Load(stateFile, stateConfigMap)
+		if err != nil {
+			args.Logger.Error("Unable to read the state file " + stateFile + ", collecting the history: " + err.Error())
+			marks = watermark.New(stateFile, stateConfigMap)
+		}
+		args.Watermarks = marks
+	}
+
+	ok := collectTargets(args) && ctx.Err() == nil
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		runSummary.Interrupt("run timeout of " + runTimeout.String() + " exceeded")
+		params.Logger.Error("Collection stopped as the run timeout of " + runTimeout.String() + " was exceeded")
+	case context.Canceled:
+		runSummary.Interrupt("cancelled")
+	}
+	runSummary.Finish(ok, missingOptionalAsWarning)
+	if err := runSummary.Write(reportDir + "/" + summary.FileName); err != nil {
+		params.Logger.Error("Unable to write the run summary: " + err.Error())
+	}
+	params.Logger.Info("Collection "+runSummary.Status, "duration", time.Since(start))
+	if backfill {
+		finishBackfill(args.Checkpoint, ctx.Err() == nil)
+	}
+	if err := args.Watermarks.Save(); err != nil {
+		params.Logger.Error("Unable to save the state file " + stateFile + ": " + err.Error())
+	}
+	if err := args.Anonymizer.Save(); err != nil {
+		params.Logger.Error("Unable to save the anonymization mapping file: " + err.Error())
+	}
+
+	params.Metrics.EndRun(start, runSummary.Status == summary.Success)
+	exportMetrics()
+	return runSummary.ExitCode
+}
+
+//planRun plans a collection at t without running it. The queries are recorded rather than sent to Prometheus, then printed or written as JSON to the dry run output. The files are written to a temporary directory that is removed afterwards and the state file is read but not saved.
+//A backfill is planned from the start, without the windows completed in its checkpoint.
+func planRun(t time.Time) int {
+	dir, err := ioutil.TempDir("", "dataCollection-dry-run")
+	if err != nil {
+		params.Logger.Error("Unable to create the directory of the dry run: " + err.Error())
+		return summary.FailureCode
+	}
+	defer os.RemoveAll(dir)
+	params.OutputDir = dir
+
+	currentTime := common.AlignTime(t, *params.Interval, params.Offset)
+	if !endTime.IsZero() {
+		currentTime = endTime
+	}
+	args := params.NewRun(context.Background(), currentTime)
+	args.Plan = plan.New(dryRunDetect)
+	if stateFile != "" {
+		marks, err := watermark.Load(stateFile, stateConfigMap)
+		if err != nil {
+			args.Logger.Warn("Unable to read the state file " + stateFile + ", planning the history: " + err.Error())
+		}
+		args.Watermarks = marks
+	}
+	collectTargets(args)
+
+	if dryRunOutput == "" {
+		args.Plan.Print(os.Stdout)
+	} else if err := args.Plan.Write(dryRunOutput); err != nil {
+		params.Logger.Error("Unable to write the plan: " + err.Error())
+		return summary.FailureCode
 	} else {
-		currentTime = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()-*params.Offset, 0, 0, t.Location())
+		params.Logger.Info("Plan of " + strconv.Itoa(len(args.Plan.Queries)) + " queries written to " + dryRunOutput)
+	}
+	return summary.SuccessCode
+}
+
+//loadCheckpoint returns the checkpoint of the backfill, resuming the checkpoint of an earlier backfill of the same window. Without an end the window of a backfill ends when it was first started, so a resumed backfill keeps the window of the checkpoint rather than moving it to now.
+func loadCheckpoint(args *common.Parameters) *checkpoint.Checkpoint {
+	window := checkpoint.Window{
+		Start:        args.StartTime,
+		End:          *args.CurrentTime,
+		History:      *args.History,
+		Interval:     *args.Interval,
+		IntervalSize: args.IntervalSize,
+		SampleRate:   args.SampleRate,
+		RateWindow:   args.RateWindow,
+	}
+	cp, err := checkpoint.Load(backfillCheckpoint)
+	if err != nil {
+		args.Logger.Warn("Unable to read the backfill checkpoint " + backfillCheckpoint + ", starting the backfill again: " + err.Error())
+	}
+	if cp == nil {
+		args.Logger.Info("Starting the backfill of " + strconv.Itoa(window.History) + " windows to " + window.End.Format(time.RFC3339))
+		return checkpoint.New(backfillCheckpoint, window)
+	}
+
+	if endTime.IsZero() {
+		window.End = cp.Window.End
+		if !startTime.IsZero() {
+			window.History = cp.Window.History
+		}
+	}
+	if !window.Equal(cp.Window) {
+		args.Logger.Warn("The backfill checkpoint " + backfillCheckpoint + " is for a different window, starting the backfill again")
+		return checkpoint.New(backfillCheckpoint, window)
+	}
+	args.Logger.Info("Resuming the backfill to " + window.End.Format(time.RFC3339) + " from the checkpoint " + backfillCheckpoint)
+	*args.CurrentTime = window.End
+	*args.History = window.History
+	return cp
+}
+
+//finishBackfill removes the checkpoint once the backfill has run through all the windows and written them all, otherwise it is kept so running the backfill again resumes it and collects the windows that are missing.
+func finishBackfill(cp *checkpoint.Checkpoint, finished bool) {
+	if !finished {
+		params.Logger.Warn("Backfill stopped before it finished, run it again to resume from the checkpoint " + backfillCheckpoint)
+		return
+	}
+	if failed := cp.Failed(); failed > 0 {
+		params.Logger.Warn(strconv.Itoa(failed) + " windows of the backfill couldn't be collected, run it again to collect them from the checkpoint " + backfillCheckpoint)
+		return
+	}
+	if err := cp.Remove(); err != nil {
+		params.Logger.Error("Unable to remove the backfill checkpoint: " + err.Error())
+	}
+	params.Logger.Info("Backfill finished")
+}
+
+//summaryHook returns the log hook that adds the warnings and errors to the run summary. Records about queries are left out as the queries that failed or returned no data are reported on their own.
+func summaryHook(s *summary.Summary) logging.Hook {
+	return func(level logging.Level, msg string, fields []interface{}) {
+		if level < logging.WarnLevel {
+			return
+		}
+		for i := 0; i < len(fields); i += 2 {
+			if fields[i] == "query" {
+				return
+			}
+		}
+		for i := len(fields) - 2; i >= 0; i -= 2 {
+			msg = fmt.Sprint(fields[i]) + "=" + fmt.Sprint(fields[i+1]) + " " + msg
+		}
+		if level == logging.WarnLevel {
+			s.Warning(msg)
+		} else {
+			s.Error(msg)
+		}
+	}
+}
+
+//collectTargets collects the Prometheus servers in the targets file, or the configured Prometheus if there isn't one. It returns false if any of them couldn't be collected.
+func collectTargets(args *common.Parameters) bool {
+	if targetsFile == "" {
+		return collectTarget(args, includeList, false)
 	}
-	params.CurrentTime = &currentTime
 
+	targets, err := loadTargets(targetsFile)
+	if err != nil {
+		args.Logger.Error(err.Error())
+		return false
+	}
+	ok := true
+	for _, t := range targets {
+		if t.PromAddr == "" {
+			args.Logger.Error("Skipping target " + t.ClusterName + " as it has no prometheus_address")
+			ok = false
+			continue
+		}
+		include := includeList
+		if t.Include != "" {
+			include = t.Include
+		}
+		args.Logger.Info("Collecting Prometheus " + t.PromAddr)
+		if !collectTarget(targetParameters(args, t), include, true) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+//collectTarget runs the data collection for one Prometheus, once per cluster if multi cluster is enabled. If several clusters are collected in the run and the target output is separate each cluster is written to its own directory.
+//It returns false if any of the clusters couldn't be collected.
+func collectTarget(args *common.Parameters, include string, severalTargets bool) bool {
+	parseIncludeParam(include)
+	if !args.StartTime.IsZero() && !withinRetention(args) {
+		return false
+	}
+
+	if !multiCluster {
+		resolveClusterName(args)
+		if severalTargets && targetOutput == "separate" {
+			args.OutputDir = clusterDir(*args.ClusterName)
+		}
+		return collect(args)
+	}
+
+	clusters := common.ClusterNames(args, clusterLabel)
+	if len(clusters) == 0 {
+		args.Logger.Error("No values found for cluster label " + clusterLabel + ", nothing to collect")
+		return false
+	}
+	ok := true
+	for _, cluster := range clusters {
+		args.Logger.Info("Collecting cluster " + cluster)
+		*args.ClusterName = cluster
+		args.ClusterMatcher = clusterLabel + "=" + strconv.Quote(cluster)
+		if targetOutput == "separate" {
+			args.OutputDir = clusterDir(cluster)
+		}
+		if !collect(args) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+//clusterDir returns the output directory for the cluster when each cluster is written separately. The clusters are kept under a clusters directory so they can't collide with the directories of the entities.
+func clusterDir(cluster string) string {
+	return params.OutputDir + "/clusters/" + strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(params.Anonymizer.Name("cluster", cluster))
+}
+
+//collect runs the data collection for the levels that are included. It returns false if the containers or nodes couldn't be collected or the run was cancelled.
+//Once the run is cancelled the levels that are still to run are skipped. What was collected of a level that was running when the run was cancelled is still written, so its files are complete, but the level is reported as incomplete in the run summary.
+func collect(params *common.Parameters) bool {
+	ok := true
+	params.Summary.AddCluster(*params.ClusterName)
 	if includeContainer {
-		container2.Metrics(params)
+		if interrupted(params, "container") {
+			ok = false
+		} else if result := container2.NewCollector(params).Collect(); result != nil {
+			container2.Write(params, result)
+			//When the namespaces are collected as entities of their own they are counted by the namespace level.
+			if !includeNamespace {
+				params.Summary.AddEntities("namespace", len(result.Namespaces))
+			}
+			params.Summary.AddEntities("hpa", len(result.HPAs))
+			for _, ns := range result.Namespaces {
+				params.Summary.AddEntities("controller", len(ns.Controllers))
+				for _, controller := range ns.Controllers {
+					params.Summary.AddEntities("container", len(controller.Containers))
+				}
+			}
+			ok = !interrupted(params, "container") && ok
+		} else {
+			interrupted(params, "container")
+			ok = false
+		}
 	} else {
-		params.InfoLogger.Println("Skipping container data collection")
-		fmt.Println("Skipping container data collection")
+		params.Logger.Info("Skipping container data collection", "entity", "container")
+	}
+	if includeNamespace {
+		if interrupted(params, "namespace") {
+			ok = false
+		} else if namespaces, workloads := namespace.NewCollector(params).Collect(); namespaces != nil {
+			namespace.Write(params, namespaces, workloads)
+			params.Summary.AddEntities("namespace", len(namespaces))
+			ok = !interrupted(params, "namespace") && ok
+		} else {
+			interrupted(params, "namespace")
+			ok = false
+		}
+	} else {
+		params.Logger.Info("Skipping namespace data collection", "entity", "namespace")
 	}
 	if includeNode {
-		node.Metrics(params)
+		if interrupted(params, "node") {
+			ok = false
+		} else if nodes, workloads := node.NewCollector(params).Collect(); nodes != nil {
+			node.Write(params, nodes, workloads)
+			params.Summary.AddEntities("node", len(nodes))
+			ok = !interrupted(params, "node") && ok
+		} else {
+			interrupted(params, "node")
+			ok = false
+		}
 	} else {
-		params.InfoLogger.Println("Skipping node data collection")
-		fmt.Println("Skipping node data collection")
+		params.Logger.Info("Skipping node data collection", "entity", "node")
 	}
 	if includeNodeGroup {
-		nodegroup.Metrics(params)
+		if interrupted(params, "nodegroup") {
+			ok = false
+		} else {
+			if nodeGroups, workloads := nodegroup.NewCollector(params).Collect(); nodeGroups != nil {
+				nodegroup.Write(params, nodeGroups, workloads)
+				params.Summary.AddEntities("nodegroup", len(nodeGroups))
+			}
+			ok = !interrupted(params, "nodegroup") && ok
+		}
 	} else {
-		params.InfoLogger.Println("Skipping node group data collection")
-		fmt.Println("Skipping node group data collection")
+		params.Logger.Info("Skipping node group data collection", "entity", "nodegroup")
 	}
 	if includeCluster {
-		cluster.Metrics(params)
+		if interrupted(params, "cluster") {
+			ok = false
+		} else {
+			c, workloads := cluster.NewCollector(params).Collect()
+			cluster.Write(params, c, workloads)
+			//The cluster is always written, it only counts as collected if some of its metrics were found.
+			if c.CPULimit != -1 || c.CPURequest != -1 || c.MemLimit != -1 || c.MemRequest != -1 {
+				params.Summary.AddEntities("cluster", 1)
+			}
+			ok = !interrupted(params, "cluster") && ok
+		}
 	} else {
-		params.InfoLogger.Println("Skipping cluster data collection")
-		fmt.Println("Skipping cluster data collection")
+		params.Logger.Info("Skipping cluster data collection", "entity", "cluster")
+	}
+	return ok
+}
+
+//interrupted returns true if the run has been cancelled, recording the level of the cluster as incomplete in the run summary.
+func interrupted(params *common.Parameters, level string) bool {
+	if params.Context == nil || params.Context.Err() == nil {
+		return false
+	}
+	params.Summary.AddIncomplete(*params.ClusterName + "/" + level)
+	return true
+}
+
+//serveMetrics serves the health checks and self metrics on the listen address for as long as the process runs.
+func serveMetrics() {
+	params.Logger.Info("Serving health checks and metrics on " + listenAddress)
+	if err := http.ListenAndServe(listenAddress, params.Metrics.Handler()); err != nil {
+		params.Logger.Error("Unable to serve health checks and metrics: " + err.Error())
+	}
+}
+
+//exportMetrics writes the self metrics to the textfile and pushes them to the Pushgateway if they are configured, so the metrics of one-shot runs can be scraped after the process exits.
+func exportMetrics() {
+	if metricsTextfile != "" {
+		if err := params.Metrics.WriteTextfile(metricsTextfile); err != nil {
+			params.Logger.Error("Unable to write metrics to " + metricsTextfile + ": " + err.Error())
+		}
+	}
+	if pushgatewayURL != "" {
+		if err := params.Metrics.Push(pushgatewayURL, "dataCollection"); err != nil {
+			params.Logger.Error("Unable to push metrics to " + pushgatewayURL + ": " + err.Error())
+		}
 	}
 }
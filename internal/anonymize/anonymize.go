@@ -0,0 +1,272 @@
+//Package anonymize replaces the names of the clusters, namespaces, controllers, pods, containers, nodes and node groups in the csv files with pseudonyms, so the data can be shared without them.
+//The pseudonyms are a keyed HMAC of the names, so a name gets the same pseudonym in every file and run, and the names behind them are kept in a local mapping file to translate the recommendations back.
+package anonymize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//pseudonymLength is the number of hex digits of the HMAC kept for a pseudonym.
+const pseudonymLength = 16
+
+//labelsColumn and listColumn are the kinds of the columns holding the labels of an entity (key : value|...) and lists of node names.
+const (
+	labelsColumn = "labels"
+	listColumn   = "nodes"
+)
+
+//columns are the kinds of names held by the columns of the csv files, by header.
+var columns = map[string]string{
+	"cluster":          "cluster",
+	"Virtual Domain":   "cluster",
+	"namespace":        "namespace",
+	"entity_name":      "controller",
+	"Created By Name":  "controller",
+	"container":        "container",
+	"Container Name":   "container",
+	"HPA Name":         "hpa",
+	"node":             "node",
+	"node_group":       "node group",
+	"Current Nodes":    listColumn,
+	"Container Labels": labelsColumn,
+	"Pod Labels":       labelsColumn,
+	"Namespace Labels": labelsColumn,
+	"Node Labels":      labelsColumn,
+	"Labels":           labelsColumn,
+}
+
+//labelNames are the kinds of names held by the labels written to the attributes. The values of the other labels are only replaced when the label values are anonymized.
+var labelNames = map[string]string{
+	"cluster":               "cluster",
+	"namespace":             "namespace",
+	"exported_namespace":    "namespace",
+	"pod":                   "pod",
+	"exported_pod":          "pod",
+	"pod_name":              "pod",
+	"container":             "container",
+	"exported_container":    "container",
+	"container_name":        "container",
+	"name":                  "container",
+	"node":                  "node",
+	"owner_name":            "controller",
+	"created_by_name":       "controller",
+	"replicaset":            "controller",
+	"deployment":            "controller",
+	"statefulset":           "controller",
+	"daemonset":             "controller",
+	"replicationcontroller": "controller",
+	"job_name":              "controller",
+	"cronjob":               "controller",
+	"hpa":                   "hpa",
+}
+
+//Anonymizer gives the pseudonyms of the names and records them for the mapping file. The methods are safe to call on a nil Anonymizer, which leaves the names as they are.
+type Anonymizer struct {
+	key         []byte
+	labelValues bool
+	mappingFile string
+	//labelColumns are the kinds of names held by the columns the labels are mapped to.
+	labelColumns map[string]string
+
+	mu sync.Mutex
+	//names holds the name behind each pseudonym by kind.
+	names map[string]map[string]string
+}
+
+//New reads the secret key from keyFile and the pseudonyms already given from the mapping file, if it exists. The values of all the labels are replaced as well when labelValues is set.
+func New(keyFile, mappingFile string, labelValues bool) (*Anonymizer, error) {
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key = []byte(strings.TrimSpace(string(key)))
+	if len(key) == 0 {
+		return nil, errors.New("the anonymization key file " + keyFile + " is empty")
+	}
+	a := &Anonymizer{key: key, labelValues: labelValues, mappingFile: mappingFile, labelColumns: map[string]string{}, names: map[string]map[string]string{}}
+	file, err := os.Open(mappingFile)
+	if os.IsNotExist(err) {
+		return a, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, errors.New("invalid anonymization mapping file " + mappingFile + ": " + err.Error())
+	}
+	for i, record := range records {
+		if i == 0 || len(record) != 3 {
+			continue
+		}
+		a.record(record[0], record[1], record[2])
+	}
+	return a, nil
+}
+
+func (a *Anonymizer) record(kind, name, pseudonym string) {
+	if _, ok := a.names[kind]; !ok {
+		a.names[kind] = map[string]string{}
+	}
+	a.names[kind][pseudonym] = name
+}
+
+//Name returns the pseudonym of the name. The kind, e.g. namespace, is only recorded in the mapping file as the same name gets the same pseudonym whatever its kind, so the columns that refer to each other, e.g. the HPA Name and the entity_name, still match.
+func (a *Anonymizer) Name(kind, name string) string {
+	if a == nil || name == "" {
+		return name
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(name))
+	pseudonym := hex.EncodeToString(mac.Sum(nil))[:pseudonymLength]
+	a.mu.Lock()
+	a.record(kind, name, pseudonym)
+	a.mu.Unlock()
+	return pseudonym
+}
+
+//AddLabelColumns adds the columns the labels are mapped to, by the name of their label. Their values are replaced like those of the labels, so only when they hold names or the label values are anonymized.
+func (a *Anonymizer) AddLabelColumns(columns map[string]string) {
+	if a == nil {
+		return
+	}
+	for name, label := range columns {
+		if kind, ok := labelNames[label]; ok {
+			a.labelColumns[name] = kind
+		} else if a.labelValues {
+			a.labelColumns[name] = "label"
+		}
+	}
+}
+
+//Columns returns the kind of name held by each column of the csv file with the header, empty for the columns that are left as they are. It returns nil if the Anonymizer is nil.
+func (a *Anonymizer) Columns(header string) []string {
+	if a == nil {
+		return nil
+	}
+	names := strings.Split(header, ",")
+	kinds := make([]string, len(names))
+	container := false
+	for _, name := range names {
+		container = container || name == "entity_name"
+	}
+	for i, name := range names {
+		kinds[i] = columns[name]
+		if kind, ok := a.labelColumns[name]; ok && kinds[i] == "" {
+			kinds[i] = kind
+		}
+		//The attributes of the containers hold the namespace and controller in the virtual datacenter and cluster, those of the nodes hold the region and zone.
+		if container && name == "Virtual Datacenter" {
+			kinds[i] = "namespace"
+		} else if container && name == "Virtual Cluster" {
+			kinds[i] = "controller"
+		}
+	}
+	return kinds
+}
+
+//Row returns the csv row with the names in the columns replaced by their pseudonyms.
+func (a *Anonymizer) Row(kinds []string, row string) string {
+	if a == nil {
+		return row
+	}
+	fields := strings.Split(row, ",")
+	for i := 0; i < len(fields) && i < len(kinds); i++ {
+		switch kinds[i] {
+		case "":
+		case labelsColumn:
+			fields[i] = a.labels(fields[i])
+		case listColumn:
+			fields[i] = a.list("node", fields[i])
+		default:
+			fields[i] = a.list(kinds[i], fields[i])
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+//list replaces each of the names of a list separated by semicolons or pipes, as used for several nodes or the values of a label gathered from several series.
+func (a *Anonymizer) list(kind, value string) string {
+	var out strings.Builder
+	start := 0
+	for i := 0; i <= len(value); i++ {
+		if i == len(value) || value[i] == ';' || value[i] == '|' {
+			out.WriteString(a.Name(kind, value[start:i]))
+			if i < len(value) {
+				out.WriteByte(value[i])
+			}
+			start = i + 1
+		}
+	}
+	return out.String()
+}
+
+//labels replaces the values of the labels that hold names, or of every label when the label values are anonymized, in a list of labels written as key : value|.
+func (a *Anonymizer) labels(value string) string {
+	pairs := strings.Split(value, "|")
+	for i, pair := range pairs {
+		parts := strings.SplitN(pair, " : ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		kind, ok := labelNames[parts[0]]
+		if !ok && !a.labelValues {
+			continue
+		} else if !ok {
+			kind = "label"
+		}
+		values := strings.Split(parts[1], ";")
+		for j := range values {
+			values[j] = a.Name(kind, values[j])
+		}
+		pairs[i] = parts[0] + " : " + strings.Join(values, ";")
+	}
+	return strings.Join(pairs, "|")
+}
+
+//Save writes the names behind the pseudonyms given so far, including those read from the mapping file, to the mapping file as kind,name,pseudonym. The file is only readable by its owner as it undoes the anonymization.
+func (a *Anonymizer) Save() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	var records [][]string
+	for kind, names := range a.names {
+		for pseudonym, name := range names {
+			records = append(records, []string{kind, name, pseudonym})
+		}
+	}
+	a.mu.Unlock()
+	sort.Slice(records, func(i, j int) bool {
+		if records[i][0] != records[j][0] {
+			return records[i][0] < records[j][0]
+		}
+		return records[i][1] < records[j][1]
+	})
+
+	tmp := a.mappingFile + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	w.Write([]string{"kind", "name", "pseudonym"})
+	w.WriteAll(records)
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.mappingFile)
+}
@@ -0,0 +1,165 @@
+package anonymize
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+//newTestAnonymizer returns an Anonymizer with the key given, its mapping file in a new temporary directory and the function that removes the directory.
+func newTestAnonymizer(t *testing.T, key string, labelValues bool) (*Anonymizer, string, func()) {
+	dir, err := ioutil.TempDir("", "anonymize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/key", []byte(key+"\n"), 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	a, err := New(dir+"/key", dir+"/mapping.csv", labelValues)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return a, dir, func() { os.RemoveAll(dir) }
+}
+
+func TestName(t *testing.T) {
+	a, _, cleanup := newTestAnonymizer(t, "secret", false)
+	defer cleanup()
+	same, _, cleanupSame := newTestAnonymizer(t, "secret", false)
+	defer cleanupSame()
+	other, _, cleanupOther := newTestAnonymizer(t, "other", false)
+	defer cleanupOther()
+
+	pseudonym := a.Name("namespace", "payments")
+	if !regexp.MustCompile(`^[0-9a-f]{16}$`).MatchString(pseudonym) {
+		t.Errorf("pseudonym %q, want 16 hex digits", pseudonym)
+	}
+	//The same key gives the same pseudonym whatever the kind, so the columns that refer to each other still match.
+	if a.Name("namespace", "payments") != pseudonym || same.Name("namespace", "payments") != pseudonym || a.Name("controller", "payments") != pseudonym {
+		t.Error("the same name and key give different pseudonyms")
+	}
+	if other.Name("namespace", "payments") == pseudonym || a.Name("namespace", "orders") == pseudonym {
+		t.Error("another name or key gives the same pseudonym")
+	}
+	if a.Name("namespace", "") != "" {
+		t.Error("an empty name has a pseudonym")
+	}
+	var none *Anonymizer
+	if none.Name("namespace", "payments") != "payments" {
+		t.Error("a nil Anonymizer replaced the name")
+	}
+}
+
+func TestRow(t *testing.T) {
+	header := "cluster,namespace,entity_name,entity_type,container,Virtual Technology,Virtual Datacenter,Virtual Cluster,Current Nodes,Pod Labels,Business Unit,Owner"
+	row := "east,ns1,web,Deployment,app,Containers,ns1,web,n1;n2|n3,namespace : ns1|app : web;api|,payments,web"
+	for _, labelValues := range []bool{false, true} {
+		a, _, cleanup := newTestAnonymizer(t, "secret", labelValues)
+		defer cleanup()
+		a.AddLabelColumns(map[string]string{"Business Unit": "label_team", "Owner": "owner_name"})
+		n := func(name string) string { return a.Name("", name) }
+
+		kinds := a.Columns(header)
+		want := []string{"cluster", "namespace", "controller", "", "container", "", "namespace", "controller", listColumn, labelsColumn, "", "controller"}
+		labels, businessUnit := "namespace : "+n("ns1")+"|app : web;api|", "payments"
+		if labelValues {
+			want[10] = "label"
+			labels, businessUnit = "namespace : "+n("ns1")+"|app : "+n("web")+";"+n("api")+"|", n("payments")
+		}
+		if !reflect.DeepEqual(kinds, want) {
+			t.Errorf("label values %v: columns %q, want %q", labelValues, kinds, want)
+		}
+
+		//Only the columns and label values holding names are replaced, each of the values of a list in turn.
+		wantRow := strings.Join([]string{n("east"), n("ns1"), n("web"), "Deployment", n("app"), "Containers", n("ns1"), n("web"), n("n1") + ";" + n("n2") + "|" + n("n3"), labels, businessUnit, n("web")}, ",")
+		if got := a.Row(kinds, row); got != wantRow {
+			t.Errorf("label values %v: row\n%s\nwant\n%s", labelValues, got, wantRow)
+		}
+	}
+
+	//The nodes only hold their names in the Virtual Datacenter and Virtual Cluster columns of the containers.
+	a, _, cleanup := newTestAnonymizer(t, "secret", false)
+	defer cleanup()
+	if kinds := a.Columns("cluster,node,Virtual Datacenter,Virtual Cluster,Node Labels"); !reflect.DeepEqual(kinds, []string{"cluster", "node", "", "", labelsColumn}) {
+		t.Errorf("node columns %q", kinds)
+	}
+	if got := a.Row([]string{"", "node"}, "a,,b"); got != "a,,b" {
+		t.Errorf("row %q, want the empty name and the columns past the header left as they are", got)
+	}
+
+	var none *Anonymizer
+	if none.Columns(header) != nil || none.Row(nil, row) != row {
+		t.Error("a nil Anonymizer replaced the names")
+	}
+}
+
+func TestSave(t *testing.T) {
+	a, dir, cleanup := newTestAnonymizer(t, "secret", false)
+	defer cleanup()
+	ns, node := a.Name("namespace", "payments"), a.Name("node", "n1,eu")
+	if err := a.Save(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dir + "/mapping.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mapping file mode %s, want -rw-------", info.Mode().Perm())
+	}
+	b, err := ioutil.ReadFile(dir + "/mapping.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "kind,name,pseudonym\nnamespace,payments," + ns + "\nnode,\"n1,eu\"," + node + "\n"; string(b) != want {
+		t.Errorf("mapping file\n%s\nwant\n%s", b, want)
+	}
+
+	//The mapping read back is kept by the next save along with the new names.
+	loaded, err := New(dir+"/key", dir+"/mapping.csv", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.names, a.names) {
+		t.Errorf("loaded mapping %v, want %v", loaded.names, a.names)
+	}
+	container := loaded.Name("container", "app")
+	if err := loaded.Save(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := New(dir+"/key", dir+"/mapping.csv", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.names["namespace"][ns] != "payments" || reloaded.names["node"][node] != "n1,eu" || reloaded.names["container"][container] != "app" {
+		t.Errorf("reloaded mapping %v, want both runs", reloaded.names)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	_, dir, cleanup := newTestAnonymizer(t, "secret", false)
+	defer cleanup()
+	if err := ioutil.WriteFile(dir+"/empty", []byte(" \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/bad.csv", []byte("kind,name,pseudonym\nnamespace,\"payments"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, keyFile, mappingFile string
+	}{
+		{"missing key", dir + "/missing", dir + "/mapping.csv"},
+		{"empty key", dir + "/empty", dir + "/mapping.csv"},
+		{"bad mapping", dir + "/key", dir + "/bad.csv"},
+	}
+	for _, test := range tests {
+		if _, err := New(test.keyFile, test.mappingFile, false); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+//Package checkpoint records the history windows of each workload file written by a backfill, so a backfill that crashed or timed out resumes from the windows that are still missing rather than starting again.
+package checkpoint
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+//Window holds the settings that decide which windows a backfill collects. A checkpoint is only resumed by a backfill of the same window.
+type Window struct {
+	//Start is the start of the absolute time window, zero if the history before the end is collected.
+	Start        time.Time     `json:"start"`
+	End          time.Time     `json:"end"`
+	History      int           `json:"history"`
+	Interval     string        `json:"interval"`
+	IntervalSize time.Duration `json:"interval_size"`
+	SampleRate   time.Duration `json:"sample_rate"`
+	RateWindow   string        `json:"rate_window"`
+}
+
+//Equal returns true if both windows collect the same data.
+func (w Window) Equal(o Window) bool {
+	return w.Start.Equal(o.Start) && w.End.Equal(o.End) && w.History == o.History && w.Interval == o.Interval && w.IntervalSize == o.IntervalSize && w.SampleRate == o.SampleRate && w.RateWindow == o.RateWindow
+}
+
+//File is the progress of the backfill of a workload file.
+type File struct {
+	//Size is the size of the file once the completed windows were written. Anything after it was written by a window that didn't complete and is cut off when the backfill resumes.
+	Size int64 `json:"size"`
+	//Completed are the ends of the windows written for each cluster.
+	Completed map[string][]time.Time `json:"completed"`
+}
+
+//Checkpoint is the progress of a backfill, saved to its file every time a window is completed. The methods can be called on a nil Checkpoint, which is how collections that aren't backfills write every window.
+type Checkpoint struct {
+	Window Window           `json:"window"`
+	Files  map[string]*File `json:"files"`
+
+	path string
+	mu   sync.Mutex
+	//failed is the number of windows of this run that weren't written as a query failed.
+	failed int
+}
+
+//New returns an empty checkpoint of the window saved to path.
+func New(path string, w Window) *Checkpoint {
+	return &Checkpoint{Window: w, Files: map[string]*File{}, path: path}
+}
+
+//Load reads the checkpoint saved to path. It returns nil if there is no checkpoint.
+func Load(path string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	c := New(path, Window{})
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if c.Files == nil {
+		c.Files = map[string]*File{}
+	}
+	return c, nil
+}
+
+//Size returns the size of the workload file once its completed windows were written, and false if none of its windows were completed.
+func (c *Checkpoint) Size(path string) (int64, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.Files[path]; ok {
+		return f.Size, true
+	}
+	return 0, false
+}
+
+//Done returns true if the window ending at end was written to the workload file for the cluster.
+func (c *Checkpoint) Done(path, cluster string, end time.Time) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.Files[path]
+	if !ok {
+		return false
+	}
+	for _, completed := range f.Completed[cluster] {
+		if completed.Equal(end) {
+			return true
+		}
+	}
+	return false
+}
+
+//Complete records that the window ending at end was written to the workload file for the cluster, leaving the file size bytes long, and saves the checkpoint.
+func (c *Checkpoint) Complete(path, cluster string, end time.Time, size int64) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.Files[path]
+	if !ok {
+		f = &File{Completed: map[string][]time.Time{}}
+		c.Files[path] = f
+	}
+	f.Size = size
+	f.Completed[cluster] = append(f.Completed[cluster], end)
+	return c.save()
+}
+
+//Fail records that a window couldn't be written, so the backfill isn't finished even though it ran through all the windows.
+func (c *Checkpoint) Fail() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failed++
+}
+
+//Failed returns the number of windows that couldn't be written.
+func (c *Checkpoint) Failed() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failed
+}
+
+//save writes the checkpoint to a temporary file that is renamed over the checkpoint, so a crash while saving leaves the previous checkpoint rather than a truncated one.
+func (c *Checkpoint) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(c.path+".tmp", append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(c.path+".tmp", c.path)
+}
+
+//Remove deletes the saved checkpoint once the backfill has finished.
+func (c *Checkpoint) Remove() error {
+	if c == nil {
+		return nil
+	}
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
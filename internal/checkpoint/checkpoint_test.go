@@ -0,0 +1,168 @@
+package checkpoint
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+//tempDir returns a new temporary directory and the function that removes it.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestWindowEqual(t *testing.T) {
+	end := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	w := Window{End: end, History: 24, Interval: "hours", IntervalSize: time.Hour, SampleRate: 5 * time.Minute, RateWindow: "5m"}
+	same := w
+	same.End = end.In(time.FixedZone("CET", 3600))
+	if !w.Equal(same) {
+		t.Errorf("%+v isn't equal to the same window in another time zone", w)
+	}
+	for name, change := range map[string]func(*Window){
+		"start":         func(o *Window) { o.Start = end.Add(-24 * time.Hour) },
+		"end":           func(o *Window) { o.End = end.Add(time.Hour) },
+		"history":       func(o *Window) { o.History = 12 },
+		"interval":      func(o *Window) { o.Interval = "days" },
+		"interval size": func(o *Window) { o.IntervalSize = 2 * time.Hour },
+		"sample rate":   func(o *Window) { o.SampleRate = time.Minute },
+		"rate window":   func(o *Window) { o.RateWindow = "1m" },
+	} {
+		o := w
+		change(&o)
+		if w.Equal(o) {
+			t.Errorf("window with another %s is equal", name)
+		}
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	c, err := Load(dir + "/checkpoint.json")
+	if c != nil || err != nil {
+		t.Errorf("Load of a missing file = %v, %v, want no checkpoint and no error", c, err)
+	}
+	if err := ioutil.WriteFile(dir+"/bad.json", []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir + "/bad.json"); err == nil {
+		t.Error("Load of a corrupt file returned no error")
+	}
+}
+
+func TestComplete(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := dir + "/checkpoint.json"
+	end := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	w := Window{End: end, History: 3, Interval: "hours", IntervalSize: time.Hour}
+
+	c := New(path, w)
+	if err := c.Complete("node/cpu.csv", "east", end, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Complete("node/cpu.csv", "east", end.Add(-time.Hour), 180); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Complete("node/cpu.csv", "west", end, 250); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left after saving: %v", err)
+	}
+
+	//The saved checkpoint has the same progress as the one it was saved from.
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]*Checkpoint{"saved": c, "loaded": loaded} {
+		if !c.Window.Equal(w) {
+			t.Errorf("%s window %+v, want %+v", name, c.Window, w)
+		}
+		if size, ok := c.Size("node/cpu.csv"); !ok || size != 250 {
+			t.Errorf("%s size = %d, %v, want the size after the last window 250", name, size, ok)
+		}
+		if _, ok := c.Size("node/mem.csv"); ok {
+			t.Errorf("%s has a size for a file without completed windows", name)
+		}
+		for _, test := range []struct {
+			path, cluster string
+			end           time.Time
+			done          bool
+		}{
+			{"node/cpu.csv", "east", end, true},
+			{"node/cpu.csv", "east", end.Add(-time.Hour).In(time.Local), true},
+			{"node/cpu.csv", "east", end.Add(-2 * time.Hour), false},
+			{"node/cpu.csv", "west", end, true},
+			{"node/cpu.csv", "west", end.Add(-time.Hour), false},
+			{"node/cpu.csv", "north", end, false},
+			{"node/mem.csv", "east", end, false},
+		} {
+			if done := c.Done(test.path, test.cluster, test.end); done != test.done {
+				t.Errorf("%s Done(%s, %s, %s) = %v, want %v", name, test.path, test.cluster, test.end, done, test.done)
+			}
+		}
+	}
+
+	if err := loaded.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("checkpoint not removed: %v", err)
+	}
+	if err := loaded.Remove(); err != nil {
+		t.Errorf("removing a removed checkpoint: %v", err)
+	}
+}
+
+func TestSaveKeepsPrevious(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := dir + "/checkpoint.json"
+	end := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	c := New(path, Window{End: end, History: 2})
+	if err := c.Complete("node/cpu.csv", "east", end, 100); err != nil {
+		t.Fatal(err)
+	}
+	//A directory in the way of the temporary file makes the next save fail before the checkpoint is replaced.
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Complete("node/cpu.csv", "east", end.Add(-time.Hour), 180); err == nil {
+		t.Fatal("Complete saved over a directory")
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := loaded.Size("node/cpu.csv"); size != 100 || loaded.Done("node/cpu.csv", "east", end.Add(-time.Hour)) {
+		t.Errorf("checkpoint has size %d after a failed save, want the previous checkpoint with size 100", size)
+	}
+}
+
+func TestNil(t *testing.T) {
+	var c *Checkpoint
+	end := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	if err := c.Complete("node/cpu.csv", "east", end, 100); err != nil {
+		t.Error(err)
+	}
+	c.Fail()
+	if _, ok := c.Size("node/cpu.csv"); ok || c.Done("node/cpu.csv", "east", end) || c.Failed() != 0 || c.Remove() != nil {
+		t.Error("a nil checkpoint has progress")
+	}
+
+	c = New("", Window{})
+	c.Fail()
+	c.Fail()
+	if c.Failed() != 2 {
+		t.Errorf("failed = %d, want 2", c.Failed())
+	}
+}
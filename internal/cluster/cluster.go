@@ -3,28 +3,34 @@ package cluster
 
 import (
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/entity"
 	"github.com/prometheus/common/model"
 )
 
-//A node structure. Used for storing attributes and config details.
-type clusterStruct struct {
+//Hard-coded string for log file warnings
+var entityKind = "cluster"
 
-	//Value fields
-	cpuLimit, cpuRequest, memLimit, memRequest int
+//Collector collects the cluster data for a single run. Create a new one with NewCollector for every run as it holds the totals found.
+type Collector struct {
+	args      *common.Parameters
+	cluster   *entity.Cluster
+	workloads []*common.Workload
 }
 
-//Map that labels and values will be stored in
-var clusterEntity = clusterStruct{}
-
-//Hard-coded string for log file warnings
-var entityKind = "cluster"
+//NewCollector returns a Collector that queries Prometheus using the parameters provided.
+func NewCollector(args *common.Parameters) *Collector {
+	return &Collector{args: args, cluster: &entity.Cluster{Name: *args.ClusterName, CPULimit: -1, CPURequest: -1, MemLimit: -1, MemRequest: -1}}
+}
 
 //Gets cluster metrics from prometheus (and checks to see if they are valid)
-func getClusterMetric(result model.Value, metric string) {
+func (c *Collector) getClusterMetric(result model.Value, metric string) {
+	//A query that returned no series leaves the metric unset.
+	if result.(model.Matrix).Len() == 0 {
+		return
+	}
 
 	//validates that the value of the entity is set and if not will default to 0
 	var value int
@@ -38,80 +44,80 @@ func getClusterMetric(result model.Value, metric string) {
 
 	switch metric {
 	case "cpuLimit":
-		clusterEntity.cpuLimit = int(value)
+		c.cluster.CPULimit = int(value)
 	case "cpuRequest":
-		clusterEntity.cpuRequest = int(value)
+		c.cluster.CPURequest = int(value)
 	case "memLimit":
-		clusterEntity.memLimit = int(value)
+		c.cluster.MemLimit = int(value)
 	case "memRequest":
-		clusterEntity.memRequest = int(value)
+		c.cluster.MemRequest = int(value)
 	}
 
 }
 
+//Write creates the config, attributes and workload files for the cluster totals and workloads found by the Collector.
+func Write(args *common.Parameters, cluster *entity.Cluster, workloads []*common.Workload) {
+	writeAttributes(args, cluster)
+	writeConfig(args, cluster)
+	common.WriteWorkloads(args, workloads)
+}
+
 //writeConfig will create the config.csv file that is will be sent Densify by the Forwarder.
-func writeConfig(args *common.Parameters) {
+func writeConfig(args *common.Parameters, cluster *entity.Cluster) {
 
 	//Create the config file and open it for writing.
-	configWrite, err := os.Create("./data/cluster/config.csv")
+	configWrite, err := common.CreateFile(args, entityKind, "config", "cluster")
 	if err != nil {
-		args.ErrorLogger.Println("entity=" + entityKind + " message=" + err.Error())
-		fmt.Println("entity=" + entityKind + " message=" + err.Error())
+		args.Logger.Error(err.Error(), "entity", entityKind)
 		return
 	}
-
-	//Write out the header.
-	fmt.Fprintln(configWrite, "cluster")
-	fmt.Fprintf(configWrite, "%s\n", *args.ClusterName)
+	fmt.Fprintf(configWrite, "%s\n", cluster.Name)
 	configWrite.Close()
 }
 
 //writeAttributes will create the attributes.csv file that is will be sent Densify by the Forwarder.
-func writeAttributes(args *common.Parameters) {
+func writeAttributes(args *common.Parameters, cluster *entity.Cluster) {
 
 	//Create the attributes file and open it for writing
-	attributeWrite, err := os.Create("./data/cluster/attributes.csv")
+	attributeWrite, err := common.CreateFile(args, entityKind, "attributes", "cluster,Virtual Technology,Virtual Domain,Existing CPU Limit,Existing CPU Request,Existing Memory Limit,Existing Memory Request")
 	if err != nil {
-		args.ErrorLogger.Println("entity=" + entityKind + " message=" + err.Error())
-		fmt.Println("entity=" + entityKind + " message=" + err.Error())
+		args.Logger.Error(err.Error(), "entity", entityKind)
 		return
 	}
 
-	//Write out the header.
-	fmt.Fprintln(attributeWrite, "cluster,Virtual Technology,Virtual Domain,Existing CPU Limit,Existing CPU Request,Existing Memory Limit,Existing Memory Request")
-
 	//Write out the different fields. For fiels that are numeric we don't want to write -1 if it wasn't set so we write a blank if that is the value otherwise we write the number out.
-	fmt.Fprintf(attributeWrite, "%s,Clusters,%s", *args.ClusterName, *args.ClusterName)
+	fmt.Fprintf(attributeWrite, "%s,Clusters,%s", cluster.Name, cluster.Name)
 
-	if clusterEntity.cpuLimit == -1 {
+	if cluster.CPULimit == -1 {
 		fmt.Fprintf(attributeWrite, ",")
 	} else {
-		fmt.Fprintf(attributeWrite, ",%d", clusterEntity.cpuLimit)
+		fmt.Fprintf(attributeWrite, ",%d", cluster.CPULimit)
 	}
 
-	if clusterEntity.cpuRequest == -1 {
+	if cluster.CPURequest == -1 {
 		fmt.Fprintf(attributeWrite, ",")
 	} else {
-		fmt.Fprintf(attributeWrite, ",%d", clusterEntity.cpuRequest)
+		fmt.Fprintf(attributeWrite, ",%d", cluster.CPURequest)
 	}
 
-	if clusterEntity.memLimit == -1 {
+	if cluster.MemLimit == -1 {
 		fmt.Fprintf(attributeWrite, ",")
 	} else {
-		fmt.Fprintf(attributeWrite, ",%d", clusterEntity.memLimit)
+		fmt.Fprintf(attributeWrite, ",%d", cluster.MemLimit)
 	}
 
-	if clusterEntity.memRequest == -1 {
+	if cluster.MemRequest == -1 {
 		fmt.Fprintf(attributeWrite, ",\n")
 	} else {
-		fmt.Fprintf(attributeWrite, ",%d\n", clusterEntity.memRequest)
+		fmt.Fprintf(attributeWrite, ",%d\n", cluster.MemRequest)
 	}
 
 	attributeWrite.Close()
 }
 
-//Metrics a global func for collecting node level metrics in prometheus
-func Metrics(args *common.Parameters) {
+//Collect gathers the cluster totals and their workloads, unless they are skipped, and returns them to be written by Write.
+func (c *Collector) Collect() (*entity.Cluster, []*common.Workload) {
+	args := c.args.PlanFor(entityKind, entityKind+"/attributes.csv")
 	//Setup variables used in the code.
 	var historyInterval time.Duration
 	historyInterval = 0
@@ -121,46 +127,49 @@ func Metrics(args *common.Parameters) {
 	//Start and end time + the prometheus address used for querying
 	range5Min := common.TimeRange(args, historyInterval)
 
-	query = `sum(kube_pod_container_resource_limits_cpu_cores*1000 * on (namespace,pod,container) group_left kube_pod_container_status_running)`
+	query = args.PodQuery(`sum(kube_pod_container_resource_limits_cpu_cores*1000 * on (namespace,pod,container) group_left kube_pod_container_status_running)`)
 	result = common.MetricCollect(args, query, range5Min, "cpuLimit", false)
 	if result != nil {
-		getClusterMetric(result, "cpuLimit")
+		c.getClusterMetric(result, "cpuLimit")
 	}
 
-	query = `sum(kube_pod_container_resource_requests_cpu_cores*1000 * on (namespace,pod,container) group_left kube_pod_container_status_running)`
+	query = args.PodQuery(`sum(kube_pod_container_resource_requests_cpu_cores*1000 * on (namespace,pod,container) group_left kube_pod_container_status_running)`)
 	result = common.MetricCollect(args, query, range5Min, "cpuRequest", false)
 	if result != nil {
-		getClusterMetric(result, "cpuRequest")
+		c.getClusterMetric(result, "cpuRequest")
 	}
 
-	query = `sum(kube_pod_container_resource_limits_memory_bytes/1024/1024 * on (namespace,pod,container) group_left kube_pod_container_status_running)`
+	query = args.PodQuery(`sum(kube_pod_container_resource_limits_memory_bytes/1024/1024 * on (namespace,pod,container) group_left kube_pod_container_status_running)`)
 	result = common.MetricCollect(args, query, range5Min, "memLimit", false)
 	if result != nil {
-		getClusterMetric(result, "memLimit")
+		c.getClusterMetric(result, "memLimit")
 	}
 
-	query = `sum(kube_pod_container_resource_requests_memory_bytes/1024/1024 * on (namespace,pod,container) group_left kube_pod_container_status_running)`
+	query = args.PodQuery(`sum(kube_pod_container_resource_requests_memory_bytes/1024/1024 * on (namespace,pod,container) group_left kube_pod_container_status_running)`)
 	result = common.MetricCollect(args, query, range5Min, "memRequest", false)
 	if result != nil {
-		getClusterMetric(result, "memRequest")
+		c.getClusterMetric(result, "memRequest")
 	}
 
-	writeAttributes(args)
-	writeConfig(args)
+	if args.SkipWorkloads {
+		return c.cluster, c.workloads
+	}
 
 	//Query and store prometheus CPU requests
-	query = `sum((kube_pod_container_resource_requests_cpu_cores) * on (namespace,pod,container) group_left kube_pod_container_status_running)`
-	common.GetWorkload("cpu_requests", "CPU Reservation in Cores", query, "", args, entityKind)
+	query = args.PodQuery(`sum((kube_pod_container_resource_requests_cpu_cores) * on (namespace,pod,container) group_left kube_pod_container_status_running)`)
+	c.workloads = append(c.workloads, common.GetWorkload("cpu_requests", "CPU Reservation in Cores", query, "", args, entityKind))
 
 	//Query and store prometheus CPU requests
-	query = `sum((kube_pod_container_resource_requests_cpu_cores) * on (namespace,pod,container) group_left kube_pod_container_status_running) / sum(kube_node_status_allocatable_cpu_cores) * 100`
-	common.GetWorkload("cpu_reservation_percent", "CPU Reservation Percent", query, "", args, entityKind)
+	query = args.PodQuery(`sum((kube_pod_container_resource_requests_cpu_cores) * on (namespace,pod,container) group_left kube_pod_container_status_running) / sum(kube_node_status_allocatable_cpu_cores) * 100`)
+	c.workloads = append(c.workloads, common.GetWorkload("cpu_reservation_percent", "CPU Reservation Percent", query, "", args, entityKind))
 
 	//Query and store prometheus Memory requests
-	query = `sum((kube_pod_container_resource_requests_memory_bytes/1024/1024) * on (namespace,pod,container) group_left kube_pod_container_status_running)`
-	common.GetWorkload("memory_requests", "Memory Reservation in MB", query, "", args, entityKind)
+	query = args.PodQuery(`sum((kube_pod_container_resource_requests_memory_bytes/1024/1024) * on (namespace,pod,container) group_left kube_pod_container_status_running)`)
+	c.workloads = append(c.workloads, common.GetWorkload("memory_requests", "Memory Reservation in MB", query, "", args, entityKind))
 
 	//Query and store prometheus Memory requests
-	query = `sum((kube_pod_container_resource_requests_memory_bytes/1024/1024) * on (namespace,pod,container) group_left kube_pod_container_status_running) / sum(kube_node_status_allocatable_memory_bytes/1024/1024) * 100`
-	common.GetWorkload("memory_reservation_percent", "Memory Reservation Percent", query, "", args, entityKind)
+	query = args.PodQuery(`sum((kube_pod_container_resource_requests_memory_bytes/1024/1024) * on (namespace,pod,container) group_left kube_pod_container_status_running) / sum(kube_node_status_allocatable_memory_bytes/1024/1024) * 100`)
+	c.workloads = append(c.workloads, common.GetWorkload("memory_reservation_percent", "Memory Reservation Percent", query, "", args, entityKind))
+
+	return c.cluster, c.workloads
 }
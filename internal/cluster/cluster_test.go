@@ -0,0 +1,27 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/testutil"
+	"github.com/prometheus/common/model"
+)
+
+func TestGetClusterMetric(t *testing.T) {
+	name := "east"
+	c := NewCollector(&common.Parameters{ClusterName: &name})
+	c.getClusterMetric(model.Matrix{testutil.Series(nil, 1000, 1200)}, "cpuRequest")
+	c.getClusterMetric(model.Matrix{testutil.Series(nil)}, "memRequest")
+	c.getClusterMetric(model.Matrix{}, "cpuLimit")
+
+	if c.cluster.Name != "east" || c.cluster.CPURequest != 1200 {
+		t.Errorf("cluster = %s with cpu request %d, want east with the last value 1200", c.cluster.Name, c.cluster.CPURequest)
+	}
+	if c.cluster.MemRequest != 0 {
+		t.Errorf("memory request = %d, want 0 for a series without samples", c.cluster.MemRequest)
+	}
+	if c.cluster.CPULimit != -1 {
+		t.Errorf("cpu limit = %d, want it unset when no series are returned", c.cluster.CPULimit)
+	}
+}
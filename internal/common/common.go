@@ -1,45 +1,173 @@
 package common
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"fmt"
-	"io"
-	"log"
 	"math"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/anonymize"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/checkpoint"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/logging"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/plan"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/selector"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/selfmetrics"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/summary"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/watermark"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/entity"
 	"github.com/prometheus/client_golang/api"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
 )
 
 // Parameters - Reusable structure that holds common arguments used in the project
 type Parameters struct {
-	ClusterName, PromURL, PromAddress, FileName, Interval *string
-	IntervalSize, History, Offset                         *int
-	Debug                                                 bool
-	CurrentTime                                           *time.Time
-	LabelSuffix                                           string
-	InfoLogger, WarnLogger, ErrorLogger, DebugLogger      *log.Logger
-	SampleRate                                            int
-	SampleRateString                                      string
-	OAuthTokenPath                                        string
-	CaCertPath                                            string
+	ClusterName, PromURL, PromAddress, FileName, Interval  *string
+	History                                                *int
+	IntervalSize, Offset                                   time.Duration
+	CurrentTime                                            *time.Time
+	StartTime                                              time.Time
+	Logger                                                 *logging.Logger
+	SampleRate                                             time.Duration
+	RateWindow                                             string
+	OAuthTokenPath                                         string
+	CaCertPath                                             string
+	OAuth2TokenURL, OAuth2ClientID, OAuth2ClientSecretPath string
+	OAuth2Scopes                                           []string
+	ClusterMatcher                                         string
+	NamespaceMatcher                                       string
+	NamespaceFilterNodes                                   bool
+	Selector                                               *selector.Selector
+	ExcludeAnnotation                                      *selector.Selector
+	LabelFilter                                            *LabelFilter
+	LabelColumns                                           *LabelColumns
+	Anonymizer                                             *anonymize.Anonymizer
+	OutputDir                                              string
+	SkipWorkloads                                          bool
+	Context                                                context.Context
+	Metrics                                                *selfmetrics.Metrics
+	Summary                                                *summary.Summary
+	Checkpoint                                             *checkpoint.Checkpoint
+	Watermarks                                             *watermark.Marks
+	MaxCatchUp                                             time.Duration
+	Plan                                                   *plan.Plan
+	prom                                                   *promClient
+	files                                                  map[string]bool
+	//matcher is added to every query run with these parameters, on top of the cluster matcher.
+	matcher string
+	//planEntity and planFile are the entity and file recorded in the plan of a dry run for the queries run with these parameters.
+	planEntity, planFile string
+}
+
+//Copy returns a copy of the parameters that can be changed to collect another Prometheus or cluster without affecting the original. The copy gets its own Prometheus client but shares the record of the files created so far so merged output is appended rather than overwritten.
+func (args *Parameters) Copy() *Parameters {
+	if args.files == nil {
+		args.files = map[string]bool{}
+	}
+	c := *args
+	clusterName, promURL, promAddress := *args.ClusterName, *args.PromURL, *args.PromAddress
+	c.ClusterName, c.PromURL, c.PromAddress = &clusterName, &promURL, &promAddress
+	c.prom = nil
+	return &c
+}
+
+//share readies the Prometheus client and the record of the files created so the copies of the parameters made for a collector use the same ones.
+func (args *Parameters) share() {
+	if args.files == nil {
+		args.files = map[string]bool{}
+	}
+	if args.prom == nil {
+		args.prom = &promClient{}
+	}
+}
+
+//WithMatcher returns the parameters to run queries that only return the series matching the label matcher with, e.g. the namespace matcher for the container queries. The parameters are returned as they are if the matcher is empty.
+func (args *Parameters) WithMatcher(matcher string) *Parameters {
+	if matcher == "" {
+		return args
+	}
+	args.share()
+	c := *args
+	if c.matcher != "" {
+		matcher = c.matcher + "," + matcher
+	}
+	c.matcher = matcher
+	return &c
+}
+
+//PodQuery returns the query with the namespace matcher added to its kube_pod metrics when the node level request and limit totals only count the namespaces collected.
+func (args *Parameters) PodQuery(query string) string {
+	if !args.NamespaceFilterNodes {
+		return query
+	}
+	return InjectPodMatcher(query, args.NamespaceMatcher)
+}
+
+//context returns the context the queries run under, which is cancelled when the caller gives up on the collection.
+func (args *Parameters) context() context.Context {
+	if args.Context == nil {
+		return context.Background()
+	}
+	return args.Context
+}
+
+//AlignTime truncates t to the start of the interval (day, hour or minute) and moves it back by the offset. All the queries of a run use this time so a large environment is collected as a snapshot of a specific time rather than a misaligned set of data.
+func AlignTime(t time.Time, interval string, offset time.Duration) time.Time {
+	if interval == "days" {
+		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	} else if interval == "hours" {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	} else {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location())
+	}
+	return t.Add(-offset)
+}
+
+//NewRun returns a copy of the parameters for a collection at currentTime that is cancelled with ctx. The files written by earlier runs in the process are overwritten rather than appended to.
+func (args *Parameters) NewRun(ctx context.Context, currentTime time.Time) *Parameters {
+	c := args.Copy()
+	c.files = map[string]bool{}
+	c.Context = ctx
+	c.CurrentTime = &currentTime
+	return c
+}
+
+//PlanFor returns the parameters to run the queries that feed the file of the entity with, so they are recorded against it in the plan of a dry run. The parameters are returned as they are if it isn't a dry run.
+func (args *Parameters) PlanFor(entity, file string) *Parameters {
+	if args.Plan == nil {
+		return args
+	}
+	args.share()
+	c := *args
+	c.planEntity, c.planFile = entity, file
+	return &c
 }
 
 // Prometheus Objects
 
-//MetricCollect is used to query Prometheus to get data for specific query and return the results to be processed.
-func MetricCollect(args *Parameters, query string, range5m v1.Range, metric string, vital bool) (value model.Value) {
+//promClient holds the Prometheus API client, so it is shared by the copies of the parameters made before it was created.
+type promClient struct {
+	api v1.API
+}
 
-	//setup the context to use for the API calls
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+//promAPI returns the Prometheus API client for the run. The client and its HTTP transport are created on first use and shared by every query so connections and OAuth2 tokens are reused.
+//A dry run only contacts Prometheus when its schema detection is enabled.
+func promAPI(args *Parameters) (v1.API, error) {
+	if args.prom != nil && args.prom.api != nil {
+		return args.prom.api, nil
+	}
+	if args.Plan != nil && !args.Plan.Detect {
+		return nil, fmt.Errorf("Prometheus isn't contacted by a dry run without schema detection")
+	}
 
 	tlsClientConfig := &tls.Config{}
 	if args.CaCertPath != "" {
@@ -47,88 +175,319 @@ func MetricCollect(args *Parameters, query string, range5m v1.Range, metric stri
 			CAFile: args.CaCertPath,
 		})
 		if err != nil {
-			log.Fatalf("Failed to generate TLS config:%v", err)
+			return nil, fmt.Errorf("failed to generate TLS config: %v", err)
 		}
 		tlsClientConfig = tmpTLSConfig
 	}
 
 	var roundTripper http.RoundTripper = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
 		TLSClientConfig: tlsClientConfig,
 	}
 
-	if args.OAuthTokenPath != "" {
+	if args.OAuth2TokenURL != "" {
+		roundTripper = NewOAuth2RoundTripper(args.OAuth2TokenURL, args.OAuth2ClientID, args.OAuth2ClientSecretPath, args.OAuth2Scopes, roundTripper)
+	} else if args.OAuthTokenPath != "" {
 		roundTripper = config.NewBearerAuthFileRoundTripper(args.OAuthTokenPath, roundTripper)
 	}
-	//Setup the API client connection
+
 	client, err := api.NewClient(api.Config{Address: *args.PromURL, RoundTripper: roundTripper})
 	if err != nil {
-		args.WarnLogger.Println("metric=" + metric + " query=" + query + " message=" + err.Error())
-		fmt.Println("metric=" + metric + " query=" + query + " message=" + err.Error())
-		return value
+		return nil, err
+	}
+	if args.prom == nil {
+		args.prom = &promClient{}
+	}
+	args.prom.api = v1.NewAPI(client)
+	return args.prom.api, nil
+}
+
+//MetricCollect is used to query Prometheus to get data for specific query and return the results to be processed.
+func MetricCollect(args *Parameters, query string, range5m v1.Range, metric string, vital bool) model.Value {
+	value, _ := CollectWindow(args, query, range5m, metric, vital)
+	return value
+}
+
+//CollectWindow queries Prometheus like MetricCollect and also returns an error if the query failed or was skipped as the run was cancelled. The workload files use it so a history window is only recorded as written when all its queries succeeded. A query that returns no data isn't an error.
+func CollectWindow(args *Parameters, query string, range5m v1.Range, metric string, vital bool) (value model.Value, err error) {
+
+	//setup the context to use for the API calls. Once the run has been cancelled the remaining queries are skipped, the run summary reports the levels that weren't completed.
+	ctx, cancel := context.WithCancel(args.context())
+	defer cancel()
+	if ctx.Err() != nil {
+		return value, ctx.Err()
+	}
+
+	//When collecting one of several clusters from the same Prometheus only the series of that cluster are queried.
+	query = InjectMatcher(query, args.ClusterMatcher)
+	query = InjectMatcher(query, args.matcher)
+
+	//A dry run records the query rather than running it and returns no data, so the collection goes on to plan the queries that follow.
+	if args.Plan != nil {
+		args.Plan.Add(args.planned(query, range5m, metric, false))
+		return model.Matrix{}, nil
+	}
+
+	//Every record logged for the query carries the metric and the query, which is also how the query is reported in the run summary.
+	logger := args.Logger.With("metric", metric, "query", query)
+	reported := summary.Query{Cluster: *args.ClusterName, Metric: metric, Query: query, Vital: vital}
+
+	//Setup the API client connection
+	q, err := promAPI(args)
+	if err != nil {
+		args.Metrics.ObserveQuery(metric, 0, true)
+		reported.Error = err.Error()
+		args.Summary.FailedQuery(reported)
+		logger.Warn(err.Error())
+		return value, err
 	}
 
 	//Query prometheus with the values defined above as well as the query that was passed into the function.
-	q := v1.NewAPI(client)
+	start := time.Now()
 	value, _, err = q.QueryRange(ctx, query, range5m)
+	duration := time.Since(start)
+	args.Metrics.ObserveQuery(metric, duration, err != nil || (vital && !hasData(value)))
 	if err != nil {
-		args.ErrorLogger.Println("metric=" + metric + " query=" + query + " message=" + err.Error())
-		fmt.Println("metric=" + metric + " query=" + query + " message=" + err.Error())
-		return value
+		reported.Error = err.Error()
+		args.Summary.FailedQuery(reported)
+		logger.Error(err.Error(), "duration", duration)
+		return value, err
+	}
+	if !hasData(value) {
+		args.Summary.EmptyQuery(reported)
 	}
 
 	//If the values from the query return no data (length of 0) then give a warning
 	if value == nil {
 		if vital {
-			args.ErrorLogger.Println("metric=" + metric + " query=" + query + " message=No resultset returned")
-			fmt.Println("metric=" + metric + " query=" + query + " message=No resultset returned")
-			return value
+			logger.Error("No resultset returned", "duration", duration)
+			return value, nil
 		}
-		args.WarnLogger.Println("metric=" + metric + " query=" + query + " message=No resultset returned")
-		fmt.Println("metric=" + metric + " query=" + query + " message=No resultset returned")
-		return value
+		logger.Warn("No resultset returned", "duration", duration)
+		return value, nil
 
 	} else if value.(model.Matrix) == nil {
 		if vital {
-			args.ErrorLogger.Println("metric=" + metric + " query=" + query + " message=No time series data returned")
-			fmt.Println("metric=" + metric + " query=" + query + " message=No time series data returned")
-			return value
+			logger.Error("No time series data returned", "duration", duration)
+			return value, nil
 		}
-		args.WarnLogger.Println("metric=" + metric + " query=" + query + " message=No time series data returned")
-		fmt.Println("metric=" + metric + " query=" + query + " message=No time series data returned")
-		return value
+		logger.Warn("No time series data returned", "duration", duration)
+		return value, nil
 	} else if value.(model.Matrix).Len() == 0 {
 		if vital {
-			args.ErrorLogger.Println("metric=" + metric + " query=" + query + " message=No data returned, value.(model.Matrix) is empty")
-			fmt.Println("metric=" + metric + " query=" + query + " message=No data returned, value.(model.Matrix) is empty")
-			return value
+			logger.Error("No data returned, value.(model.Matrix) is empty", "duration", duration)
+			return value, nil
 		}
-		args.WarnLogger.Println("metric=" + metric + " query=" + query + " message=No data returned, value.(model.Matrix) is empty")
-		fmt.Println("metric=" + metric + " query=" + query + " message=No data returned, value.(model.Matrix) is empty")
-
+		logger.Warn("No data returned, value.(model.Matrix) is empty", "duration", duration)
+		return value, nil
 	}
+	logger.Debug("Query returned data", "duration", duration, "series", value.(model.Matrix).Len())
 
 	//Return the data that was received from Prometheus.
-	return value
+	return value, nil
+}
+
+//DetectCollect runs a query whose result decides the form of the later queries, e.g. whether cAdvisor uses the pod_name label, and returns true as the schema was detected.
+//A dry run only runs it when schema detection is enabled, otherwise it returns nil and false and the collectors plan the queries of the current schema.
+func DetectCollect(args *Parameters, query string, range5m v1.Range, metric string) (model.Value, bool) {
+	if args.Plan == nil {
+		return MetricCollect(args, query, range5m, metric, false), true
+	}
+	args.Plan.Add(args.planned(InjectMatcher(InjectMatcher(query, args.ClusterMatcher), args.matcher), range5m, metric, true))
+	if !args.Plan.Detect {
+		return nil, false
+	}
+	args.share()
+	c := *args
+	c.Plan = nil
+	return MetricCollect(&c, query, range5m, metric, false), true
+}
+
+//planned returns the query as it is recorded in the plan of a dry run.
+func (args *Parameters) planned(query string, range5m v1.Range, metric string, detect bool) plan.Query {
+	return plan.Query{Cluster: *args.ClusterName, Entity: args.planEntity, Metric: metric, Query: query, Start: range5m.Start, End: range5m.End, Step: model.Duration(range5m.Step).String(), File: args.planFile, Detect: detect}
+}
+
+//hasData returns true if the query returned at least one series.
+func hasData(value model.Value) bool {
+	matrix, ok := value.(model.Matrix)
+	return ok && matrix.Len() != 0
+}
+
+//DetectClusterName tries to derive the cluster name from Prometheus. It first looks for the label in the external_labels of the Prometheus configuration and then for a single value of the label on kube_node_info or up. An empty string is returned if nothing is found.
+func DetectClusterName(args *Parameters, label string) string {
+	if label == "" {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(args.context(), time.Minute)
+	defer cancel()
+
+	q, err := promAPI(args)
+	if err != nil {
+		args.Logger.Warn("Unable to detect cluster name: " + err.Error())
+		return ""
+	}
+
+	//Agents like Thanos Query do not serve the configuration endpoint so an error here is expected and we fall through to the queries.
+	if cfg, err := q.Config(ctx); err == nil {
+		var promConfig struct {
+			Global struct {
+				ExternalLabels map[string]string `yaml:"external_labels"`
+			} `yaml:"global"`
+		}
+		if err := yaml.Unmarshal([]byte(cfg.YAML), &promConfig); err == nil {
+			if name := promConfig.Global.ExternalLabels[label]; name != "" {
+				args.Logger.Info("Cluster name " + name + " found in Prometheus external label " + label)
+				return name
+			}
+		}
+	} else {
+		args.Logger.Debug("Unable to read Prometheus configuration: " + err.Error())
+	}
+
+	values, metric := clusterLabelValues(ctx, q, args, label)
+	if len(values) > 1 {
+		args.Logger.Warn("Multiple values found for label "+label+" of "+metric+", unable to pick a cluster name", "metric", "clusterName")
+		return ""
+	}
+	if len(values) == 1 {
+		args.Logger.Info("Cluster name " + values[0] + " found in label " + label + " of " + metric)
+		return values[0]
+	}
+	return ""
+}
+
+//ClusterNames returns all the values of the cluster label found in Prometheus, used when collecting several clusters from a single Prometheus or Thanos.
+func ClusterNames(args *Parameters, label string) []string {
+	ctx, cancel := context.WithTimeout(args.context(), time.Minute)
+	defer cancel()
+
+	q, err := promAPI(args)
+	if err != nil {
+		args.Logger.Error(err.Error(), "metric", "clusterNames")
+		return nil
+	}
+	values, _ := clusterLabelValues(ctx, q, args, label)
+	return values
+}
+
+//clusterLabelValues returns the values of the label on kube_node_info, or on up if kube_node_info doesn't have it, along with the metric they were found on.
+//The values are looked up over the whole collection window, so a backfill or an absolute window also finds the clusters that have since gone.
+func clusterLabelValues(ctx context.Context, q v1.API, args *Parameters, label string) ([]string, string) {
+	end, window := time.Now(), time.Duration(0)
+	if args.CurrentTime != nil {
+		end = *args.CurrentTime
+		history := 1
+		if args.History != nil && *args.History > 1 {
+			history = *args.History
+		}
+		window = end.Sub(TimeRange(args, time.Duration(history-1)).Start)
+	}
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+	for _, metric := range []string{"kube_node_info", "up"} {
+		query := `count(count_over_time(` + metric + `{` + label + `!=""}[` + model.Duration(window).String() + `])) by (` + label + `)`
+		value, _, err := q.Query(ctx, query, end)
+		if err != nil {
+			args.Logger.Warn(err.Error(), "metric", "clusterName", "query", query)
+			continue
+		}
+		vector, ok := value.(model.Vector)
+		if !ok || len(vector) == 0 {
+			continue
+		}
+		var values []string
+		for _, sample := range vector {
+			values = append(values, string(sample.Metric[model.LabelName(label)]))
+		}
+		sort.Strings(values)
+		return values, metric
+	}
+	return nil, ""
 }
 
 //TimeRange allows you to define the start and end values of the range will pass to the Prometheus for the query.
 func TimeRange(args *Parameters, historyInterval time.Duration) (promRange v1.Range) {
 
-	var start, end time.Time
-
 	//For workload metrics the historyInterval will be set depending on how far back in history we are querying currently. Note it will be 0 for all queries that are not workload related.
-	if *args.Interval == "days" {
-		start = args.CurrentTime.Add(time.Hour * -24 * time.Duration(*args.IntervalSize)).Add(time.Hour * -24 * time.Duration(*args.IntervalSize) * historyInterval)
-		end = args.CurrentTime.Add(time.Hour * -24 * time.Duration(*args.IntervalSize) * historyInterval)
-	} else if *args.Interval == "hours" {
-		start = args.CurrentTime.Add(time.Hour * -1 * time.Duration(*args.IntervalSize)).Add(time.Hour * -1 * time.Duration(*args.IntervalSize) * historyInterval)
-		end = args.CurrentTime.Add(time.Hour * -1 * time.Duration(*args.IntervalSize) * historyInterval)
-	} else {
-		start = args.CurrentTime.Add(time.Minute * -1 * time.Duration(*args.IntervalSize)).Add(time.Minute * -1 * time.Duration(*args.IntervalSize) * historyInterval)
-		end = args.CurrentTime.Add(time.Minute * -1 * time.Duration(*args.IntervalSize) * historyInterval)
+	end := args.CurrentTime.Add(-args.IntervalSize * historyInterval)
+	start := end.Add(-args.IntervalSize)
+
+	//When collecting an absolute time window the oldest history window is cut short at the start of the window.
+	if !args.StartTime.IsZero() && start.Before(args.StartTime) {
+		start = args.StartTime
 	}
 
-	return v1.Range{Start: start, End: end, Step: time.Minute * time.Duration(args.SampleRate)}
+	return v1.Range{Start: start, End: end, Step: args.SampleRate}
+}
+
+//IntervalDuration returns the length of size intervals of days, hours or minutes.
+func IntervalDuration(interval string, size int) time.Duration {
+	if interval == "days" {
+		return time.Hour * 24 * time.Duration(size)
+	} else if interval == "hours" {
+		return time.Hour * time.Duration(size)
+	}
+	return time.Minute * time.Duration(size)
+}
+
+//CheckInterval returns an error unless the interval is days, hours or minutes.
+func CheckInterval(interval string) error {
+	if interval != "days" && interval != "hours" && interval != "minutes" {
+		return fmt.Errorf("invalid interval %q, it must be days, hours or minutes", interval)
+	}
+	return nil
+}
+
+//ParseDuration reads a Prometheus duration such as 15m, 6h or 1w, or a Go duration such as 1h30m or 30s. A plain number is a number of intervals of days, hours or minutes, as used by older versions. Units that aren't known are an error rather than being taken as minutes.
+func ParseDuration(value, interval string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if n, err := strconv.Atoi(value); err == nil {
+		return IntervalDuration(interval, n), nil
+	}
+	if d, err := model.ParseDuration(value); err == nil {
+		return time.Duration(d), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, it must be a number of %s or a duration with a unit of ms, s, m, h, d, w or y, e.g. 15m", value, interval)
+	}
+	return d, nil
+}
+
+//Retention returns how long Prometheus keeps data for, read from the flags it was started with. It returns 0 if the retention is only limited by size or the flags can't be read, as happens with Thanos and managed Prometheus services.
+func Retention(args *Parameters) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(args.context(), time.Minute)
+	defer cancel()
+
+	q, err := promAPI(args)
+	if err != nil {
+		return 0, err
+	}
+	flags, err := q.Flags(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	//The retention.time flag replaced retention in Prometheus 2.7. When neither is set the default of 15 days applies unless the retention is by size.
+	for _, name := range []string{"storage.tsdb.retention.time", "storage.tsdb.retention"} {
+		if value, ok := flags[name]; ok && value != "" && value != "0s" {
+			retention, err := model.ParseDuration(value)
+			if err != nil {
+				return 0, fmt.Errorf("unable to parse %s %q: %s", name, value, err)
+			}
+			return time.Duration(retention), nil
+		}
+	}
+	if size, ok := flags["storage.tsdb.retention.size"]; ok && size != "" && size != "0B" {
+		return 0, nil
+	}
+	if _, ok := flags["storage.tsdb.retention.time"]; ok {
+		return 15 * 24 * time.Hour, nil
+	}
+	return 0, nil
 }
 
 // AddToLabelMap used to add values to label map used for attributes.
@@ -177,61 +536,303 @@ func AddToLabelMap(key string, value string, labelPath map[string]string) {
 	}
 }
 
-//GetWorkload used to query for the workload data and then calls write workload
-func GetWorkload(fileName, metricName, query string, metricfield model.LabelName, args *Parameters, entityKind string) {
-	var historyInterval time.Duration
-	historyInterval = 0
-	var result model.Value
-	//Open the files that will be used for the workload data types and write out there headers.
-	workloadWrite, err := os.Create("./data/" + entityKind + "/" + fileName + ".csv")
+//File is a csv file being written. It counts the rows written to it so they can be reported in the self metrics when it is closed.
+type File struct {
+	*os.File
+	path string
+	rows int
+	args *Parameters
+	//key is the key of the high-water mark of the file, windows the number of history windows to collect for it and written the history windows written so far.
+	key     string
+	windows int
+	written map[time.Duration]bool
+	//columns are the kinds of names held by the columns when the names are anonymized and pending the end of a row that is still being written.
+	columns []string
+	pending []byte
+}
+
+//Write writes to the file, counting the rows. When the names are anonymized the rows are written once they are complete, with the names replaced.
+func (f *File) Write(p []byte) (int, error) {
+	if f.columns != nil {
+		f.pending = append(f.pending, p...)
+		return len(p), f.writeRows(false)
+	}
+	n, err := f.File.Write(p)
+	f.rows += bytes.Count(p[:n], []byte{'\n'})
+	return n, err
+}
+
+//writeRows writes the complete rows that are pending with the names anonymized, and the rest of the pending data as well when all is set.
+func (f *File) writeRows(all bool) error {
+	var out bytes.Buffer
+	for {
+		i := bytes.IndexByte(f.pending, '\n')
+		if i < 0 {
+			break
+		}
+		out.WriteString(f.args.Anonymizer.Row(f.columns, string(f.pending[:i])) + "\n")
+		f.pending = f.pending[i+1:]
+		f.rows++
+	}
+	if all && len(f.pending) > 0 {
+		out.WriteString(f.args.Anonymizer.Row(f.columns, string(f.pending)))
+		f.pending = nil
+	}
+	_, err := f.File.Write(out.Bytes())
+	return err
+}
+
+//Close closes the file and records the rows written to it. For incremental collections the high-water mark of the file is moved to the end of the newest window written without a window that failed before it, so it is moved to the time of the collection once all its windows were written and never past the data of a window that is missing.
+func (f *File) Close() error {
+	if f.columns != nil {
+		f.writeRows(true)
+	}
+	f.args.Metrics.AddRows(f.path, f.rows)
+	f.args.Summary.AddRows(f.path, f.rows)
+	f.rows = 0
+	//The windows go back in time from 0, the newest, so the written ones are walked from the oldest until the first that is missing.
+	for historyInterval := time.Duration(f.windows - 1); historyInterval >= 0 && f.written[historyInterval]; historyInterval-- {
+		f.args.Watermarks.Set(f.key, TimeRange(f.args, historyInterval).End)
+	}
+	return f.File.Close()
+}
+
+//Workload is a workload file collected by history window. The windows to collect are planned from the high-water mark of the file and the backfill checkpoint when it is created, and the file is written by WriteWorkload once the level is collected.
+type Workload struct {
+	*entity.Workload
+	args      *Parameters
+	path, key string
+	//windows is the number of history windows planned and history the history window of each window collected.
+	windows int
+	history []time.Duration
+}
+
+//NewWorkload returns the workload file of the entity kind, with the metric held by its values and the columns identifying its series, and plans the history windows to collect for it.
+//For incremental collections these are the windows since the high-water mark of the file, up to the maximum catch up, and none if the file is up to date. The first collection of a file collects the History.
+func NewWorkload(args *Parameters, entityKind, fileName, metric string, columns ...string) *Workload {
+	w := newWorkload(args, entityKind, fileName, metric, columns)
+	w.windows = *args.History
+	mark, ok := args.Watermarks.Get(w.key)
+	if !ok {
+		return w
+	}
+	size := args.IntervalSize
+	behind := args.CurrentTime.Sub(mark)
+	w.windows = int((behind + size - 1) / size)
+	if w.windows < 0 {
+		w.windows = 0
+	}
+	if limit := int(args.MaxCatchUp / size); args.MaxCatchUp > 0 && w.windows > limit {
+		if limit < 1 {
+			limit = 1
+		}
+		args.Logger.Warn("Only catching up the last "+strconv.Itoa(limit)+" windows, the data from "+mark.Format(time.RFC3339)+" to "+TimeRange(args, time.Duration(limit-1)).Start.Format(time.RFC3339)+" is past the maximum catch up and is skipped", "file", w.path)
+		w.windows = limit
+	}
+	return w
+}
+
+//NewCurrentWorkload returns a workload file that holds the values at the time of the collection, e.g. the current sizes of the controllers, in a single window. It isn't tracked by the high-water marks or the backfill checkpoint.
+func NewCurrentWorkload(args *Parameters, entityKind, fileName, metric string, columns ...string) *Workload {
+	return newWorkload(args, entityKind, fileName, metric, columns)
+}
+
+//WrapWorkload returns a workload collected elsewhere, e.g. by a caller of the public API, so it can be written by WriteWorkload. It isn't tracked by the high-water marks or the backfill checkpoint.
+func WrapWorkload(w *entity.Workload) *Workload {
+	return &Workload{Workload: w, history: make([]time.Duration, len(w.Windows))}
+}
+
+func newWorkload(args *Parameters, entityKind, fileName, metric string, columns []string) *Workload {
+	return &Workload{
+		Workload: &entity.Workload{Kind: entityKind, Name: fileName, Columns: columns, Metric: metric},
+		args:     args,
+		path:     args.OutputDir + "/" + entityKind + "/" + fileName + ".csv",
+		key:      watermark.Key(*args.ClusterName, entityKind, fileName+".csv"),
+	}
+}
+
+//Planned returns the number of history windows to collect for the file.
+func (w *Workload) Planned() int {
+	return w.windows
+}
+
+//ShareMark keys the high-water mark of the file on that of the other file, and plans the same windows, for files written from the same queries.
+func (w *Workload) ShareMark(other *Workload) {
+	w.key, w.windows = other.key, other.windows
+}
+
+//TimeRange returns the range of the history window of the file. The oldest window starts at the high-water mark of the file so an incremental collection doesn't query the data that was already written.
+func (w *Workload) TimeRange(historyInterval time.Duration) v1.Range {
+	promRange := TimeRange(w.args, historyInterval)
+	if mark, ok := w.args.Watermarks.Get(w.key); ok && promRange.Start.Before(mark) {
+		promRange.Start = mark
+	}
+	return promRange
+}
+
+//Done returns true if the history window was written to the file by an earlier run of the backfill being resumed, so it doesn't need to be collected again.
+func (w *Workload) Done(historyInterval time.Duration) bool {
+	return w.args.Checkpoint.Done(w.path, *w.args.ClusterName, TimeRange(w.args, historyInterval).End)
+}
+
+//Window adds the history window to the file and returns it for its series to be added. It is collected until a query of the window fails.
+func (w *Workload) Window(historyInterval time.Duration) *entity.WorkloadWindow {
+	promRange := w.TimeRange(historyInterval)
+	window := &entity.WorkloadWindow{Start: promRange.Start, End: promRange.End, Collected: true}
+	w.Windows = append(w.Windows, window)
+	w.history = append(w.history, historyInterval)
+	return window
+}
+
+//AddSeries adds the samples of the entity identified by keys to the window.
+func AddSeries(window *entity.WorkloadWindow, samples []model.SamplePair, keys ...string) {
+	series := &entity.Series{Keys: keys, Samples: make([]entity.Sample, len(samples))}
+	for i, sample := range samples {
+		series.Samples[i] = entity.Sample{Time: sample.Timestamp.Time(), Value: float64(sample.Value)}
+	}
+	window.Series = append(window.Series, series)
+}
+
+//WriteWorkload writes the workload file with the windows collected, each recorded as written in the backfill checkpoint and towards moving the high-water mark if it was collected.
+func WriteWorkload(args *Parameters, w *Workload) {
+	header := "cluster," + strings.Join(append(append([]string{}, w.Columns...), "Datetime", w.Metric), ",")
+	file, err := CreateFile(args, w.Kind, w.Name, header)
 	if err != nil {
-		args.ErrorLogger.Println("entity=" + entityKind + " message=" + err.Error())
-		fmt.Println("entity=" + entityKind + " message=" + err.Error())
+		args.Logger.Error(err.Error(), "entity", w.Kind, "metric", w.Metric)
+		return
+	}
+	file.key, file.windows = w.key, w.windows
+	for i, window := range w.Windows {
+		for _, series := range window.Series {
+			keys := ""
+			for _, key := range series.Keys {
+				keys += key + ","
+			}
+			for _, sample := range series.Samples {
+				fmt.Fprintf(file, "%s,%s%s,%f\n", *args.ClusterName, keys, sample.Time.Format("2006-01-02 15:04:05.000"), sample.Value)
+			}
+		}
+		//Only the planned windows are recorded, the current values aren't tracked.
+		if int(w.history[i]) < w.windows {
+			file.Complete(w.history[i], window.Collected)
+		}
+	}
+	file.Close()
+}
+
+//WriteWorkloads writes the workload files.
+func WriteWorkloads(args *Parameters, workloads []*Workload) {
+	for _, w := range workloads {
+		WriteWorkload(args, w)
+	}
+}
+
+//Complete records the history window as written to the file, in the backfill checkpoint and towards moving the high-water mark, if all its queries were collected. Windows with a query that failed or that were cut short by the run being cancelled aren't recorded so they are collected again when the backfill resumes or by the next incremental collection.
+func (f *File) Complete(historyInterval time.Duration, collected bool) {
+	if !collected || f.args.context().Err() != nil {
+		f.args.Checkpoint.Fail()
 		return
 	}
+	if f.written == nil {
+		f.written = map[time.Duration]bool{}
+	}
+	f.written[historyInterval] = true
+	if f.args.Checkpoint == nil {
+		return
+	}
+	info, err := f.Stat()
+	if err == nil {
+		err = f.args.Checkpoint.Complete(f.path, *f.args.ClusterName, TimeRange(f.args, historyInterval).End, info.Size())
+	}
+	if err != nil {
+		f.args.Logger.Error("Unable to save the backfill checkpoint: "+err.Error(), "file", f.path)
+	}
+}
+
+//CreateFile creates the csv file for the entity and writes out the header. If the file was already created during this run, as happens when collecting several clusters into the same files, it is opened for appending instead and the header is not written again.
+//When resuming a backfill the files with completed windows are kept, cut back to the end of the last completed window, and appended to.
+func CreateFile(args *Parameters, entityKind, fileName, header string) (*File, error) {
+	dir := args.OutputDir + "/" + entityKind
+	path := dir + "/" + fileName + ".csv"
+	key := watermark.Key(*args.ClusterName, entityKind, fileName+".csv")
+	if args.files == nil {
+		args.files = map[string]bool{}
+	}
+	if size, ok := args.Checkpoint.Size(path); ok && !args.files[path] {
+		if err := os.Truncate(path, size); err != nil {
+			return nil, err
+		}
+		args.files[path] = true
+	}
+	if args.files[path] {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return &File{File: file, path: path, args: args, key: key, columns: args.Anonymizer.Columns(header)}, nil
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	args.files[path] = true
+	fmt.Fprintln(file, header)
+	return &File{File: file, path: path, args: args, key: key, columns: args.Anonymizer.Columns(header)}, nil
+}
+
+//GetWorkload queries the workload data of the entity kind for the history windows of the file and returns it to be written by WriteWorkload.
+func GetWorkload(fileName, metricName, query string, metricfield model.LabelName, args *Parameters, entityKind string) *Workload {
+	var historyInterval time.Duration
+	historyInterval = 0
+	var result model.Value
+	var err error
+	workload := NewWorkload(args, entityKind, fileName, metricName, entityKind)
 	if entityKind == "cluster" {
-		fmt.Fprintf(workloadWrite, "cluster,Datetime,%s\n", metricName)
-	} else {
-		fmt.Fprintf(workloadWrite, "cluster,%s,Datetime,%s\n", entityKind, metricName)
+		workload = NewWorkload(args, entityKind, fileName, metricName)
 	}
 
 	//If the History parameter is set to anything but default 1 then will loop through the calls starting with the current day\hour\minute interval and work backwards.
 	//This is done as the farther you go back in time the slpwer prometheus querying becomes and we have seen cases where will not run from timeouts on Prometheus.
 	//As a result if we do hit an issue with timing out on Prometheus side we still can send the current data and data going back to that point vs losing it all.
-	for historyInterval = 0; int(historyInterval) < *args.History; historyInterval++ {
-		range5Min := TimeRange(args, historyInterval)
+	windows := workload.Planned()
+	queryArgs := args.PlanFor(entityKind, entityKind+"/"+fileName+".csv")
+	for historyInterval = 0; int(historyInterval) < windows; historyInterval++ {
+		if workload.Done(historyInterval) {
+			continue
+		}
+		window := workload.Window(historyInterval)
 
-		result = MetricCollect(args, query, range5Min, metricName, false)
+		result, err = CollectWindow(queryArgs, query, workload.TimeRange(historyInterval), metricName, false)
 		if result != nil {
-			writeWorkload(workloadWrite, result, metricfield, args, entityKind)
+			addWorkload(window, result, metricfield, entityKind)
 		}
+		window.Collected = err == nil
 	}
-	//Close the workload files.
-	workloadWrite.Close()
+	return workload
 }
 
-//writeWorkload will write out the workload data specific to metric provided to the file that was passed in.
-func writeWorkload(file io.Writer, result model.Value, metricfield model.LabelName, args *Parameters, entityKind string) {
-	//Loop through the results for the workload and validate that contains the required labels and that the entity exists in the systems data structure once validated will write out the workload for the system.
-	for i := 0; i < result.(model.Matrix).Len(); i++ {
-		var entity model.LabelValue
-		var ok bool
+//addWorkload adds the series of the result to the window, keyed by the entity in the metric field. Values that aren't numbers are written as 0.
+func addWorkload(window *entity.WorkloadWindow, result model.Value, metricfield model.LabelName, entityKind string) {
+	//Loop through the results for the workload and validate that contains the required labels.
+	for _, series := range result.(model.Matrix) {
+		var keys []string
 		if entityKind != "cluster" {
-			if entity, ok = result.(model.Matrix)[i].Metric[metricfield]; !ok {
+			name, ok := series.Metric[metricfield]
+			if !ok {
 				continue
 			}
+			keys = append(keys, strings.Replace(string(name), ";", ".", -1))
 		}
-		//Loop through the different values over the interval and write out each one to the workload file.
-		for j := 0; j < len(result.(model.Matrix)[i].Values); j++ {
-			var val model.SampleValue
-			if !math.IsNaN(float64(result.(model.Matrix)[i].Values[j].Value)) && !math.IsInf(float64(result.(model.Matrix)[i].Values[j].Value), 0) {
-				val = result.(model.Matrix)[i].Values[j].Value
-			}
-			fmt.Fprintf(file, "%s,", *args.ClusterName)
-			if entityKind != "cluster" {
-				fmt.Fprintf(file, "%s,", strings.Replace(string(entity), ";", ".", -1))
+		samples := make([]model.SamplePair, len(series.Values))
+		for j, sample := range series.Values {
+			samples[j].Timestamp = sample.Timestamp
+			if !math.IsNaN(float64(sample.Value)) && !math.IsInf(float64(sample.Value), 0) {
+				samples[j].Value = sample.Value
 			}
-			fmt.Fprintf(file, "%s,%f\n", time.Unix(0, int64(result.(model.Matrix)[i].Values[j].Timestamp)*1000000).Format("2006-01-02 15:04:05.000"), val)
 		}
+		AddSeries(window, samples, keys...)
 	}
 }
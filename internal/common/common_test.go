@@ -0,0 +1,200 @@
+package common
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/checkpoint"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/logging"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/watermark"
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+func TestClusterLabelValuesWindow(t *testing.T) {
+	var queries, times []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		queries = append(queries, r.Form.Get("query"))
+		times = append(times, r.Form.Get("time"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[` +
+			`{"metric":{"cluster":"west"},"value":[0,"1"]},{"metric":{"cluster":"east"},"value":[0,"1"]}]}}`))
+	}))
+	defer server.Close()
+	client, err := api.NewClient(api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, err := logging.New(ioutil.Discard, logging.Logfmt, logging.InfoLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	currentTime, history := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), 3
+	args := &Parameters{CurrentTime: &currentTime, History: &history, IntervalSize: time.Hour, Logger: logger}
+	values, metric := clusterLabelValues(context.Background(), v1.NewAPI(client), args, "cluster")
+	if metric != "kube_node_info" || len(values) != 2 || values[0] != "east" || values[1] != "west" {
+		t.Errorf("got %v on %q, want [east west] on kube_node_info", values, metric)
+	}
+	if want := `count(count_over_time(kube_node_info{cluster!=""}[3h])) by (cluster)`; len(queries) != 1 || queries[0] != want {
+		t.Errorf("queries %q, want %q", queries, want)
+	}
+	if want := "2024-03-01T12:00:00Z"; len(times) != 1 || times[0] != want {
+		t.Errorf("queried at %q, want the current time %s", times, want)
+	}
+}
+
+func TestWriteWorkload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logger, err := logging.New(ioutil.Discard, logging.Logfmt, logging.InfoLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusterName, history, currentTime := "east", 3, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	marks := watermark.New(dir+"/state.json", "")
+	args := &Parameters{ClusterName: &clusterName, History: &history, CurrentTime: &currentTime, IntervalSize: time.Hour, Logger: logger, OutputDir: dir, Watermarks: marks}
+
+	//The newest window is collected, the middle one has a query that failed and the oldest is collected, so the mark only moves to the end of the oldest.
+	w := NewWorkload(args, "node", "cpu_utilization", "CPU Utilization", "node")
+	if w.Planned() != 3 {
+		t.Fatalf("planned %d windows, want the history of 3", w.Planned())
+	}
+	for h := time.Duration(0); int(h) < w.Planned(); h++ {
+		window := w.Window(h)
+		window.Collected = h != 1
+		AddSeries(window, []model.SamplePair{{Timestamp: model.TimeFromUnix(window.End.Unix()), Value: model.SampleValue(h)}}, "n1")
+	}
+	WriteWorkload(args, w)
+
+	b, err := ioutil.ReadFile(dir + "/node/cpu_utilization.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := func(end time.Time) string { return end.Local().Format("2006-01-02 15:04:05.000") }
+	want := "cluster,node,Datetime,CPU Utilization\n" +
+		"east,n1," + format(currentTime) + ",0.000000\n" +
+		"east,n1," + format(currentTime.Add(-time.Hour)) + ",1.000000\n" +
+		"east,n1," + format(currentTime.Add(-2*time.Hour)) + ",2.000000\n"
+	if string(b) != want {
+		t.Errorf("file\n%s\nwant\n%s", b, want)
+	}
+	if mark, ok := marks.Get(watermark.Key("east", "node", "cpu_utilization.csv")); !ok || !mark.Equal(currentTime.Add(-2*time.Hour)) {
+		t.Errorf("mark = %s, %v, want the end of the oldest window %s", mark, ok, currentTime.Add(-2*time.Hour))
+	}
+}
+
+func TestCreateFileResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(dir+"/node", 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := dir + "/node/cpu_utilization.csv"
+	completed := "cluster,node,Datetime,CPU Utilization\neast,n1,2024-03-01 12:00:00.000,1.000000\n"
+	if err := ioutil.WriteFile(path, []byte(completed+"east,n1,2024-03-01 11:00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	//The crashed backfill completed the first window, so the partial row written after it is cut off when the file is created again.
+	clusterName, history, currentTime := "east", 2, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cp := checkpoint.New(dir+"/checkpoint.json", checkpoint.Window{End: currentTime, History: history})
+	if err := cp.Complete(path, clusterName, currentTime, int64(len(completed))); err != nil {
+		t.Fatal(err)
+	}
+	args := &Parameters{ClusterName: &clusterName, History: &history, CurrentTime: &currentTime, IntervalSize: time.Hour, OutputDir: dir, Checkpoint: cp}
+	file, err := CreateFile(args, "node", "cpu_utilization", "cluster,node,Datetime,CPU Utilization")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("east,n1,2024-03-01 11:00:00.000,2.000000\n")
+	file.Close()
+
+	//A second cluster in the same run appends to the file rather than cutting it off again.
+	file, err = CreateFile(args, "node", "cpu_utilization", "cluster,node,Datetime,CPU Utilization")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("west,n2,2024-03-01 12:00:00.000,3.000000\n")
+	file.Close()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := completed + "east,n1,2024-03-01 11:00:00.000,2.000000\nwest,n2,2024-03-01 12:00:00.000,3.000000\n"
+	if string(b) != want {
+		t.Errorf("file\n%s\nwant\n%s", b, want)
+	}
+}
+
+func TestNewWorkloadPlanned(t *testing.T) {
+	logger, err := logging.New(ioutil.Discard, logging.Logfmt, logging.InfoLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		behind     time.Duration
+		marked     bool
+		maxCatchUp time.Duration
+		windows    int
+	}{
+		{"first collection", 0, false, 0, 24},
+		{"first collection past the maximum catch up", 0, false, 2 * time.Hour, 24},
+		{"up to date", 0, true, 0, 0},
+		{"mark ahead", -time.Hour, true, 0, 0},
+		{"three behind", 3 * time.Hour, true, 0, 3},
+		{"part of a window behind", 150 * time.Minute, true, 0, 3},
+		{"within the maximum catch up", 3 * time.Hour, true, 3 * time.Hour, 3},
+		{"past the maximum catch up", 10 * time.Hour, true, 2 * time.Hour, 2},
+		{"maximum catch up under a window", 10 * time.Hour, true, 30 * time.Minute, 1},
+	}
+	for _, test := range tests {
+		clusterName, history := "east", 24
+		marks := watermark.New("", "")
+		mark := currentTime.Add(-test.behind)
+		if test.marked {
+			marks.Set(watermark.Key(clusterName, "node", "cpu_utilization.csv"), mark)
+		}
+		args := &Parameters{ClusterName: &clusterName, History: &history, CurrentTime: &currentTime, IntervalSize: time.Hour, Logger: logger, Watermarks: marks, MaxCatchUp: test.maxCatchUp}
+
+		w := NewWorkload(args, "node", "cpu_utilization", "CPU Utilization", "node")
+		if w.Planned() != test.windows {
+			t.Errorf("%s: planned %d windows, want %d", test.name, w.Planned(), test.windows)
+			continue
+		}
+		//The oldest window starts at the mark, unless the windows were cut short by the maximum catch up.
+		if test.marked && test.windows > 0 {
+			start := w.TimeRange(time.Duration(test.windows - 1)).Start
+			want := currentTime.Add(-time.Duration(test.windows) * time.Hour)
+			if mark.After(want) {
+				want = mark
+			}
+			if !start.Equal(want) {
+				t.Errorf("%s: oldest window starts at %s, want %s", test.name, start, want)
+			}
+		}
+
+		//A file written from the same queries plans the same windows and moves the same mark.
+		extra := NewWorkload(args, "node", "cpu_extra", "CPU Extra", "node")
+		extra.ShareMark(w)
+		if extra.Planned() != w.Planned() || extra.key != w.key {
+			t.Errorf("%s: sharing the mark planned %d windows with the key %s, want %d with %s", test.name, extra.Planned(), extra.key, w.Planned(), w.key)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//LabelKinds are the kinds of entity the label filters can be given for. The pod labels are those of the controllers, which gather the labels of their pods, and the node labels are also used for the node groups.
+var LabelKinds = []string{"container", "pod", "namespace", "node", "hpa"}
+
+//internalLabels are added by Prometheus or the scrape configuration rather than describing the entity, so they are dropped unless the internal labels are kept.
+var internalLabels = map[string]bool{
+	"__name__": true, "instance": true, "job": true, "uid": true, "endpoint": true, "service": true, "prometheus": true, "prometheus_replica": true,
+}
+
+//LabelFilter decides which labels and annotations are kept in the labels of each kind of entity written to the attributes. A nil LabelFilter drops the internal labels and keeps the rest.
+//It is only applied when the labels attributes are written, the label selector and the label columns see all the labels gathered.
+type LabelFilter struct {
+	//allow and deny hold the patterns of each kind of entity, those for every kind are under the empty kind.
+	allow, deny  map[string][]*regexp.Regexp
+	keepInternal bool
+}
+
+//NewLabelFilter reads the allow and deny lists. Each entry is a regular expression matching the whole name of the label, e.g. annotation_.*, optionally prefixed with the kind of entity it applies to, e.g. pod:label_team. Entries without a kind apply to every kind.
+func NewLabelFilter(allow, deny []string, keepInternal bool) (*LabelFilter, error) {
+	f := &LabelFilter{allow: map[string][]*regexp.Regexp{}, deny: map[string][]*regexp.Regexp{}, keepInternal: keepInternal}
+	for _, list := range []struct {
+		name     string
+		entries  []string
+		patterns map[string][]*regexp.Regexp
+	}{{"label_allow", allow, f.allow}, {"label_deny", deny, f.deny}} {
+		for _, entry := range list.entries {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			kind, pattern := "", entry
+			if i := strings.Index(entry, ":"); i >= 0 {
+				kind, pattern = strings.ToLower(entry[:i]), entry[i+1:]
+				found := false
+				for _, k := range LabelKinds {
+					found = found || k == kind
+				}
+				if !found {
+					return nil, fmt.Errorf("invalid %s entry %q, the kind must be one of %s", list.name, entry, strings.Join(LabelKinds, ", "))
+				}
+			}
+			re, err := regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s entry %q: %s", list.name, entry, err)
+			}
+			list.patterns[kind] = append(list.patterns[kind], re)
+		}
+	}
+	return f, nil
+}
+
+//Keep returns true if the label is kept for the kind of entity. A label is kept if it isn't internal, matches the allow list when there is one for the kind and doesn't match the deny list.
+func (f *LabelFilter) Keep(kind, label string) bool {
+	if f == nil {
+		return !internalLabels[label]
+	}
+	if internalLabels[label] && !f.keepInternal {
+		return false
+	}
+	if len(f.allow[""])+len(f.allow[kind]) > 0 && !matchAny(f.allow[""], label) && !matchAny(f.allow[kind], label) {
+		return false
+	}
+	return !matchAny(f.deny[""], label) && !matchAny(f.deny[kind], label)
+}
+
+func matchAny(patterns []*regexp.Regexp, label string) bool {
+	for _, re := range patterns {
+		if re.MatchString(label) {
+			return true
+		}
+	}
+	return false
+}
+
+//columnKinds are the kinds of entity a label column can be mapped to, in the order their labels are looked up.
+var columnKinds = []string{"container", "pod", "namespace", "node"}
+
+//labelSource is a label a column takes its value from, only looked up in the labels of the kind of entity if the kind is set.
+type labelSource struct {
+	kind, label string
+}
+
+//LabelColumns maps labels to columns of their own in the attributes, e.g. label_team to Business Unit, so they can be used in Densify without parsing the labels columns. A nil LabelColumns adds no columns.
+type LabelColumns struct {
+	names   []string
+	sources map[string][]labelSource
+}
+
+//NewLabelColumns reads the mapping of the labels to the columns. Each entry is the name of a label, optionally prefixed with the kind of entity it is looked up in, and the name of the column, e.g. namespace:label_owner=Application Owner.
+//Several labels can be mapped to the same column, the first one found is used.
+func NewLabelColumns(entries []string) (*LabelColumns, error) {
+	c := &LabelColumns{sources: map[string][]labelSource{}}
+	for _, entry := range entries {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		i := strings.Index(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid label_columns entry %q, it must be label=column", entry)
+		}
+		source, name := labelSource{label: strings.TrimSpace(entry[:i])}, strings.TrimSpace(entry[i+1:])
+		if j := strings.Index(source.label, ":"); j >= 0 {
+			source.kind, source.label = strings.ToLower(source.label[:j]), source.label[j+1:]
+			found := false
+			for _, k := range columnKinds {
+				found = found || k == source.kind
+			}
+			if !found {
+				return nil, fmt.Errorf("invalid label_columns entry %q, the kind must be one of %s", entry, strings.Join(columnKinds, ", "))
+			}
+		}
+		if source.label == "" || name == "" || strings.ContainsAny(name, "\"\n") {
+			return nil, fmt.Errorf("invalid label_columns entry %q, it must be label=column", entry)
+		}
+		if _, ok := c.sources[name]; !ok {
+			c.names = append(c.names, name)
+		}
+		c.sources[name] = append(c.sources[name], source)
+	}
+	return c, nil
+}
+
+//Header returns the header with the names of the columns added.
+func (c *LabelColumns) Header(header string) string {
+	if c == nil || len(c.names) == 0 {
+		return header
+	}
+	return header + "," + strings.Join(c.names, ",")
+}
+
+//Columns returns the label of each column, the first one if several are mapped to it.
+func (c *LabelColumns) Columns() map[string]string {
+	columns := map[string]string{}
+	if c == nil {
+		return columns
+	}
+	for name, sources := range c.sources {
+		columns[name] = sources[0].label
+	}
+	return columns
+}
+
+//Row returns the values of the columns, each preceded by a comma, from the labels of each kind of entity. A label is looked up in the labels of the container, then of the controller (pod) and then of the namespace, so a workload can override the label of its namespace.
+func (c *LabelColumns) Row(labels map[string]map[string]string) string {
+	if c == nil {
+		return ""
+	}
+	var row strings.Builder
+	for _, name := range c.names {
+		row.WriteString(",")
+		row.WriteString(strings.Replace(c.value(name, labels), ",", " ", -1))
+	}
+	return row.String()
+}
+
+func (c *LabelColumns) value(name string, labels map[string]map[string]string) string {
+	for _, source := range c.sources[name] {
+		for _, kind := range columnKinds {
+			if source.kind != "" && source.kind != kind {
+				continue
+			}
+			if value, ok := labels[kind][source.label]; ok {
+				return value
+			}
+		}
+	}
+	return ""
+}
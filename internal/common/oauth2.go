@@ -0,0 +1,158 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//tokenExpiryDelta is how long before the reported expiry a token is treated as expired so it is never sent right as it lapses.
+const tokenExpiryDelta = time.Minute
+
+//tokenTimeout bounds a token request so a token endpoint that doesn't answer can't hold up every query behind it.
+const tokenTimeout = 30 * time.Second
+
+//oAuth2RoundTripper adds an OAuth2 bearer token obtained with the client credentials grant to each request and refreshes it when it expires.
+type oAuth2RoundTripper struct {
+	tokenURL, clientID, clientSecretPath string
+	scopes                               []string
+	rt                                   http.RoundTripper
+	timeout                              time.Duration
+
+	mtx    sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+//tokenResponse is the subset of the token endpoint response that is used.
+type tokenResponse struct {
+	AccessToken string      `json:"access_token"`
+	TokenType   string      `json:"token_type"`
+	ExpiresIn   json.Number `json:"expires_in"`
+}
+
+//NewOAuth2RoundTripper returns a round tripper that authenticates requests with tokens from tokenURL using the client credentials grant.
+//The client secret is read from clientSecretPath every time a token is requested so a rotated secret is picked up without a restart.
+func NewOAuth2RoundTripper(tokenURL, clientID, clientSecretPath string, scopes []string, rt http.RoundTripper) http.RoundTripper {
+	return &oAuth2RoundTripper{tokenURL: tokenURL, clientID: clientID, clientSecretPath: clientSecretPath, scopes: scopes, rt: rt, timeout: tokenTimeout}
+}
+
+func (rt *oAuth2RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := rt.getToken(req.Context(), false)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := rt.rt.RoundTrip(withBearer(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	//The token may have been revoked before its expiry so fetch a new one and retry once if the request can be replayed.
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	token, err = rt.getToken(req.Context(), true)
+	if err != nil {
+		return resp, nil
+	}
+	retry := withBearer(req, token)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	return rt.rt.RoundTrip(retry)
+}
+
+//getToken returns the cached token or requests a new one when there is none, it is about to expire or force is set.
+//The token request is cancelled with ctx, the context of the request being authenticated, and after the timeout.
+func (rt *oAuth2RoundTripper) getToken(ctx context.Context, force bool) (string, error) {
+	rt.mtx.Lock()
+	defer rt.mtx.Unlock()
+
+	if !force && rt.token != "" && time.Now().Add(tokenExpiryDelta).Before(rt.expiry) {
+		return rt.token, nil
+	}
+
+	secret, err := ioutil.ReadFile(rt.clientSecretPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to read OAuth2 client secret file %s: %s", rt.clientSecretPath, err)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", rt.clientID)
+	form.Set("client_secret", strings.TrimSpace(string(secret)))
+	if len(rt.scopes) > 0 {
+		form.Set("scope", strings.Join(rt.scopes, " "))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, rt.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("unable to create OAuth2 token request: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, rt.timeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := rt.rt.RoundTrip(req)
+	if err != nil {
+		return "", fmt.Errorf("unable to get OAuth2 token from %s: %s", rt.tokenURL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("unable to read OAuth2 token response from %s: %s", rt.tokenURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("OAuth2 token endpoint %s returned %s: %s", rt.tokenURL, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return "", fmt.Errorf("unable to parse OAuth2 token response from %s: %s", rt.tokenURL, err)
+	}
+	if tr.AccessToken == "" {
+		return "", fmt.Errorf("OAuth2 token endpoint %s returned no access_token", rt.tokenURL)
+	}
+	if tr.TokenType != "" && !strings.EqualFold(tr.TokenType, "bearer") {
+		return "", fmt.Errorf("OAuth2 token endpoint %s returned unsupported token_type %s", rt.tokenURL, tr.TokenType)
+	}
+
+	rt.token = tr.AccessToken
+	//Tokens without an expiry are reused until the server rejects them.
+	rt.expiry = time.Now().Add(time.Hour * 24 * 365)
+	if expiresIn, err := tr.ExpiresIn.Int64(); err == nil && expiresIn > 0 {
+		rt.expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return rt.token, nil
+}
+
+func (rt *oAuth2RoundTripper) CloseIdleConnections() {
+	if ci, ok := rt.rt.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+//withBearer returns a copy of the request with the Authorization header set to the token.
+func withBearer(req *http.Request, token string) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
@@ -0,0 +1,201 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//tokenServer is a local token endpoint and Prometheus that records the token requests and rejects the tokens listed in revoked.
+//The token requests don't get an answer while hang is set.
+type tokenServer struct {
+	mu        sync.Mutex
+	hang      bool
+	expiresIn int
+	forms     []map[string]string
+	revoked   map[string]bool
+	seen      []string
+}
+
+func (s *tokenServer) handler(t *testing.T) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("token request method = %s, want POST", r.Method)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-www-form-urlencoded" {
+			t.Errorf("token request Content-Type = %q", ct)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.hang {
+			s.mu.Unlock()
+			<-r.Context().Done()
+			s.mu.Lock()
+			return
+		}
+		form := map[string]string{}
+		for key := range r.PostForm {
+			form[key] = r.PostForm.Get(key)
+		}
+		s.forms = append(s.forms, form)
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%d}`, len(s.forms), s.expiresIn)
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.seen = append(s.seen, token)
+		if s.revoked[token] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprint(w, string(body))
+	})
+	return mux
+}
+
+//newTestRoundTripper starts the token server and returns it with a round tripper using it, and the function cleaning them up.
+func newTestRoundTripper(t *testing.T, s *tokenServer, scopes []string) (*httptest.Server, http.RoundTripper, func()) {
+	server := httptest.NewServer(s.handler(t))
+	secret, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.WriteString("s3cret &=+\n")
+	secret.Close()
+	cleanup := func() {
+		server.Close()
+		os.Remove(secret.Name())
+	}
+	return server, NewOAuth2RoundTripper(server.URL+"/token", "client id", secret.Name(), scopes, http.DefaultTransport), cleanup
+}
+
+func get(t *testing.T, rt http.RoundTripper, url, body string) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	return resp
+}
+
+func TestOAuth2FirstFetchAndForm(t *testing.T) {
+	s := &tokenServer{expiresIn: 3600}
+	server, rt, cleanup := newTestRoundTripper(t, s, []string{"read", "metrics:query"})
+	defer cleanup()
+
+	for i := 0; i < 2; i++ {
+		if resp := get(t, rt, server.URL+"/api", ""); resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want 200", resp.StatusCode)
+		}
+	}
+	if len(s.forms) != 1 {
+		t.Fatalf("token requests = %d, want 1 as the token is cached", len(s.forms))
+	}
+	want := map[string]string{"grant_type": "client_credentials", "client_id": "client id", "client_secret": "s3cret &=+", "scope": "read metrics:query"}
+	for key, value := range want {
+		if s.forms[0][key] != value {
+			t.Errorf("form %s = %q, want %q", key, s.forms[0][key], value)
+		}
+	}
+	if s.seen[0] != "token-1" || s.seen[1] != "token-1" {
+		t.Errorf("tokens sent = %v, want token-1 twice", s.seen)
+	}
+}
+
+func TestOAuth2NoScopes(t *testing.T) {
+	s := &tokenServer{expiresIn: 3600}
+	server, rt, cleanup := newTestRoundTripper(t, s, nil)
+	defer cleanup()
+	get(t, rt, server.URL+"/api", "")
+	if _, ok := s.forms[0]["scope"]; ok {
+		t.Errorf("scope sent without scopes: %v", s.forms[0])
+	}
+}
+
+func TestOAuth2RefreshOnExpiry(t *testing.T) {
+	//Tokens expiring within tokenExpiryDelta are treated as expired, so every request fetches a new one.
+	s := &tokenServer{expiresIn: 30}
+	server, rt, cleanup := newTestRoundTripper(t, s, nil)
+	defer cleanup()
+	get(t, rt, server.URL+"/api", "")
+	get(t, rt, server.URL+"/api", "")
+	if len(s.forms) != 2 {
+		t.Fatalf("token requests = %d, want 2", len(s.forms))
+	}
+	if s.seen[0] != "token-1" || s.seen[1] != "token-2" {
+		t.Errorf("tokens sent = %v, want token-1 then token-2", s.seen)
+	}
+}
+
+func TestOAuth2RetryOnceOn401(t *testing.T) {
+	s := &tokenServer{expiresIn: 3600, revoked: map[string]bool{"token-1": true}}
+	server, rt, cleanup := newTestRoundTripper(t, s, nil)
+	defer cleanup()
+	resp := get(t, rt, server.URL+"/api", "query=up")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200 after the retry", resp.StatusCode)
+	}
+	if len(s.seen) != 2 || s.seen[0] != "token-1" || s.seen[1] != "token-2" {
+		t.Errorf("tokens sent = %v, want token-1 then token-2", s.seen)
+	}
+
+	//A token rejected again after the refresh isn't retried a second time.
+	s.mu.Lock()
+	s.revoked["token-2"], s.revoked["token-3"] = true, true
+	s.seen = nil
+	s.mu.Unlock()
+	if resp := get(t, rt, server.URL+"/api", "query=up"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", resp.StatusCode)
+	}
+	if len(s.seen) != 2 {
+		t.Errorf("requests = %d, want 2 (one retry)", len(s.seen))
+	}
+}
+
+func TestOAuth2TokenRequestCancelled(t *testing.T) {
+	s := &tokenServer{expiresIn: 3600, hang: true}
+	server, rt, cleanup := newTestRoundTripper(t, s, nil)
+	defer cleanup()
+
+	//The token request stops with the request it authenticates.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := rt.RoundTrip(req.WithContext(ctx)); err == nil {
+		t.Fatal("no error while the token endpoint hangs")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled request returned after %s", elapsed)
+	}
+
+	//Without a deadline on the request the token request times out on its own.
+	rt.(*oAuth2RoundTripper).timeout = 50 * time.Millisecond
+	start = time.Now()
+	if _, err := rt.RoundTrip(req); err == nil {
+		t.Fatal("no error while the token endpoint hangs")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("token request timed out after %s", elapsed)
+	}
+}
@@ -0,0 +1,212 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//promQLKeywords are identifiers that are not metric names. The grouping keywords are followed by a list of label names rather than an expression.
+var promQLKeywords = map[string]bool{
+	"by": true, "without": true, "on": true, "ignoring": true, "group_left": true, "group_right": true,
+	"bool": true, "offset": true, "and": true, "or": true, "unless": true, "atan2": true, "inf": true, "nan": true,
+}
+
+var promQLGroupingKeywords = map[string]bool{
+	"by": true, "without": true, "on": true, "ignoring": true, "group_left": true, "group_right": true,
+}
+
+//promQLAggregations can have the grouping clause before the parenthesis, e.g. sum by (node) (...), so they aren't always followed by one.
+var promQLAggregations = map[string]bool{
+	"sum": true, "min": true, "max": true, "avg": true, "group": true, "stddev": true, "stdvar": true,
+	"count": true, "count_values": true, "bottomk": true, "topk": true, "quantile": true,
+}
+
+//InjectMatcher adds the label matcher (e.g. cluster="a") to every series selector in the query so it only returns data for the series that match.
+//Selectors that already have matchers get the matcher added to the list, unless it is already in it, and bare metric names get a new matcher list.
+func InjectMatcher(query, matcher string) string {
+	return injectMatcher(query, matcher, func(string) bool { return true })
+}
+
+//InjectPodMatcher adds the label matcher only to the selectors of the kube_pod metrics of kube-state-metrics, e.g. so the requests and limits summed for a node are only those of the namespaces collected while the node capacity isn't filtered.
+func InjectPodMatcher(query, matcher string) string {
+	return injectMatcher(query, matcher, func(metric string) bool { return strings.HasPrefix(metric, "kube_pod_") })
+}
+
+//NamespaceMatcher returns the label matchers that keep the namespaces of the include list, or all of them if it is empty, and drop those of the exclude list. The entries are exact names or regular expressions matching the whole name, e.g. kube-.*.
+func NamespaceMatcher(include, exclude []string) (string, error) {
+	var matchers []string
+	for _, list := range []struct {
+		op      string
+		entries []string
+	}{{"=~", include}, {"!~", exclude}} {
+		var entries []string
+		for _, entry := range list.entries {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			if _, err := regexp.Compile("^(?:" + entry + ")$"); err != nil {
+				return "", fmt.Errorf("invalid namespace %q: %s", entry, err)
+			}
+			entries = append(entries, entry)
+		}
+		if len(entries) > 0 {
+			matchers = append(matchers, "namespace"+list.op+strconv.Quote(strings.Join(entries, "|")))
+		}
+	}
+	return strings.Join(matchers, ","), nil
+}
+
+//injectMatcher adds the label matcher to the selectors of the metrics for which inject returns true. Selectors without a metric name are only given the matcher if inject accepts an empty name.
+func injectMatcher(query, matcher string, inject func(metric string) bool) string {
+	if matcher == "" {
+		return query
+	}
+
+	var out strings.Builder
+	braceDepth, bracketDepth := 0, 0
+	inLabelList, pendingLabelList := false, false
+	//metric is the metric name of the selector whose matchers are reached next.
+	metric := ""
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			end := scanString(query, i)
+			out.WriteString(query[i:end])
+			i = end
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			out.WriteByte(c)
+			i++
+			continue
+		case isIdentStart(c):
+			end := i + 1
+			for end < len(query) && isIdentChar(query[end]) {
+				end++
+			}
+			ident := query[i:end]
+			out.WriteString(ident)
+			i = end
+			if braceDepth > 0 || bracketDepth > 0 || inLabelList {
+				continue
+			}
+			if promQLKeywords[strings.ToLower(ident)] {
+				pendingLabelList = promQLGroupingKeywords[strings.ToLower(ident)]
+				metric = ""
+				continue
+			}
+			pendingLabelList = false
+			if promQLAggregations[strings.ToLower(ident)] {
+				continue
+			}
+			//Functions and aggregations are followed by a parenthesis and selectors with matchers are handled when the brace is reached.
+			if next := nextSignificant(query, i); next == '(' {
+				metric = ""
+				continue
+			} else if next == '{' {
+				metric = ident
+				continue
+			}
+			if inject(ident) {
+				out.WriteString("{" + matcher + "}")
+			}
+			continue
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9':
+			//Numbers and durations such as 5m or 1e3 are consumed whole so their suffix isn't taken for a metric name.
+			end := i + 1
+			for end < len(query) && (isIdentChar(query[end]) || query[end] == '.') {
+				end++
+			}
+			out.WriteString(query[i:end])
+			i = end
+			pendingLabelList = false
+			continue
+		}
+
+		out.WriteByte(c)
+		i++
+		switch c {
+		case '{':
+			braceDepth++
+			if braceDepth == 1 && inject(metric) && !hasMatcher(query, i, matcher) {
+				out.WriteString(matcher)
+				if nextSignificant(query, i) != '}' {
+					out.WriteString(",")
+				}
+			}
+			metric = ""
+		case '}':
+			braceDepth--
+		case '[':
+			bracketDepth++
+		case ']':
+			bracketDepth--
+		case '(':
+			if pendingLabelList {
+				inLabelList = true
+			}
+		case ')':
+			inLabelList = false
+		}
+		pendingLabelList = false
+	}
+	return out.String()
+}
+
+//scanString returns the index just past the string literal starting at start.
+func scanString(query string, start int) int {
+	quote := query[start]
+	for i := start + 1; i < len(query); i++ {
+		if query[i] == '\\' && quote != '`' {
+			i++
+			continue
+		}
+		if query[i] == quote {
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+//hasMatcher returns whether the matchers of the selector starting at start, just past its brace, already include the matcher.
+func hasMatcher(query string, start int, matcher string) bool {
+	from := start
+	for i := start; i < len(query); {
+		switch query[i] {
+		case '"', '\'', '`':
+			i = scanString(query, i)
+			continue
+		case ',', '}':
+			if strings.TrimSpace(query[from:i]) == matcher {
+				return true
+			}
+			if query[i] == '}' {
+				return false
+			}
+			from = i + 1
+		}
+		i++
+	}
+	return false
+}
+
+//nextSignificant returns the next character that isn't white space, or 0 at the end of the query.
+func nextSignificant(query string, start int) byte {
+	for i := start; i < len(query); i++ {
+		if c := query[i]; c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			return c
+		}
+	}
+	return 0
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
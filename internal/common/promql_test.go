@@ -0,0 +1,86 @@
+package common
+
+import "testing"
+
+func TestInjectMatcher(t *testing.T) {
+	const matcher = `cluster="a"`
+	tests := []struct {
+		name, query, want string
+	}{
+		{"bare metric", `up`, `up{cluster="a"}`},
+		{"recording rule name", `job:rate5m`, `job:rate5m{cluster="a"}`},
+		{"existing matchers", `up{job="x"}`, `up{cluster="a",job="x"}`},
+		{"empty matchers", `up{ }`, `up{cluster="a" }`},
+		{"no metric name", `{__name__="up"}`, `{cluster="a",__name__="up"}`},
+		{"matcher already present", `up{job="x", cluster="a"}`, `up{job="x", cluster="a"}`},
+		{"other cluster kept", `up{cluster="b"}`, `up{cluster="a",cluster="b"}`},
+		{"string with braces", `up{path=~"/{a}/.*",x='}'}`, `up{cluster="a",path=~"/{a}/.*",x='}'}`},
+		{"string arguments", `label_replace(up, "dst", "$1 {x}", "src", "(.*)")`, `label_replace(up{cluster="a"}, "dst", "$1 {x}", "src", "(.*)")`},
+		{"range selector", `rate(x_total[5m])`, `rate(x_total{cluster="a"}[5m])`},
+		{"subquery", `max_over_time(rate(x_total{job="j"}[5m])[1h:5m])`, `max_over_time(rate(x_total{cluster="a",job="j"}[5m])[1h:5m])`},
+		{"offset", `x offset 5m`, `x{cluster="a"} offset 5m`},
+		{"range offset", `rate(x[5m] offset 1h)`, `rate(x{cluster="a"}[5m] offset 1h)`},
+		{"by before", `sum by (node, pod) (rate(x[5m]))`, `sum by (node, pod) (rate(x{cluster="a"}[5m]))`},
+		{"by after", `sum(x) by (node)`, `sum(x{cluster="a"}) by (node)`},
+		{"without", `max without (instance) (x)`, `max without (instance) (x{cluster="a"})`},
+		{"on group_left", `a * on (namespace, pod) group_left (node) b`, `a{cluster="a"} * on (namespace, pod) group_left (node) b{cluster="a"}`},
+		{"ignoring", `a / ignoring(container) b{c="d"}`, `a{cluster="a"} / ignoring(container) b{cluster="a",c="d"}`},
+		{"bool and numbers", `a > bool 1e3 - 0.5`, `a{cluster="a"} > bool 1e3 - 0.5`},
+		{"aggregation parameters", `topk(5, x) or count_values("v", y)`, `topk(5, x{cluster="a"}) or count_values("v", y{cluster="a"})`},
+		{"keyword case", `sum(x) BY (node)`, `sum(x{cluster="a"}) BY (node)`},
+	}
+	for _, test := range tests {
+		if got := InjectMatcher(test.query, matcher); got != test.want {
+			t.Errorf("%s: InjectMatcher(%q) = %q, want %q", test.name, test.query, got, test.want)
+		}
+	}
+	if got := InjectMatcher(`up`, ""); got != `up` {
+		t.Errorf("InjectMatcher with no matcher = %q, want the query unchanged", got)
+	}
+}
+
+func TestInjectPodMatcher(t *testing.T) {
+	const matcher = `namespace=~"a|b"`
+	tests := []struct {
+		query, want string
+	}{
+		{
+			`sum(kube_pod_container_resource_requests{resource="cpu"}) by (node) / sum(kube_node_status_capacity{resource="cpu"}) by (node)`,
+			`sum(kube_pod_container_resource_requests{namespace=~"a|b",resource="cpu"}) by (node) / sum(kube_node_status_capacity{resource="cpu"}) by (node)`,
+		},
+		{`count(kube_pod_info) by (node)`, `count(kube_pod_info{namespace=~"a|b"}) by (node)`},
+		{`kube_node_info * on (node) group_left kube_pod_info`, `kube_node_info * on (node) group_left kube_pod_info{namespace=~"a|b"}`},
+		{`{__name__="kube_pod_info"}`, `{__name__="kube_pod_info"}`},
+	}
+	for _, test := range tests {
+		if got := InjectPodMatcher(test.query, matcher); got != test.want {
+			t.Errorf("InjectPodMatcher(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestNamespaceMatcher(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude []string
+		want             string
+	}{
+		{"none", nil, nil, ``},
+		{"blank entries", []string{" ", ""}, []string{""}, ``},
+		{"include", []string{"default", " kube-.* "}, nil, `namespace=~"default|kube-.*"`},
+		{"exclude", nil, []string{"kube-system"}, `namespace!~"kube-system"`},
+		{"include and exclude", []string{"team-.*"}, []string{"team-test"}, `namespace=~"team-.*",namespace!~"team-test"`},
+		{"quoted", []string{`kube\.system`}, []string{`a"b`}, `namespace=~"kube\\.system",namespace!~"a\"b"`},
+	}
+	for _, test := range tests {
+		got, err := NamespaceMatcher(test.include, test.exclude)
+		if err != nil {
+			t.Errorf("%s: NamespaceMatcher returned %v", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%s: NamespaceMatcher = %q, want %q", test.name, got, test.want)
+		}
+	}
+	if _, err := NamespaceMatcher([]string{"ok"}, []string{"(bad"}); err == nil {
+		t.Error("NamespaceMatcher accepted an invalid regular expression")
+	}
+}
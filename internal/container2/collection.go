@@ -2,17 +2,16 @@
 package container2
 
 import (
-	"fmt"
-	"os"
 	"strconv"
 	"time"
 
 	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/entity"
 	"github.com/prometheus/common/model"
 )
 
 //getContainerMetric is used to parse the results from Prometheus related to Container Entities and store them in the systems data structure.
-func getContainerMetric(result model.Value, namespace, pod, container model.LabelName, metric string) bool {
+func (c *Collector) getContainerMetric(result model.Value, namespace, pod, container model.LabelName, metric string) bool {
 	var status = false
 	//Validate there is data in the results.
 	if result == nil {
@@ -25,7 +24,7 @@ func getContainerMetric(result model.Value, namespace, pod, container model.Labe
 		if test == false {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)]; ok == false {
+		if _, ok := c.systems[string(namespaceValue)]; ok == false {
 			continue
 		}
 		//Validate that the data contains the pod label with value and check it exists in our systems structure
@@ -33,7 +32,7 @@ func getContainerMetric(result model.Value, namespace, pod, container model.Labe
 		if test == false {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)].pointers["Pod__"+string(podValue)]; ok == false {
+		if _, ok := c.systems[string(namespaceValue)].pointers["Pod__"+string(podValue)]; ok == false {
 			continue
 		}
 		//Validate that the data contains the container label with value and check it exists in our systems structure
@@ -41,7 +40,7 @@ func getContainerMetric(result model.Value, namespace, pod, container model.Labe
 		if test == false {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].containers[string(containerValue)]; ok == false {
+		if _, ok := c.systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].Containers[string(containerValue)]; ok == false {
 			continue
 		}
 		//validates that the value of the entity is set and if not will default to 0
@@ -55,19 +54,19 @@ func getContainerMetric(result model.Value, namespace, pod, container model.Labe
 		//Check which metric this is for and update the corresponding variable for this container in the system data structure
 		switch metric {
 		case "memory":
-			systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].containers[string(containerValue)].memory = value
+			c.systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].Containers[string(containerValue)].Memory = value
 		case "cpuLimit":
-			systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].containers[string(containerValue)].cpuLimit = value
+			c.systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].Containers[string(containerValue)].CPULimit = value
 		case "cpuRequest":
-			systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].containers[string(containerValue)].cpuRequest = value
+			c.systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].Containers[string(containerValue)].CPURequest = value
 		case "memLimit":
-			systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].containers[string(containerValue)].memLimit = value
+			c.systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].Containers[string(containerValue)].MemLimit = value
 		case "memRequest":
-			systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].containers[string(containerValue)].memRequest = value
+			c.systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].Containers[string(containerValue)].MemRequest = value
 		case "restarts":
-			systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].containers[string(containerValue)].restarts = value
+			c.systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].Containers[string(containerValue)].Restarts = value
 		case "powerState":
-			systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].containers[string(containerValue)].powerState = value
+			c.systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].Containers[string(containerValue)].PowerState = value
 		}
 		status = true
 	}
@@ -75,7 +74,7 @@ func getContainerMetric(result model.Value, namespace, pod, container model.Labe
 }
 
 //getContainerMetricString is used to parse the label based results from Prometheus related to Container Entities and store them in the systems data structure.
-func getContainerMetricString(result model.Value, namespace model.LabelName, pod, container model.LabelName) {
+func (c *Collector) getContainerMetricString(result model.Value, namespace model.LabelName, pod, container model.LabelName) {
 	//Validate there is data in the results.
 	if result == nil {
 		return
@@ -86,7 +85,7 @@ func getContainerMetricString(result model.Value, namespace model.LabelName, pod
 		if test == false {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)]; ok == false {
+		if _, ok := c.systems[string(namespaceValue)]; ok == false {
 			continue
 		}
 		//Validate that the data contains the pod label with value and check it exists in our temp structure if not it will be added
@@ -94,7 +93,7 @@ func getContainerMetricString(result model.Value, namespace model.LabelName, pod
 		if test == false {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)].pointers["Pod__"+string(podValue)]; ok == false {
+		if _, ok := c.systems[string(namespaceValue)].pointers["Pod__"+string(podValue)]; ok == false {
 			continue
 		}
 		//Validate that the data contains the container label with value and check it exists in our temp structure if not it will be added
@@ -102,18 +101,18 @@ func getContainerMetricString(result model.Value, namespace model.LabelName, pod
 		if test == false {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].containers[string(containerValue)]; ok == false {
+		if _, ok := c.systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].Containers[string(containerValue)]; ok == false {
 			continue
 		}
 		//loop through all the labels for an entity and store them in a map. For controller based entities where there will be multiple copies of containers they will have there values concatinated together.
 		for key, value := range result.(model.Matrix)[i].Metric {
-			common.AddToLabelMap(string(key), string(value), systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].containers[string(containerValue)].labelMap)
+			common.AddToLabelMap(string(key), string(value), c.systems[string(namespaceValue)].pointers["Pod__"+string(podValue)].Containers[string(containerValue)].Labels)
 		}
 	}
 }
 
 //getmidMetric is used to parse the results from Prometheus related to mid Entities and store them in the systems data structure.
-func getMidMetric(result model.Value, namespace model.LabelName, mid model.LabelName, metric string, prefix string) {
+func (c *Collector) getMidMetric(result model.Value, namespace model.LabelName, mid model.LabelName, metric string, prefix string) {
 	//Validate there is data in the results.
 	if result == nil {
 		return
@@ -125,7 +124,7 @@ func getMidMetric(result model.Value, namespace model.LabelName, mid model.Label
 		if !ok {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)]; !ok {
+		if _, ok := c.systems[string(namespaceValue)]; !ok {
 			continue
 		}
 		//Validate that the data contains the mid label with value and check it exists in our systems structure
@@ -133,10 +132,10 @@ func getMidMetric(result model.Value, namespace model.LabelName, mid model.Label
 		if !ok {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)]; !ok {
+		if _, ok := c.systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)]; !ok {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)].midLevels[prefix+"__"+string(midValue)]; !ok {
+		if _, ok := c.systems[string(namespaceValue)].Controllers[prefix+"__"+string(midValue)]; !ok {
 			continue
 		}
 		//validates that the value of the entity is set and if not will default to 0
@@ -149,17 +148,17 @@ func getMidMetric(result model.Value, namespace model.LabelName, mid model.Label
 		//Check which metric this is for and update the corresponding variable for this mid in the system data structure
 		switch metric {
 		case "currentSize":
-			systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)].currentSize = int(value)
+			c.systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)].CurrentSize = int(value)
 		case "creationTime":
-			systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)].creationTime = value
+			c.systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)].CreationTime = value
 		default:
-			common.AddToLabelMap(metric, strconv.FormatInt(value, 10), systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)].labelMap)
+			common.AddToLabelMap(metric, strconv.FormatInt(value, 10), c.systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)].Labels)
 		}
 	}
 }
 
 //getmidMetricString is used to parse the label based results from Prometheus related to mid Entities and store them in the systems data structure.
-func getMidMetricString(result model.Value, namespace model.LabelName, mid model.LabelName, prefix string) {
+func (c *Collector) getMidMetricString(result model.Value, namespace model.LabelName, mid model.LabelName, prefix string) {
 	//temp structure used to store data while working with it. As we are combining the labels into a formatted string for loading.
 	//Validate there is data in the results.
 	if result == nil {
@@ -171,7 +170,7 @@ func getMidMetricString(result model.Value, namespace model.LabelName, mid model
 		if !ok {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)]; !ok {
+		if _, ok := c.systems[string(namespaceValue)]; !ok {
 			continue
 		}
 		//Validate that the data contains the mid label with value and check it exists in our temp structure if not it will be added
@@ -179,19 +178,62 @@ func getMidMetricString(result model.Value, namespace model.LabelName, mid model
 		if !ok {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)]; !ok {
+		if _, ok := c.systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)]; !ok {
 			continue
 		}
 		//loop through all the labels for an entity and store them in a map. For controller based entities where there will be multiple copies of containers they will have there values concatinated together.
 		for key, value := range result.(model.Matrix)[i].Metric {
-			common.AddToLabelMap(string(key), string(value), systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)].labelMap)
+			common.AddToLabelMap(string(key), string(value), c.systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)].Labels)
+		}
+	}
+}
+
+//getExclusions records the namespaces, or the controllers at the top of the hierarchy of the pods and controllers, whose annotations match the exclude annotation. The annotations are checked as returned rather than through the labels, so an opt out isn't missed when the label filters drop the annotation.
+func (c *Collector) getExclusions(result model.Value, namespace model.LabelName, mid model.LabelName, prefix string) {
+	if result == nil || c.args.ExcludeAnnotation.Empty() {
+		return
+	}
+	for i := 0; i < result.(model.Matrix).Len(); i++ {
+		metric := result.(model.Matrix)[i].Metric
+		namespaceValue, ok := metric[namespace]
+		if !ok {
+			continue
+		}
+		ns, ok := c.systems[string(namespaceValue)]
+		if !ok {
+			continue
+		}
+		annotations := map[string]string{}
+		for key, value := range metric {
+			annotations[string(key)] = string(value)
+		}
+		if !c.args.ExcludeAnnotation.Matches(annotations) {
+			continue
+		}
+		if mid == "" {
+			c.optedOutNamespaces[string(namespaceValue)] = true
+			continue
+		}
+		midValue, ok := metric[mid]
+		if !ok {
+			continue
+		}
+		controller, ok := ns.pointers[prefix+"__"+string(midValue)]
+		if !ok {
+			continue
+		}
+		source := "controller"
+		if prefix == "Pod" {
+			source = "pod"
+		}
+		if _, ok := c.optedOut[controller]; !ok {
+			c.optedOut[controller] = source
 		}
 	}
 }
 
 //getHPAMetricString is used to parse the label based results from Prometheus related to mid Entities and store them in the systems data structure.
-func getHPAMetricString(result model.Value, namespace model.LabelName, hpa model.LabelName, args *common.Parameters) {
-	hpas := map[string]map[string]string{}
+func (c *Collector) getHPAMetricString(result model.Value, namespace model.LabelName, hpa model.LabelName) {
 	//Validate there is data in the results.
 	if result == nil {
 		return
@@ -202,7 +244,7 @@ func getHPAMetricString(result model.Value, namespace model.LabelName, hpa model
 		if !ok {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)]; !ok {
+		if _, ok := c.systems[string(namespaceValue)]; !ok {
 			continue
 		}
 		//Validate that the data contains the mid label with value and check it exists in our temp structure if not it will be added
@@ -210,33 +252,29 @@ func getHPAMetricString(result model.Value, namespace model.LabelName, hpa model
 		if !ok {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)].pointers["Deployment__"+string(hpaValue)]; ok {
+		if _, ok := c.systems[string(namespaceValue)].pointers["Deployment__"+string(hpaValue)]; ok {
 			for key, value := range result.(model.Matrix)[i].Metric {
-				common.AddToLabelMap(string(key), string(value), systems[string(namespaceValue)].pointers["Deployment__"+string(hpaValue)].labelMap)
+				common.AddToLabelMap(string(key), string(value), c.systems[string(namespaceValue)].pointers["Deployment__"+string(hpaValue)].Labels)
 			}
-		} else if _, ok := systems[string(namespaceValue)].pointers["ReplicaSet__"+string(hpaValue)]; ok {
+		} else if _, ok := c.systems[string(namespaceValue)].pointers["ReplicaSet__"+string(hpaValue)]; ok {
 			for key, value := range result.(model.Matrix)[i].Metric {
-				common.AddToLabelMap(string(key), string(value), systems[string(namespaceValue)].pointers["ReplicaSet__"+string(hpaValue)].labelMap)
+				common.AddToLabelMap(string(key), string(value), c.systems[string(namespaceValue)].pointers["ReplicaSet__"+string(hpaValue)].Labels)
 			}
-		} else if _, ok := systems[string(namespaceValue)].pointers["ReplicationController__"+string(hpaValue)]; ok {
+		} else if _, ok := c.systems[string(namespaceValue)].pointers["ReplicationController__"+string(hpaValue)]; ok {
 			for key, value := range result.(model.Matrix)[i].Metric {
-				common.AddToLabelMap(string(key), string(value), systems[string(namespaceValue)].pointers["ReplicationController__"+string(hpaValue)].labelMap)
+				common.AddToLabelMap(string(key), string(value), c.systems[string(namespaceValue)].pointers["ReplicationController__"+string(hpaValue)].Labels)
 			}
 		} else {
-			hpas[string(hpaValue)] = map[string]string{}
+			c.hpas[string(hpaValue)] = &entity.HPA{Name: string(hpaValue), Namespace: string(namespaceValue), Labels: map[string]string{}}
 			for key, value := range result.(model.Matrix)[i].Metric {
-				common.AddToLabelMap(string(key), string(value), hpas[string(hpaValue)])
+				common.AddToLabelMap(string(key), string(value), c.hpas[string(hpaValue)].Labels)
 			}
 		}
 	}
-	if len(hpas) > 0 {
-		writeHPAAttributes(args, hpas)
-		writeHPAConfig(args, hpas)
-	}
 }
 
 //getNamespaceMetric is used to parse the results from Prometheus related to Namespace Entities and store them in the systems data structure.
-func getNamespacelimits(result model.Value, namespace model.LabelName) {
+func (c *Collector) getNamespacelimits(result model.Value, namespace model.LabelName) {
 	//Validate there is data in the results.
 	if result == nil {
 		return
@@ -248,7 +286,7 @@ func getNamespacelimits(result model.Value, namespace model.LabelName) {
 		if !ok {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)]; !ok {
+		if _, ok := c.systems[string(namespaceValue)]; !ok {
 			continue
 		}
 		//validates that the value of the entity is set and if not will default to 0
@@ -263,19 +301,19 @@ func getNamespacelimits(result model.Value, namespace model.LabelName) {
 		resource := result.(model.Matrix)[i].Metric["resource"]
 		switch {
 		case constraint == "defaultRequest" && resource == "cpu":
-			systems[string(namespaceValue)].cpuRequest = value
+			c.systems[string(namespaceValue)].CPURequest = value
 		case constraint == "defaultRequest" && resource == "memory":
-			systems[string(namespaceValue)].memRequest = value
+			c.systems[string(namespaceValue)].MemRequest = value
 		case constraint == "default" && resource == "cpu":
-			systems[string(namespaceValue)].cpuLimit = value
+			c.systems[string(namespaceValue)].CPULimit = value
 		case constraint == "default" && resource == "memory":
-			systems[string(namespaceValue)].memLimit = value
+			c.systems[string(namespaceValue)].MemLimit = value
 		}
 	}
 }
 
 //getNamespaceMetricString is used to parse the label based results from Prometheus related to Namespace Entities and store them in the systems data structure.
-func getNamespaceMetricString(result model.Value, namespace model.LabelName) {
+func (c *Collector) getNamespaceMetricString(result model.Value, namespace model.LabelName) {
 	//Validate there is data in the results.
 	if result == nil {
 		return
@@ -287,192 +325,164 @@ func getNamespaceMetricString(result model.Value, namespace model.LabelName) {
 		if !ok {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)]; !ok {
+		if _, ok := c.systems[string(namespaceValue)]; !ok {
 			continue
 		}
 		//loop through all the labels for an entity and store them in a map.
 		for key, value := range result.(model.Matrix)[i].Metric {
-			common.AddToLabelMap(string(key), string(value), systems[string(namespaceValue)].labelMap)
+			common.AddToLabelMap(string(key), string(value), c.systems[string(namespaceValue)].Labels)
 		}
 	}
 }
 
-func getWorkload(fileName, metricName, query, aggregator string, args *common.Parameters) {
+//getWorkload queries the workload of the containers, by the kind of owner they run under, for the history windows of the file.
+func (c *Collector) getWorkload(fileName, metricName, query, aggregator string) {
 	var historyInterval time.Duration
 	historyInterval = 0
 	var result model.Value
+	var err error
 	var query2 string
 
-	//Open the files that will be used for the workload data types and write out there headers.
-	workloadWrite, err := os.Create("./data/container/" + aggregator + `_` + fileName + ".csv")
-	if err != nil {
-		args.ErrorLogger.Println("entity=" + entityKind + " metric=" + metricName + " query=" + query + " message=" + err.Error())
-		fmt.Println("entity=" + entityKind + " metric=" + metricName + " query=" + query + " message=" + err.Error())
-		return
-	}
-	fmt.Fprintf(workloadWrite, "cluster,namespace,entity_name,entity_type,container,Datetime,%s\n", metricName)
+	workload := common.NewWorkload(c.args, "container", aggregator+`_`+fileName, metricName, "namespace", "entity_name", "entity_type", "container")
+	c.workloads = append(c.workloads, workload)
 
 	//If the History parameter is set to anything but default 1 then will loop through the calls starting with the current day\hour\minute interval and work backwards.
 	//This is done as the farther you go back in time the slpwer prometheus querying becomes and we have seen cases where will not run from timeouts on Prometheus.
 	//As a result if we do hit an issue with timing out on Prometheus side we still can send the current data and data going back to that point vs losing it all.
-	for historyInterval = 0; int(historyInterval) < *args.History; historyInterval++ {
-		range5Min := common.TimeRange(args, historyInterval)
+	windows := workload.Planned()
+	args := c.args.PlanFor("container", "container/"+aggregator+"_"+fileName+".csv")
+	for historyInterval = 0; int(historyInterval) < windows; historyInterval++ {
+		if workload.Done(historyInterval) {
+			continue
+		}
+		window := workload.Window(historyInterval)
+		range5Min := workload.TimeRange(historyInterval)
 
 		//query containers under a pod with no owner
-		query2 = aggregator + `(` + query + ` * on (pod, namespace) group_left max(kube_pod_owner{owner_name="<none>"}) by (namespace, pod, container` + args.LabelSuffix + `)) by (pod,namespace,container` + args.LabelSuffix + `)`
-		result = common.MetricCollect(args, query2, range5Min, "pod_"+metricName, false)
-		writeWorkload(workloadWrite, result, "namespace", "pod", model.LabelName("container"+args.LabelSuffix), args, "Pod")
+		query2 = aggregator + `(` + query + ` * on (pod, namespace) group_left max(kube_pod_owner{owner_name="<none>"}) by (namespace, pod, container` + c.labelSuffix + `)) by (pod,namespace,container` + c.labelSuffix + `)`
+		result, err = common.CollectWindow(args, query2, range5Min, "pod_"+metricName, false)
+		window.Collected = window.Collected && err == nil
+		c.addWorkload(window, result, "namespace", "pod", model.LabelName("container"+c.labelSuffix), "Pod")
 
 		//query containers under a controller with no owner
-		query2 = aggregator + `(` + query + ` * on (pod, namespace) group_left (owner_name,owner_kind) max(kube_pod_owner) by (namespace, pod, owner_name, owner_kind)) by (owner_kind,owner_name,namespace,container` + args.LabelSuffix + `)`
-		result = common.MetricCollect(args, query2, range5Min, "controller_"+metricName, false)
-		writeWorkload(workloadWrite, result, "namespace", "owner_name", model.LabelName("container"+args.LabelSuffix), args, "")
+		query2 = aggregator + `(` + query + ` * on (pod, namespace) group_left (owner_name,owner_kind) max(kube_pod_owner) by (namespace, pod, owner_name, owner_kind)) by (owner_kind,owner_name,namespace,container` + c.labelSuffix + `)`
+		result, err = common.CollectWindow(args, query2, range5Min, "controller_"+metricName, false)
+		window.Collected = window.Collected && err == nil
+		c.addWorkload(window, result, "namespace", "owner_name", model.LabelName("container"+c.labelSuffix), "")
 
 		//query containers under a deployment
-		query2 = aggregator + `(` + query + ` * on (pod, namespace) group_left (replicaset) max(label_replace(kube_pod_owner{owner_kind="ReplicaSet"}, "replicaset", "$1", "owner_name", "(.*)")) by (namespace, pod, replicaset) * on (replicaset, namespace) group_left (owner_name) max(kube_replicaset_owner{owner_kind="Deployment"}) by (namespace, replicaset, owner_name)) by (owner_name,namespace,container` + args.LabelSuffix + `)`
-		result = common.MetricCollect(args, query2, range5Min, "deployment_"+metricName, false)
-		writeWorkload(workloadWrite, result, "namespace", "owner_name", model.LabelName("container"+args.LabelSuffix), args, "Deployment")
+		query2 = aggregator + `(` + query + ` * on (pod, namespace) group_left (replicaset) max(label_replace(kube_pod_owner{owner_kind="ReplicaSet"}, "replicaset", "$1", "owner_name", "(.*)")) by (namespace, pod, replicaset) * on (replicaset, namespace) group_left (owner_name) max(kube_replicaset_owner{owner_kind="Deployment"}) by (namespace, replicaset, owner_name)) by (owner_name,namespace,container` + c.labelSuffix + `)`
+		result, err = common.CollectWindow(args, query2, range5Min, "deployment_"+metricName, false)
+		window.Collected = window.Collected && err == nil
+		c.addWorkload(window, result, "namespace", "owner_name", model.LabelName("container"+c.labelSuffix), "Deployment")
 
 		//query containers under a cron job
-		query2 = aggregator + `(` + query + ` * on (pod, namespace) group_left (job) max(label_replace(kube_pod_owner{owner_kind="Job"}, "job", "$1", "owner_name", "(.*)")) by (namespace, pod, job) * on (job, namespace) group_left (owner_name) max(label_replace(kube_job_owner{owner_kind="CronJob"}, "job", "$1", "job_name", "(.*)")) by (namespace, job, owner_name)) by (owner_name,namespace,container` + args.LabelSuffix + `)`
-		result = common.MetricCollect(args, query2, range5Min, "cronJob_"+metricName, false)
-		writeWorkload(workloadWrite, result, "namespace", "owner_name", model.LabelName("container"+args.LabelSuffix), args, "CronJob")
-
+		query2 = aggregator + `(` + query + ` * on (pod, namespace) group_left (job) max(label_replace(kube_pod_owner{owner_kind="Job"}, "job", "$1", "owner_name", "(.*)")) by (namespace, pod, job) * on (job, namespace) group_left (owner_name) max(label_replace(kube_job_owner{owner_kind="CronJob"}, "job", "$1", "job_name", "(.*)")) by (namespace, job, owner_name)) by (owner_name,namespace,container` + c.labelSuffix + `)`
+		result, err = common.CollectWindow(args, query2, range5Min, "cronJob_"+metricName, false)
+		window.Collected = window.Collected && err == nil
+		c.addWorkload(window, result, "namespace", "owner_name", model.LabelName("container"+c.labelSuffix), "CronJob")
 	}
-	//Close the workload files.
-	workloadWrite.Close()
 }
 
-func getDeploymentWorkload(fileName, metricName, query string, args *common.Parameters) {
+//getDeploymentWorkload queries the workload of the deployments, repeated for each of their containers, for the history windows of the file.
+func (c *Collector) getDeploymentWorkload(fileName, metricName, query string) {
 	var historyInterval time.Duration
 	historyInterval = 0
 	var result model.Value
+	var err error
 
-	//Open the files that will be used for the workload data types and write out there headers.
-	workloadWrite, err := os.Create("./data/container/deployment_" + fileName + ".csv")
-	if err != nil {
-		args.ErrorLogger.Println("metric=" + metricName + " query=" + query + " message=File not found")
-		fmt.Println("metric=" + metricName + " query=" + query + " message=File not found")
-		return
-	}
-	fmt.Fprintf(workloadWrite, "cluster,namespace,entity_name,entity_type,container,Datetime,%s\n", metricName)
+	workload := common.NewWorkload(c.args, "container", "deployment_"+fileName, metricName, "namespace", "entity_name", "entity_type", "container")
+	c.workloads = append(c.workloads, workload)
 
-	tempMap := map[int]map[string]map[string][]model.SamplePair{}
+	//Each history window is kept as soon as it is collected so what was collected is written if a later window times out.
+	windows := workload.Planned()
+	args := c.args.PlanFor("container", "container/deployment_"+fileName+".csv")
+	for historyInterval = 0; int(historyInterval) < windows; historyInterval++ {
+		if workload.Done(historyInterval) {
+			continue
+		}
+		window := workload.Window(historyInterval)
 
-	for historyInterval = 0; int(historyInterval) < *args.History; historyInterval++ {
-		tempMap[int(historyInterval)] = map[string]map[string][]model.SamplePair{}
-		range5Min := common.TimeRange(args, historyInterval)
+		result, err = common.CollectWindow(args, query, workload.TimeRange(historyInterval), metricName, false)
+		window.Collected = err == nil
+		tempMap := groupSamples(result, "deployment")
 
-		result = common.MetricCollect(args, query, range5Min, metricName, false)
-		for i := 0; i < result.(model.Matrix).Len(); i++ {
-			for j := 0; j < len(result.(model.Matrix)[i].Values); j++ {
-				if _, ok := tempMap[int(historyInterval)][string(result.(model.Matrix)[i].Metric["namespace"])]; !ok {
-					tempMap[int(historyInterval)][string(result.(model.Matrix)[i].Metric["namespace"])] = map[string][]model.SamplePair{}
+		for n := range c.systems {
+			for m, midVal := range c.systems[n].Controllers {
+				if midVal.Kind != "Deployment" {
+					continue
 				}
-				if _, ok := tempMap[int(historyInterval)][string(result.(model.Matrix)[i].Metric["namespace"])][string(result.(model.Matrix)[i].Metric["deployment"])]; !ok {
-					tempMap[int(historyInterval)][string(result.(model.Matrix)[i].Metric["namespace"])][string(result.(model.Matrix)[i].Metric["deployment"])] = []model.SamplePair{}
-				}
-				tempMap[int(historyInterval)][string(result.(model.Matrix)[i].Metric["namespace"])][string(result.(model.Matrix)[i].Metric["deployment"])] = append(tempMap[int(historyInterval)][string(result.(model.Matrix)[i].Metric["namespace"])][string(result.(model.Matrix)[i].Metric["deployment"])], result.(model.Matrix)[i].Values[j])
-			}
-		}
-	}
-
-	for n := range systems {
-		for m, midVal := range systems[n].midLevels {
-			if midVal.kind != "Deployment" {
-				continue
-			}
-			for c := range systems[n].midLevels[m].containers {
-				for historyInterval = 0; int(historyInterval) < *args.History; historyInterval++ {
-					for _, val := range tempMap[int(historyInterval)][n][midVal.name] {
-						fmt.Fprintf(workloadWrite, "%s,%s,%s,%s,%s,%s,%f\n", *args.ClusterName, n, midVal.name, midVal.kind, c, time.Unix(0, int64(val.Timestamp)*1000000).Format("2006-01-02 15:04:05.000"), val.Value)
+				for kc := range c.systems[n].Controllers[m].Containers {
+					if samples, ok := tempMap[n][midVal.Name]; ok {
+						common.AddSeries(window, samples, n, midVal.Name, midVal.Kind, kc)
 					}
 				}
 			}
 		}
 	}
-	workloadWrite.Close()
 }
 
-func getHPAWorkload(fileName, metricName, query string, args *common.Parameters) {
+//getHPAWorkload queries the workload of the HPAs for the history windows of the files. The HPAs of a controller that was collected go to the container file and the rest to the HPA file.
+func (c *Collector) getHPAWorkload(fileName, metricName, query string) {
 	var historyInterval time.Duration
 	historyInterval = 0
 	var result model.Value
+	var err error
 
-	//Open the files that will be used for the workload data types and write out there headers.
-	workloadWrite, err := os.Create("./data/container/hpa_" + fileName + ".csv")
-	if err != nil {
-		args.ErrorLogger.Println("metric=" + metricName + " query=" + query + " message=File not found")
-		fmt.Println("metric=" + metricName + " query=" + query + " message=File not found")
-		return
-	}
-	workloadWriteExtra, err := os.Create("./data/hpa/hpa_extra_" + fileName + ".csv")
-	if err != nil {
-		args.ErrorLogger.Println("metric=" + metricName + " query=" + query + " message=File not found")
-		fmt.Println("metric=" + metricName + " query=" + query + " message=File not found")
-		return
-	}
-	fmt.Fprintf(workloadWrite, "cluster,namespace,entity_name,entity_type,container,HPA Name,Datetime,%s\n", metricName)
-	fmt.Fprintf(workloadWriteExtra, "cluster,namespace,entity_name,entity_type,container,HPA Name,Datetime,%s\n", metricName)
+	workload := common.NewWorkload(c.args, "container", "hpa_"+fileName, metricName, "namespace", "entity_name", "entity_type", "container", "HPA Name")
+	workloadExtra := common.NewWorkload(c.args, "hpa", "hpa_extra_"+fileName, metricName, "namespace", "entity_name", "entity_type", "container", "HPA Name")
+	//Both files are written from the same queries so they share the high-water mark of the container file and collect the same windows.
+	workloadExtra.ShareMark(workload)
+	c.workloads = append(c.workloads, workload, workloadExtra)
 
-	tempMap := map[int]map[string]map[string][]model.SamplePair{}
-
-	for historyInterval = 0; int(historyInterval) < *args.History; historyInterval++ {
-		tempMap[int(historyInterval)] = map[string]map[string][]model.SamplePair{}
-		range5Min := common.TimeRange(args, historyInterval)
-
-		result = common.MetricCollect(args, query, range5Min, metricName, false)
-		for i := 0; i < result.(model.Matrix).Len(); i++ {
-			for j := 0; j < len(result.(model.Matrix)[i].Values); j++ {
-				if _, ok := tempMap[int(historyInterval)][string(result.(model.Matrix)[i].Metric["namespace"])]; !ok {
-					tempMap[int(historyInterval)][string(result.(model.Matrix)[i].Metric["namespace"])] = map[string][]model.SamplePair{}
-				}
-				if _, ok := tempMap[int(historyInterval)][string(result.(model.Matrix)[i].Metric["namespace"])][string(result.(model.Matrix)[i].Metric["hpa"])]; !ok {
-					tempMap[int(historyInterval)][string(result.(model.Matrix)[i].Metric["namespace"])][string(result.(model.Matrix)[i].Metric["hpa"])] = []model.SamplePair{}
-				}
-				tempMap[int(historyInterval)][string(result.(model.Matrix)[i].Metric["namespace"])][string(result.(model.Matrix)[i].Metric["hpa"])] = append(tempMap[int(historyInterval)][string(result.(model.Matrix)[i].Metric["namespace"])][string(result.(model.Matrix)[i].Metric["hpa"])], result.(model.Matrix)[i].Values[j])
-			}
+	windows := workload.Planned()
+	args := c.args.PlanFor("container", "container/hpa_"+fileName+".csv,hpa/hpa_extra_"+fileName+".csv")
+	for historyInterval = 0; int(historyInterval) < windows; historyInterval++ {
+		if workload.Done(historyInterval) {
+			continue
 		}
-	}
+		window, windowExtra := workload.Window(historyInterval), workloadExtra.Window(historyInterval)
 
-	for historyInterval = 0; int(historyInterval) < *args.History; historyInterval++ {
-		for n := range systems {
-			for m, midVal := range systems[n].pointers {
-				switch midVal.kind {
-				case "Deployment":
-					for c := range systems[n].pointers[m].containers {
-						for _, val := range tempMap[int(historyInterval)][n][midVal.name] {
-							fmt.Fprintf(workloadWrite, "%s,%s,%s,%s,%s,%s,%s,%f\n", *args.ClusterName, n, midVal.name, midVal.kind, c, midVal.name, time.Unix(0, int64(val.Timestamp)*1000000).Format("2006-01-02 15:04:05.000"), val.Value)
-						}
-					}
-				case "ReplicaSet":
-					for c := range systems[n].pointers[m].containers {
-						for _, val := range tempMap[int(historyInterval)][n][midVal.name] {
-							fmt.Fprintf(workloadWrite, "%s,%s,%s,%s,%s,%s,%s,%f\n", *args.ClusterName, n, midVal.name, midVal.kind, c, midVal.name, time.Unix(0, int64(val.Timestamp)*1000000).Format("2006-01-02 15:04:05.000"), val.Value)
-						}
-					}
-				case "ReplicationController":
-					for c := range systems[n].pointers[m].containers {
-						for _, val := range tempMap[int(historyInterval)][n][midVal.name] {
-							fmt.Fprintf(workloadWrite, "%s,%s,%s,%s,%s,%s,%s,%f\n", *args.ClusterName, n, midVal.name, midVal.kind, c, midVal.name, time.Unix(0, int64(val.Timestamp)*1000000).Format("2006-01-02 15:04:05.000"), val.Value)
+		result, err = common.CollectWindow(args, query, workload.TimeRange(historyInterval), metricName, false)
+		window.Collected, windowExtra.Collected = err == nil, err == nil
+		tempMap := groupSamples(result, "hpa")
+
+		for n := range c.systems {
+			for m, midVal := range c.systems[n].pointers {
+				switch midVal.Kind {
+				case "Deployment", "ReplicaSet", "ReplicationController":
+					for kc := range c.systems[n].pointers[m].Containers {
+						if samples, ok := tempMap[n][midVal.Name]; ok {
+							common.AddSeries(window, samples, n, midVal.Name, midVal.Kind, kc, midVal.Name)
 						}
 					}
 				}
-				delete(tempMap[int(historyInterval)][n], midVal.name)
+				delete(tempMap[n], midVal.Name)
 			}
 		}
-	}
-	workloadWrite.Close()
-	for historyInterval = 0; int(historyInterval) < *args.History; historyInterval++ {
-		for i := range tempMap {
-			for n := range tempMap[i] {
-				for m := range tempMap[i][n] {
-					for _, val := range tempMap[int(historyInterval)][n][m] {
-						fmt.Fprintf(workloadWriteExtra, "%s,%s,,,,%s,%s,%f\n", *args.ClusterName, n, m, time.Unix(0, int64(val.Timestamp)*1000000).Format("2006-01-02 15:04:05.000"), val.Value)
-					}
+		for n := range tempMap {
+			for m := range tempMap[n] {
+				if c.excluded[n+"__"+m] {
+					continue
 				}
+				common.AddSeries(windowExtra, tempMap[n][m], n, "", "", "", m)
 			}
 		}
 	}
-	workloadWriteExtra.Close()
+}
+
+//groupSamples returns the samples of the result by namespace and the value of the label, e.g. the deployment or hpa.
+func groupSamples(result model.Value, label model.LabelName) map[string]map[string][]model.SamplePair {
+	samples := map[string]map[string][]model.SamplePair{}
+	if result == nil {
+		return samples
+	}
+	for _, series := range result.(model.Matrix) {
+		namespace, name := string(series.Metric["namespace"]), string(series.Metric[label])
+		if _, ok := samples[namespace]; !ok {
+			samples[namespace] = map[string][]model.SamplePair{}
+		}
+		samples[namespace][name] = append(samples[namespace][name], series.Values...)
+	}
+	return samples
 }
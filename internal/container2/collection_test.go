@@ -0,0 +1,161 @@
+package container2
+
+import (
+	"testing"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/selector"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/testutil"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/entity"
+	"github.com/prometheus/common/model"
+)
+
+//newTestCollector returns a collector holding the namespace ns1 with the deployment d1, owning the replica set rs1 and pod p1 with the container c1, and the pod p2 with no owner.
+func newTestCollector() *Collector {
+	c := NewCollector(&common.Parameters{})
+	d1 := &entity.Controller{Name: "d1", Kind: "Deployment", Containers: map[string]*entity.Container{"c1": {Name: "c1", Labels: map[string]string{}}}, Labels: map[string]string{}}
+	p2 := &entity.Controller{Name: "p2", Kind: "Pod", Containers: map[string]*entity.Container{"c2": {Name: "c2", Labels: map[string]string{}}}, Labels: map[string]string{}}
+	c.systems["ns1"] = &namespace{
+		Namespace: &entity.Namespace{Name: "ns1", Controllers: map[string]*entity.Controller{"Deployment__d1": d1, "Pod__p2": p2}, CPURequest: -1, CPULimit: -1, MemRequest: -1, MemLimit: -1, Labels: map[string]string{}},
+		pointers:  map[string]*entity.Controller{"Deployment__d1": d1, "ReplicaSet__rs1": d1, "Pod__p1": d1, "Pod__p2": p2},
+	}
+	return c
+}
+
+func TestGetContainerMetric(t *testing.T) {
+	c := newTestCollector()
+	result := model.Matrix{
+		testutil.Series([]string{"namespace", "ns1", "pod", "p1", "container", "c1"}, 100, 250),
+		testutil.Series([]string{"namespace", "ns2", "pod", "p1", "container", "c1"}, 1),
+		testutil.Series([]string{"namespace", "ns1", "pod", "p9", "container", "c1"}, 2),
+		testutil.Series([]string{"namespace", "ns1", "pod", "p1"}, 3),
+	}
+	if !c.getContainerMetric(result, "namespace", "pod", "container", "memory") {
+		t.Error("getContainerMetric returned false for a matching series")
+	}
+	if got := c.systems["ns1"].Controllers["Deployment__d1"].Containers["c1"].Memory; got != 250 {
+		t.Errorf("Memory = %d, want the last value 250", got)
+	}
+	if c.getContainerMetric(model.Matrix{result[1], result[2], result[3]}, "namespace", "pod", "container", "cpuLimit") {
+		t.Error("getContainerMetric returned true without a matching series")
+	}
+	if c.getContainerMetric(nil, "namespace", "pod", "container", "cpuLimit") {
+		t.Error("getContainerMetric returned true for no result")
+	}
+
+	c.getContainerMetric(model.Matrix{testutil.Series([]string{"namespace", "ns1", "pod", "p1", "container", "c1"})}, "namespace", "pod", "container", "restarts")
+	if got := c.systems["ns1"].Controllers["Deployment__d1"].Containers["c1"].Restarts; got != 0 {
+		t.Errorf("Restarts = %d, want 0 for a series without samples", got)
+	}
+}
+
+func TestGetContainerMetricString(t *testing.T) {
+	c := newTestCollector()
+	c.getContainerMetricString(model.Matrix{
+		testutil.Series([]string{"namespace", "ns1", "pod", "p1", "container", "c1", "image", "web:1"}),
+		testutil.Series([]string{"namespace", "ns1", "pod", "p1", "container", "c1", "image", "web:2"}),
+		testutil.Series([]string{"namespace", "ns1", "pod", "p1", "container", "c9", "image", "other"}),
+	}, "namespace", "pod", "container")
+	labels := c.systems["ns1"].Controllers["Deployment__d1"].Containers["c1"].Labels
+	if labels["image"] != "web:1;web:2" {
+		t.Errorf("image = %q, want the values of both series", labels["image"])
+	}
+	if labels["container"] != "c1" {
+		t.Errorf("container = %q, want c1 once", labels["container"])
+	}
+}
+
+func TestGetMidMetric(t *testing.T) {
+	c := newTestCollector()
+	c.getMidMetric(model.Matrix{testutil.Series([]string{"namespace", "ns1", "deployment", "d1"}, 2, 3)}, "namespace", "deployment", "currentSize", "Deployment")
+	c.getMidMetric(model.Matrix{testutil.Series([]string{"namespace", "ns1", "deployment", "d1"}, 1600000000)}, "namespace", "deployment", "creationTime", "Deployment")
+	c.getMidMetric(model.Matrix{testutil.Series([]string{"namespace", "ns1", "deployment", "d1"}, 5)}, "namespace", "deployment", "maxSurge", "Deployment")
+	//A replica set is only a pointer to its deployment, not a controller of its own, so its metrics aren't kept.
+	c.getMidMetric(model.Matrix{testutil.Series([]string{"namespace", "ns1", "replicaset", "rs1"}, 9)}, "namespace", "replicaset", "currentSize", "ReplicaSet")
+
+	d1 := c.systems["ns1"].Controllers["Deployment__d1"]
+	if d1.CurrentSize != 3 || d1.CreationTime != 1600000000 || d1.Labels["maxSurge"] != "5" {
+		t.Errorf("deployment = size %d, creation time %d, maxSurge %q, want 3, 1600000000 and 5", d1.CurrentSize, d1.CreationTime, d1.Labels["maxSurge"])
+	}
+}
+
+func TestGetMidMetricString(t *testing.T) {
+	c := newTestCollector()
+	c.getMidMetricString(model.Matrix{
+		testutil.Series([]string{"namespace", "ns1", "replicaset", "rs1", "label_app", "web"}),
+		testutil.Series([]string{"namespace", "ns1", "replicaset", "rs9", "label_app", "other"}),
+	}, "namespace", "replicaset", "ReplicaSet")
+	if got := c.systems["ns1"].Controllers["Deployment__d1"].Labels["label_app"]; got != "web" {
+		t.Errorf("label_app = %q, want the label of the replica set on its deployment", got)
+	}
+}
+
+func TestGetExclusions(t *testing.T) {
+	c := newTestCollector()
+	c.getExclusions(model.Matrix{testutil.Series([]string{"namespace", "ns1", "pod", "p1", "annotation_densify_com_exclude", "true"})}, "namespace", "pod", "Pod")
+	if len(c.optedOut) != 0 {
+		t.Fatal("getExclusions opted out workloads without an exclude annotation")
+	}
+
+	c.args.ExcludeAnnotation, _ = selector.ParseAnnotation("densify.com/exclude=true")
+	c.getExclusions(model.Matrix{
+		testutil.Series([]string{"namespace", "ns1", "pod", "p1", "annotation_densify_com_exclude", "true"}),
+		testutil.Series([]string{"namespace", "ns1", "pod", "p2", "annotation_densify_com_exclude", "false"}),
+		testutil.Series([]string{"namespace", "ns1", "pod", "p9", "annotation_densify_com_exclude", "true"}),
+	}, "namespace", "pod", "Pod")
+	c.getExclusions(model.Matrix{testutil.Series([]string{"namespace", "ns1", "deployment", "d1", "annotation_densify_com_exclude", "true"})}, "namespace", "deployment", "Deployment")
+	d1, p2 := c.systems["ns1"].Controllers["Deployment__d1"], c.systems["ns1"].Controllers["Pod__p2"]
+	if c.optedOut[d1] != "pod" {
+		t.Errorf("d1 opted out by %q, want pod as its pod was found first", c.optedOut[d1])
+	}
+	if _, ok := c.optedOut[p2]; ok {
+		t.Error("p2 opted out with another value of the annotation")
+	}
+
+	c.getExclusions(model.Matrix{testutil.Series([]string{"namespace", "ns1", "annotation_densify_com_exclude", "true"}), testutil.Series([]string{"namespace", "ns2", "annotation_densify_com_exclude", "true"})}, "namespace", "", "")
+	if !c.optedOutNamespaces["ns1"] || c.optedOutNamespaces["ns2"] {
+		t.Errorf("namespaces opted out = %v, want only ns1 which was collected", c.optedOutNamespaces)
+	}
+}
+
+func TestGetHPAMetricString(t *testing.T) {
+	c := newTestCollector()
+	c.getHPAMetricString(model.Matrix{
+		testutil.Series([]string{"namespace", "ns1", "hpa", "d1", "label_team", "a"}),
+		testutil.Series([]string{"namespace", "ns1", "hpa", "h2", "label_team", "b"}),
+		testutil.Series([]string{"namespace", "ns9", "hpa", "h3"}),
+	}, "namespace", "hpa")
+	if got := c.systems["ns1"].Controllers["Deployment__d1"].Labels["label_team"]; got != "a" {
+		t.Errorf("label_team of d1 = %q, want the label of the HPA scaling it", got)
+	}
+	if len(c.hpas) != 1 || c.hpas["h2"] == nil || c.hpas["h2"].Namespace != "ns1" || c.hpas["h2"].Labels["label_team"] != "b" {
+		t.Errorf("HPAs = %v, want only h2 which doesn't scale a controller", c.hpas)
+	}
+}
+
+func TestGetNamespacelimits(t *testing.T) {
+	c := newTestCollector()
+	c.getNamespacelimits(model.Matrix{
+		testutil.Series([]string{"namespace", "ns1", "constraint", "defaultRequest", "resource", "cpu"}, 100),
+		testutil.Series([]string{"namespace", "ns1", "constraint", "default", "resource", "memory"}, 512),
+		testutil.Series([]string{"namespace", "ns1", "constraint", "max", "resource", "cpu"}, 4000),
+	}, "namespace")
+	ns := c.systems["ns1"]
+	if ns.CPURequest != 100 || ns.MemLimit != 512 || ns.CPULimit != -1 || ns.MemRequest != -1 {
+		t.Errorf("namespace limits = cpu request %d, cpu limit %d, mem request %d, mem limit %d, want 100, -1, -1 and 512", ns.CPURequest, ns.CPULimit, ns.MemRequest, ns.MemLimit)
+	}
+}
+
+func TestGroupSamples(t *testing.T) {
+	grouped := groupSamples(model.Matrix{
+		testutil.Series([]string{"namespace", "ns1", "hpa", "h1"}, 1, 2),
+		testutil.Series([]string{"namespace", "ns1", "hpa", "h1"}, 3),
+		testutil.Series([]string{"namespace", "ns2", "hpa", "h1"}, 4),
+	}, "hpa")
+	if len(grouped["ns1"]["h1"]) != 3 || len(grouped["ns2"]["h1"]) != 1 {
+		t.Errorf("grouped samples = %v, want 3 for ns1/h1 and 1 for ns2/h1", grouped)
+	}
+	if len(groupSamples(nil, "hpa")) != 0 {
+		t.Error("groupSamples of no result isn't empty")
+	}
+}
@@ -2,42 +2,55 @@
 package container2
 
 import (
-	"fmt"
-	"os"
 	"time"
 
 	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/logging"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/summary"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/entity"
 	"github.com/prometheus/common/model"
 )
 
-var systems = map[string]*namespace{}
 var entityKind = "Container"
 
+//namespace wraps the namespace entity with the lookups used while parsing the results.
+//pointers maps every owner (e.g. ReplicaSet__web-12345 or Pod__web-12345-abcde) to the controller at the top of its hierarchy.
 type namespace struct {
-	pointers                                   map[string]*midLevel
-	midLevels                                  map[string]*midLevel
-	cpuLimit, cpuRequest, memLimit, memRequest int
-	labelMap                                   map[string]string
+	*entity.Namespace
+	pointers map[string]*entity.Controller
 }
 
-//midLevel is used to hold information related to the highest owner of any containers
-type midLevel struct {
-	name, kind            string
-	containers            map[string]*container
-	currentSize, restarts int
-	creationTime          int64
-	labelMap              map[string]string
+//Collector collects the container data for a single run. Create a new one with NewCollector for every run as it holds the entities found.
+type Collector struct {
+	args *common.Parameters
+	//labelSuffix is set to _name when cAdvisor uses the older pod_name and container_name labels.
+	labelSuffix string
+	systems     map[string]*namespace
+	hpas        map[string]*entity.HPA
+	//excluded holds the controllers and HPAs removed by the exclude annotation or the label selector, keyed by namespace and name, so their workloads aren't written as HPAs of unknown controllers.
+	excluded map[string]bool
+	//optedOut holds the controllers whose pods or own annotations match the exclude annotation, with what had the annotation, and optedOutNamespaces the namespaces that do.
+	optedOut           map[*entity.Controller]string
+	optedOutNamespaces map[string]bool
+	workloads          []*common.Workload
 }
 
-//container is used to hold information related to containers
-type container struct {
-	memory, cpuLimit, cpuRequest, memLimit, memRequest, restarts, powerState int
-	name                                                                     string
-	labelMap                                                                 map[string]string
+//Result holds the entities found by the Collector that are written to the config and attributes files, and the workloads written to the workload files.
+type Result struct {
+	Namespaces map[string]*entity.Namespace
+	//HPAs are the horizontal pod autoscalers that don't scale any of the controllers found.
+	HPAs      map[string]*entity.HPA
+	Workloads []*common.Workload
 }
 
-//Metrics function to collect data related to containers.
-func Metrics(args *common.Parameters) {
+//NewCollector returns a Collector that queries Prometheus using the parameters provided. Only the namespaces of the namespace filters are queried.
+func NewCollector(args *common.Parameters) *Collector {
+	return &Collector{args: args.WithMatcher(args.NamespaceMatcher), systems: map[string]*namespace{}, hpas: map[string]*entity.HPA{}, excluded: map[string]bool{}, optedOut: map[*entity.Controller]string{}, optedOutNamespaces: map[string]bool{}}
+}
+
+//Collect gathers the containers and their owners and their workloads, unless they are skipped, and returns them to be written by Write. It returns nil if the vital metrics couldn't be collected.
+func (c *Collector) Collect() *Result {
+	args := c.args.PlanFor("container", "container/config.csv,container/attributes.csv")
 	//Setup variables used in the code.
 	var historyInterval time.Duration
 	historyInterval = 0
@@ -56,7 +69,7 @@ func Metrics(args *common.Parameters) {
 	query = `sum(kube_pod_owner{owner_name!="<none>"}) by (namespace, pod, owner_name, owner_kind)`
 	result = common.MetricCollect(args, query, range5Min, "pods", true)
 	if result == nil {
-		return
+		return nil
 	}
 
 	rslt = result.(model.Matrix)
@@ -86,7 +99,7 @@ func Metrics(args *common.Parameters) {
 	query = `max(kube_pod_container_info) by (container, pod, namespace)`
 	result = common.MetricCollect(args, query, range5Min, "containers", true)
 	if result == nil {
-		return
+		return nil
 	}
 
 	rslt = result.(model.Matrix)
@@ -101,452 +114,589 @@ func Metrics(args *common.Parameters) {
 		var ownerKind string
 
 		namespaceName := string(rslt[i].Metric["namespace"])
-		if _, ok := systems[namespaceName]; !ok {
-			systems[namespaceName] = &namespace{pointers: map[string]*midLevel{}, midLevels: map[string]*midLevel{}, cpuRequest: -1, cpuLimit: -1, memRequest: -1, memLimit: -1, labelMap: map[string]string{}}
+		if _, ok := c.systems[namespaceName]; !ok {
+			c.systems[namespaceName] = &namespace{Namespace: &entity.Namespace{Name: namespaceName, Controllers: map[string]*entity.Controller{}, CPURequest: -1, CPULimit: -1, MemRequest: -1, MemLimit: -1, Labels: map[string]string{}}, pointers: map[string]*entity.Controller{}}
 		}
 
-		//systems[namespaceName].pods[podName] = &pod{labelMap: map[string]string{}}
+		//c.systems[namespaceName].pods[podName] = &pod{labelMap: map[string]string{}}
 		if controllerName, ok := podOwners[podName+"__"+namespaceName]; ok {
 			if deploymentName, ok := replicaSetOwners[controllerName+"__"+namespaceName]; ok && podOwnersKind[podName+"__"+namespaceName] == "ReplicaSet" {
 				currentOwner = deploymentName
 				ownerKind = "Deployment"
 				//Create deployment as top owner and add container
-				if _, ok := systems[namespaceName].midLevels[ownerKind+"__"+deploymentName]; !ok {
-					systems[namespaceName].midLevels[ownerKind+"__"+deploymentName] = &midLevel{name: deploymentName, kind: "Deployment", containers: map[string]*container{}, labelMap: map[string]string{}, currentSize: -1}
-					systems[namespaceName].midLevels[ownerKind+"__"+deploymentName].containers[containerName] = &container{name: containerName, labelMap: map[string]string{}, cpuLimit: -1, cpuRequest: -1, memLimit: -1, memRequest: -1, memory: -1, restarts: -1, powerState: 1}
-				} else if _, ok := systems[namespaceName].midLevels[ownerKind+"__"+deploymentName].containers[containerName]; !ok {
-					systems[namespaceName].midLevels[ownerKind+"__"+deploymentName].containers[containerName] = &container{name: containerName, labelMap: map[string]string{}, cpuLimit: -1, cpuRequest: -1, memLimit: -1, memRequest: -1, memory: -1, restarts: -1, powerState: 1}
+				if _, ok := c.systems[namespaceName].Controllers[ownerKind+"__"+deploymentName]; !ok {
+					c.systems[namespaceName].Controllers[ownerKind+"__"+deploymentName] = &entity.Controller{Name: deploymentName, Kind: "Deployment", Containers: map[string]*entity.Container{}, Labels: map[string]string{}, CurrentSize: -1}
+					c.systems[namespaceName].Controllers[ownerKind+"__"+deploymentName].Containers[containerName] = &entity.Container{Name: containerName, Labels: map[string]string{}, CPULimit: -1, CPURequest: -1, MemLimit: -1, MemRequest: -1, Memory: -1, Restarts: -1, PowerState: 1}
+				} else if _, ok := c.systems[namespaceName].Controllers[ownerKind+"__"+deploymentName].Containers[containerName]; !ok {
+					c.systems[namespaceName].Controllers[ownerKind+"__"+deploymentName].Containers[containerName] = &entity.Container{Name: containerName, Labels: map[string]string{}, CPULimit: -1, CPURequest: -1, MemLimit: -1, MemRequest: -1, Memory: -1, Restarts: -1, PowerState: 1}
 				}
-				if _, ok := systems[namespaceName].pointers[ownerKind+"__"+deploymentName]; !ok {
-					systems[namespaceName].pointers[ownerKind+"__"+deploymentName] = systems[namespaceName].midLevels[ownerKind+"__"+currentOwner]
+				if _, ok := c.systems[namespaceName].pointers[ownerKind+"__"+deploymentName]; !ok {
+					c.systems[namespaceName].pointers[ownerKind+"__"+deploymentName] = c.systems[namespaceName].Controllers[ownerKind+"__"+currentOwner]
 				}
 			} else if cronJobName, ok := jobOwners[controllerName+"__"+namespaceName]; ok && podOwnersKind[podName+"__"+namespaceName] == "Job" {
 				currentOwner = cronJobName
 				ownerKind = "CronJob"
 				//Create deployment as top owner and add container
-				if _, ok := systems[namespaceName].midLevels[ownerKind+"__"+cronJobName]; !ok {
-					systems[namespaceName].midLevels[ownerKind+"__"+cronJobName] = &midLevel{name: cronJobName, kind: "CronJob", containers: map[string]*container{}, labelMap: map[string]string{}, currentSize: -1}
-					systems[namespaceName].midLevels[ownerKind+"__"+cronJobName].containers[containerName] = &container{name: containerName, labelMap: map[string]string{}, cpuLimit: -1, cpuRequest: -1, memLimit: -1, memRequest: -1, memory: -1, restarts: -1, powerState: 1}
-				} else if _, ok := systems[namespaceName].midLevels[ownerKind+"__"+cronJobName].containers[containerName]; !ok {
-					systems[namespaceName].midLevels[ownerKind+"__"+cronJobName].containers[containerName] = &container{name: containerName, labelMap: map[string]string{}, cpuLimit: -1, cpuRequest: -1, memLimit: -1, memRequest: -1, memory: -1, restarts: -1, powerState: 1}
+				if _, ok := c.systems[namespaceName].Controllers[ownerKind+"__"+cronJobName]; !ok {
+					c.systems[namespaceName].Controllers[ownerKind+"__"+cronJobName] = &entity.Controller{Name: cronJobName, Kind: "CronJob", Containers: map[string]*entity.Container{}, Labels: map[string]string{}, CurrentSize: -1}
+					c.systems[namespaceName].Controllers[ownerKind+"__"+cronJobName].Containers[containerName] = &entity.Container{Name: containerName, Labels: map[string]string{}, CPULimit: -1, CPURequest: -1, MemLimit: -1, MemRequest: -1, Memory: -1, Restarts: -1, PowerState: 1}
+				} else if _, ok := c.systems[namespaceName].Controllers[ownerKind+"__"+cronJobName].Containers[containerName]; !ok {
+					c.systems[namespaceName].Controllers[ownerKind+"__"+cronJobName].Containers[containerName] = &entity.Container{Name: containerName, Labels: map[string]string{}, CPULimit: -1, CPURequest: -1, MemLimit: -1, MemRequest: -1, Memory: -1, Restarts: -1, PowerState: 1}
 				}
-				if _, ok := systems[namespaceName].pointers[ownerKind+"__"+cronJobName]; !ok {
-					systems[namespaceName].pointers[ownerKind+"__"+cronJobName] = systems[namespaceName].midLevels[ownerKind+"__"+currentOwner]
+				if _, ok := c.systems[namespaceName].pointers[ownerKind+"__"+cronJobName]; !ok {
+					c.systems[namespaceName].pointers[ownerKind+"__"+cronJobName] = c.systems[namespaceName].Controllers[ownerKind+"__"+currentOwner]
 				}
 			} else {
 				currentOwner = controllerName
 				ownerKind = podOwnersKind[podName+"__"+namespaceName]
 				//Create controller as top owner and add container
-				if _, ok := systems[namespaceName].midLevels[podOwnersKind[podName+"__"+namespaceName]+"__"+controllerName]; !ok {
-					systems[namespaceName].midLevels[podOwnersKind[podName+"__"+namespaceName]+"__"+controllerName] = &midLevel{name: controllerName, kind: podOwnersKind[podName+"__"+namespaceName], containers: map[string]*container{}, labelMap: map[string]string{}, currentSize: -1}
-					systems[namespaceName].midLevels[podOwnersKind[podName+"__"+namespaceName]+"__"+controllerName].containers[containerName] = &container{name: containerName, labelMap: map[string]string{}, cpuLimit: -1, cpuRequest: -1, memLimit: -1, memRequest: -1, memory: -1, restarts: -1, powerState: 1}
-				} else if _, ok := systems[namespaceName].midLevels[podOwnersKind[podName+"__"+namespaceName]+"__"+controllerName].containers[containerName]; !ok {
-					systems[namespaceName].midLevels[podOwnersKind[podName+"__"+namespaceName]+"__"+controllerName].containers[containerName] = &container{name: containerName, labelMap: map[string]string{}, cpuLimit: -1, cpuRequest: -1, memLimit: -1, memRequest: -1, memory: -1, restarts: -1, powerState: 1}
+				if _, ok := c.systems[namespaceName].Controllers[podOwnersKind[podName+"__"+namespaceName]+"__"+controllerName]; !ok {
+					c.systems[namespaceName].Controllers[podOwnersKind[podName+"__"+namespaceName]+"__"+controllerName] = &entity.Controller{Name: controllerName, Kind: podOwnersKind[podName+"__"+namespaceName], Containers: map[string]*entity.Container{}, Labels: map[string]string{}, CurrentSize: -1}
+					c.systems[namespaceName].Controllers[podOwnersKind[podName+"__"+namespaceName]+"__"+controllerName].Containers[containerName] = &entity.Container{Name: containerName, Labels: map[string]string{}, CPULimit: -1, CPURequest: -1, MemLimit: -1, MemRequest: -1, Memory: -1, Restarts: -1, PowerState: 1}
+				} else if _, ok := c.systems[namespaceName].Controllers[podOwnersKind[podName+"__"+namespaceName]+"__"+controllerName].Containers[containerName]; !ok {
+					c.systems[namespaceName].Controllers[podOwnersKind[podName+"__"+namespaceName]+"__"+controllerName].Containers[containerName] = &entity.Container{Name: containerName, Labels: map[string]string{}, CPULimit: -1, CPURequest: -1, MemLimit: -1, MemRequest: -1, Memory: -1, Restarts: -1, PowerState: 1}
 				}
 			}
-			if _, ok := systems[namespaceName].pointers[podOwnersKind[podName+"__"+namespaceName]+"__"+controllerName]; !ok {
-				systems[namespaceName].pointers[podOwnersKind[podName+"__"+namespaceName]+"__"+controllerName] = systems[namespaceName].midLevels[ownerKind+"__"+currentOwner]
+			if _, ok := c.systems[namespaceName].pointers[podOwnersKind[podName+"__"+namespaceName]+"__"+controllerName]; !ok {
+				c.systems[namespaceName].pointers[podOwnersKind[podName+"__"+namespaceName]+"__"+controllerName] = c.systems[namespaceName].Controllers[ownerKind+"__"+currentOwner]
 			}
 		} else {
 			currentOwner = podName
 			ownerKind = "Pod"
 			//Create pod as top owner and add container
-			if _, ok := systems[namespaceName].midLevels[ownerKind+"__"+podName]; !ok {
-				systems[namespaceName].midLevels[ownerKind+"__"+podName] = &midLevel{name: podName, kind: "Pod", containers: map[string]*container{}, labelMap: map[string]string{}, currentSize: -1}
+			if _, ok := c.systems[namespaceName].Controllers[ownerKind+"__"+podName]; !ok {
+				c.systems[namespaceName].Controllers[ownerKind+"__"+podName] = &entity.Controller{Name: podName, Kind: "Pod", Containers: map[string]*entity.Container{}, Labels: map[string]string{}, CurrentSize: -1}
 			}
-			systems[namespaceName].midLevels[ownerKind+"__"+podName].containers[containerName] = &container{name: containerName, labelMap: map[string]string{}, cpuLimit: -1, cpuRequest: -1, memLimit: -1, memRequest: -1, memory: -1, restarts: -1, powerState: 1}
+			c.systems[namespaceName].Controllers[ownerKind+"__"+podName].Containers[containerName] = &entity.Container{Name: containerName, Labels: map[string]string{}, CPULimit: -1, CPURequest: -1, MemLimit: -1, MemRequest: -1, Memory: -1, Restarts: -1, PowerState: 1}
 		}
-		if _, ok := systems[namespaceName].pointers["Pod__"+podName]; !ok {
-			systems[namespaceName].pointers["Pod__"+podName] = systems[namespaceName].midLevels[ownerKind+"__"+currentOwner]
+		if _, ok := c.systems[namespaceName].pointers["Pod__"+podName]; !ok {
+			c.systems[namespaceName].pointers["Pod__"+podName] = c.systems[namespaceName].Controllers[ownerKind+"__"+currentOwner]
 		}
 	}
 
 	//for printing containers
 	tempString := ""
-	if args.Debug {
-		for i := range systems {
+	if args.Logger.Enabled(logging.DebugLevel) {
+		for i := range c.systems {
 			tempString += "namespace: " + i + "\n"
-			for j, v := range systems[i].midLevels {
-				tempString += "- entity name: " + v.name + "\n  entity kind: " + v.kind + "\n  namespace: " + i + "\n  containers: \n"
-				for k := range systems[i].midLevels[j].containers {
+			for j, v := range c.systems[i].Controllers {
+				tempString += "- entity name: " + v.Name + "\n  entity kind: " + v.Kind + "\n  namespace: " + i + "\n  containers: \n"
+				for k := range c.systems[i].Controllers[j].Containers {
 					tempString += "  - " + k + "\n"
 				}
 			}
 		}
-		args.DebugLogger.Println("message=Dump of Systesms structure\n" + tempString)
-		fmt.Println("message=Dump of Systesms structure\n" + tempString)
+		args.Logger.Debug("Dump of Systesms structure\n" + tempString)
 	}
 
 	//Container metrics
 	query = `container_spec_memory_limit_bytes{name!~"k8s_POD_.*"}/1024/1024`
-	result = common.MetricCollect(args, query, range5Min, "memory", false)
+	result, _ = common.DetectCollect(args, query, range5Min, "memory")
 	if result != nil {
-		if args.LabelSuffix == "" && getContainerMetric(result, "namespace", "pod", "container", "memory") {
+		if c.labelSuffix == "" && c.getContainerMetric(result, "namespace", "pod", "container", "memory") {
 			//Don't do anything
-		} else if getContainerMetric(result, "namespace", "pod_name", "container_name", "memory") {
-			args.LabelSuffix = "_name"
+		} else if c.getContainerMetric(result, "namespace", "pod_name", "container_name", "memory") {
+			c.labelSuffix = "_name"
 		}
 	}
 
 	query = `sum(kube_pod_container_resource_limits_cpu_cores) by (pod,namespace,container)*1000`
 	result = common.MetricCollect(args, query, range5Min, "cpuLimit", false)
 	if result != nil {
-		getContainerMetric(result, "namespace", "pod", "container", "cpuLimit")
+		c.getContainerMetric(result, "namespace", "pod", "container", "cpuLimit")
 	}
 
 	query = `sum(kube_pod_container_resource_requests_cpu_cores) by (pod,namespace,container)*1000`
 	result = common.MetricCollect(args, query, range5Min, "cpuRequest", false)
 	if result != nil {
-		getContainerMetric(result, "namespace", "pod", "container", "cpuRequest")
+		c.getContainerMetric(result, "namespace", "pod", "container", "cpuRequest")
 	}
 
 	query = `sum(kube_pod_container_resource_limits_memory_bytes) by (pod,namespace,container)/1024/1024`
 	result = common.MetricCollect(args, query, range5Min, "memLimit", false)
 	if result != nil {
-		getContainerMetric(result, "namespace", "pod", "container", "memLimit")
+		c.getContainerMetric(result, "namespace", "pod", "container", "memLimit")
 	}
 
 	query = `sum(kube_pod_container_resource_requests_memory_bytes) by (pod,namespace,container)/1024/1024`
 	result = common.MetricCollect(args, query, range5Min, "memRequest", false)
 	if result != nil {
-		getContainerMetric(result, "namespace", "pod", "container", "memRequest")
+		c.getContainerMetric(result, "namespace", "pod", "container", "memRequest")
 	}
 
 	query = `container_spec_cpu_shares{name!~"k8s_POD_.*"}`
 	result = common.MetricCollect(args, query, range5Min, "conLabel", false)
 	if result != nil {
-		getContainerMetricString(result, "namespace", model.LabelName("pod"+args.LabelSuffix), model.LabelName("container"+args.LabelSuffix))
+		c.getContainerMetricString(result, "namespace", model.LabelName("pod"+c.labelSuffix), model.LabelName("container"+c.labelSuffix))
 	}
 
 	query = `kube_pod_container_info`
 	result = common.MetricCollect(args, query, range5Min, "conInfo", false)
 	if result != nil {
-		getContainerMetricString(result, "namespace", "pod", "container")
+		c.getContainerMetricString(result, "namespace", "pod", "container")
 	}
 
 	//Pod metrics
 	query = `kube_pod_info`
 	result = common.MetricCollect(args, query, range5Min, "podInfo", false)
 	if result != nil {
-		getMidMetricString(result, "namespace", "pod", "Pod")
+		c.getMidMetricString(result, "namespace", "pod", "Pod")
 	}
 
 	query = `kube_pod_labels`
 	result = common.MetricCollect(args, query, range5Min, "podLabels", false)
 	if result != nil {
-		getMidMetricString(result, "namespace", "pod", "Pod")
+		c.getMidMetricString(result, "namespace", "pod", "Pod")
 	}
 
 	query = `sum(kube_pod_container_status_restarts_total) by (pod,namespace,container)`
 	result = common.MetricCollect(args, query, range5Min, "restarts", false)
 	if result != nil {
-		getContainerMetric(result, "namespace", "pod", "container", "restarts")
+		c.getContainerMetric(result, "namespace", "pod", "container", "restarts")
 	}
 
 	query = `sum(kube_pod_container_status_terminated) by (pod,namespace,container)`
 	result = common.MetricCollect(args, query, range5Min, "powerState", false)
 	if result != nil {
-		getContainerMetric(result, "namespace", "pod", "container", "powerState")
+		c.getContainerMetric(result, "namespace", "pod", "container", "powerState")
 	}
 
 	query = `kube_pod_created`
 	result = common.MetricCollect(args, query, range5Min, "podCreationTime", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "pod", "creationTime", "Pod")
+		c.getMidMetric(result, "namespace", "pod", "creationTime", "Pod")
 	}
 
 	//Namespace metrics
 	query = `kube_namespace_labels`
 	result = common.MetricCollect(args, query, range5Min, "namespaceLabels", false)
 	if result != nil {
-		getNamespaceMetricString(result, "namespace")
+		c.getNamespaceMetricString(result, "namespace")
 	}
 
 	query = `kube_namespace_annotations`
 	result = common.MetricCollect(args, query, range5Min, "namespaceAnnotations", false)
 	if result != nil {
-		getNamespaceMetricString(result, "namespace")
+		c.getNamespaceMetricString(result, "namespace")
+		c.getExclusions(result, "namespace", "", "Namespace")
 	}
 
 	query = `kube_limitrange`
 	result = common.MetricCollect(args, query, range5Min, "nameSpaceLimitrange", false)
 	if result != nil {
-		getNamespacelimits(result, "namespace")
+		c.getNamespacelimits(result, "namespace")
 	}
 
 	//Deployment metrics
 	query = `kube_deployment_labels`
 	result = common.MetricCollect(args, query, range5Min, "labels", false)
 	if result != nil {
-		getMidMetricString(result, "namespace", "deployment", "Deployment")
+		c.getMidMetricString(result, "namespace", "deployment", "Deployment")
 	}
 
 	query = `kube_deployment_spec_strategy_rollingupdate_max_surge`
 	result = common.MetricCollect(args, query, range5Min, "maxSurge", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "deployment", "maxSurge", "Deployment")
+		c.getMidMetric(result, "namespace", "deployment", "maxSurge", "Deployment")
 	}
 
 	query = `kube_deployment_spec_strategy_rollingupdate_max_unavailable`
 	result = common.MetricCollect(args, query, range5Min, "maxUnavailable", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "deployment", "maxUnavailable", "Deployment")
+		c.getMidMetric(result, "namespace", "deployment", "maxUnavailable", "Deployment")
 	}
 
 	query = `kube_deployment_metadata_generation`
 	result = common.MetricCollect(args, query, range5Min, "metadataGeneration", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "deployment", "metadataGeneration", "Deployment")
+		c.getMidMetric(result, "namespace", "deployment", "metadataGeneration", "Deployment")
 	}
 
 	query = `kube_deployment_created`
 	result = common.MetricCollect(args, query, range5Min, "deploymentCreated", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "deployment", "creationTime", "Deployment")
+		c.getMidMetric(result, "namespace", "deployment", "creationTime", "Deployment")
 	}
 
 	//ReplicaSet metrics
 	query = `kube_replicaset_labels`
 	result = common.MetricCollect(args, query, range5Min, "replicaSetLabels", false)
 	if result != nil {
-		getMidMetricString(result, "namespace", "replicaset", "ReplicaSet")
+		c.getMidMetricString(result, "namespace", "replicaset", "ReplicaSet")
 	}
 
 	query = `kube_replicaset_created`
 	result = common.MetricCollect(args, query, range5Min, "replicaSetCreated", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "replicaset", "creationTime", "ReplicaSet")
+		c.getMidMetric(result, "namespace", "replicaset", "creationTime", "ReplicaSet")
 	}
 
 	//ReplicationController metrics
 	query = `kube_replicationcontroller_created`
 	result = common.MetricCollect(args, query, range5Min, "replicationControllerCreated", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "replicationcontroller", "creationTime", "ReplicationController")
+		c.getMidMetric(result, "namespace", "replicationcontroller", "creationTime", "ReplicationController")
 	}
 
 	//DaemonSet metrics
 	query = `kube_daemonset_labels`
 	result = common.MetricCollect(args, query, range5Min, "daemonSetLabels", false)
 	if result != nil {
-		getMidMetricString(result, "namespace", "daemonset", "DaemonSet")
+		c.getMidMetricString(result, "namespace", "daemonset", "DaemonSet")
 	}
 
 	query = `kube_daemonset_created`
 	result = common.MetricCollect(args, query, range5Min, "daemonSetCreated", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "daemonset", "creationTime", "DaemonSet")
+		c.getMidMetric(result, "namespace", "daemonset", "creationTime", "DaemonSet")
 	}
 
 	//StatefulSet metrics
 	query = `kube_statefulset_labels`
 	result = common.MetricCollect(args, query, range5Min, "statefulSetLabels", false)
 	if result != nil {
-		getMidMetricString(result, "namespace", "statefulset", "StatefulSet")
+		c.getMidMetricString(result, "namespace", "statefulset", "StatefulSet")
 	}
 
 	query = `kube_statefulset_created`
 	result = common.MetricCollect(args, query, range5Min, "statefulSetCreated", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "statefulset", "creationTime", "StatefulSet")
+		c.getMidMetric(result, "namespace", "statefulset", "creationTime", "StatefulSet")
 	}
 
 	//Job metrics
 	query = `kube_job_info * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`
 	result = common.MetricCollect(args, query, range5Min, "jobInfo", false)
 	if result != nil {
-		getMidMetricString(result, "namespace", "job_name", "Job")
+		c.getMidMetricString(result, "namespace", "job_name", "Job")
 	}
 
 	query = `kube_job_labels * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`
 	result = common.MetricCollect(args, query, range5Min, "jobLabel", false)
 	if result != nil {
-		getMidMetricString(result, "namespace", "job_name", "Job")
+		c.getMidMetricString(result, "namespace", "job_name", "Job")
 	}
 
 	query = `kube_job_spec_completions * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`
 	result = common.MetricCollect(args, query, range5Min, "jobSpecCompletions", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "job_name", "specCompletions", "Job")
+		c.getMidMetric(result, "namespace", "job_name", "specCompletions", "Job")
 	}
 
 	query = `kube_job_spec_parallelism * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`
 	result = common.MetricCollect(args, query, range5Min, "jobSpecParallelism", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "job_name", "specParallelism", "Job")
+		c.getMidMetric(result, "namespace", "job_name", "specParallelism", "Job")
 	}
 
 	query = `kube_job_status_completion_time * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`
 	result = common.MetricCollect(args, query, range5Min, "jobStatusCompletionTime", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "job_name", "statusCompletionTime", "Job")
+		c.getMidMetric(result, "namespace", "job_name", "statusCompletionTime", "Job")
 	}
 
 	query = `kube_job_status_start_time * on (namespace,job_name) group_left (owner_name) max(kube_job_owner) by (namespace, job_name, owner_name)`
 	result = common.MetricCollect(args, query, range5Min, "jobStatusStartTime", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "job_name", "statusStartTime", "Job")
+		c.getMidMetric(result, "namespace", "job_name", "statusStartTime", "Job")
 	}
 
 	query = `kube_job_created`
 	result = common.MetricCollect(args, query, range5Min, "jobCreated", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "job", "creationTime", "Job")
+		c.getMidMetric(result, "namespace", "job", "creationTime", "Job")
 	}
 
 	//CronJob metrics
 	query = `kube_cronjob_labels`
 	result = common.MetricCollect(args, query, range5Min, "cronJobLabels", false)
 	if result != nil {
-		getMidMetricString(result, "namespace", "cronjob", "CronJob")
+		c.getMidMetricString(result, "namespace", "cronjob", "CronJob")
 	}
 
 	query = `kube_cronjob_info`
 	result = common.MetricCollect(args, query, range5Min, "cronJobInfo", false)
 	if result != nil {
-		getMidMetricString(result, "namespace", "cronjob", "CronJob")
+		c.getMidMetricString(result, "namespace", "cronjob", "CronJob")
 	}
 
 	query = `kube_cronjob_next_schedule_time`
 	result = common.MetricCollect(args, query, range5Min, "cronJobNextScheduleTime", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "cronjob", "nextScheduleTime", "CronJob")
+		c.getMidMetric(result, "namespace", "cronjob", "nextScheduleTime", "CronJob")
 	}
 
 	query = `kube_cronjob_status_last_schedule_time`
 	result = common.MetricCollect(args, query, range5Min, "cronJobStatusLastScheduleTime", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "cronjob", "lastScheduleTime", "CronJob")
+		c.getMidMetric(result, "namespace", "cronjob", "lastScheduleTime", "CronJob")
 	}
 
 	query = `kube_cronjob_status_active`
 	result = common.MetricCollect(args, query, range5Min, "cronJobStatusActive", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "cronjob", "statusActive", "CronJob")
+		c.getMidMetric(result, "namespace", "cronjob", "statusActive", "CronJob")
 	}
 
 	query = `kube_cronjob_created`
 	result = common.MetricCollect(args, query, range5Min, "cronJobCreated", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "cronjob", "creationTime", "CronJob")
+		c.getMidMetric(result, "namespace", "cronjob", "creationTime", "CronJob")
 	}
 
 	//HPA metrics
 	query = `kube_hpa_labels`
 	result = common.MetricCollect(args, query, range5Min, "hpaLabels", false)
 	if result != nil {
-		getHPAMetricString(result, "namespace", "hpa", args)
+		c.getHPAMetricString(result, "namespace", "hpa")
 	}
 
-	//Current size workloads
-	currentSizeWrite, err := os.Create("./data/container/currentSize.csv")
-	if err != nil {
-		args.ErrorLogger.Println("entity=" + entityKind + " message=" + err.Error())
-		fmt.Println("entity=" + entityKind + " message=" + err.Error())
-		return
+	//Annotation metrics, only used to find the workloads opted out with the exclude annotation.
+	if !args.ExcludeAnnotation.Empty() {
+		query = `kube_pod_annotations`
+		result = common.MetricCollect(args, query, range5Min, "podAnnotations", false)
+		if result != nil {
+			c.getExclusions(result, "namespace", "pod", "Pod")
+		}
+
+		query = `kube_deployment_annotations`
+		result = common.MetricCollect(args, query, range5Min, "deploymentAnnotations", false)
+		if result != nil {
+			c.getExclusions(result, "namespace", "deployment", "Deployment")
+		}
+
+		query = `kube_replicaset_annotations`
+		result = common.MetricCollect(args, query, range5Min, "replicaSetAnnotations", false)
+		if result != nil {
+			c.getExclusions(result, "namespace", "replicaset", "ReplicaSet")
+		}
+
+		query = `kube_daemonset_annotations`
+		result = common.MetricCollect(args, query, range5Min, "daemonSetAnnotations", false)
+		if result != nil {
+			c.getExclusions(result, "namespace", "daemonset", "DaemonSet")
+		}
+
+		query = `kube_statefulset_annotations`
+		result = common.MetricCollect(args, query, range5Min, "statefulSetAnnotations", false)
+		if result != nil {
+			c.getExclusions(result, "namespace", "statefulset", "StatefulSet")
+		}
+
+		query = `kube_job_annotations`
+		result = common.MetricCollect(args, query, range5Min, "jobAnnotations", false)
+		if result != nil {
+			c.getExclusions(result, "namespace", "job_name", "Job")
+		}
+
+		query = `kube_cronjob_annotations`
+		result = common.MetricCollect(args, query, range5Min, "cronJobAnnotations", false)
+		if result != nil {
+			c.getExclusions(result, "namespace", "cronjob", "CronJob")
+		}
+	}
+
+	c.exclude()
+	c.filter()
+
+	//Current size workloads. The current sizes are still stored in the entities when the workloads are skipped, only the window of the workload is left out.
+	var currentSize *entity.WorkloadWindow
+	if !args.SkipWorkloads {
+		workload := common.NewCurrentWorkload(args, "container", "currentSize", "Auto Scaling - In Service Instances", "namespace", "entity_name", "entity_type", "container")
+		c.workloads = append(c.workloads, workload)
+		currentSize = workload.Window(historyInterval)
 	}
-	fmt.Fprintf(currentSizeWrite, "cluster,namespace,entity_name,entity_type,container,Datetime,Auto Scaling - In Service Instances\n")
+	args = c.args.PlanFor("container", "container/attributes.csv,container/currentSize.csv")
 
 	query = `kube_replicaset_spec_replicas`
 	result = common.MetricCollect(args, query, range5Min, "replicaSetSpecReplicas", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "replicaset", "currentSize", "ReplicaSet")
+		c.getMidMetric(result, "namespace", "replicaset", "currentSize", "ReplicaSet")
 	}
-	writeWorkloadMid(currentSizeWrite, result, "namespace", "replicaset", args, "ReplicaSet")
+	c.addWorkloadMid(currentSize, result, "namespace", "replicaset", "ReplicaSet")
 
 	query = `kube_replicationcontroller_spec_replicas`
 	result = common.MetricCollect(args, query, range5Min, "replicationcontroller_spec_replicas", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "replicationcontroller", "currentSize", "ReplicationController")
+		c.getMidMetric(result, "namespace", "replicationcontroller", "currentSize", "ReplicationController")
 	}
-	writeWorkloadMid(currentSizeWrite, result, "namespace", "replicationcontroller", args, "ReplicationController")
+	c.addWorkloadMid(currentSize, result, "namespace", "replicationcontroller", "ReplicationController")
 
 	query = `kube_daemonset_status_number_available`
 	result = common.MetricCollect(args, query, range5Min, "daemonSetStatusNumberAvailable", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "daemonset", "currentSize", "DaemonSet")
+		c.getMidMetric(result, "namespace", "daemonset", "currentSize", "DaemonSet")
 	}
-	writeWorkloadMid(currentSizeWrite, result, "namespace", "daemonset", args, "DaemonSet")
+	c.addWorkloadMid(currentSize, result, "namespace", "daemonset", "DaemonSet")
 
 	query = `kube_statefulset_replicas`
 	result = common.MetricCollect(args, query, range5Min, "statefulSetReplicas", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "statefulset", "currentSize", "StatefulSet")
+		c.getMidMetric(result, "namespace", "statefulset", "currentSize", "StatefulSet")
 	}
-	writeWorkloadMid(currentSizeWrite, result, "namespace", "statefulset", args, "StatefulSet")
+	c.addWorkloadMid(currentSize, result, "namespace", "statefulset", "StatefulSet")
 
 	query = `kube_job_spec_parallelism`
 	result = common.MetricCollect(args, query, range5Min, "jobSpecParallelism", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "job_name", "currentSize", "Job")
+		c.getMidMetric(result, "namespace", "job_name", "currentSize", "Job")
 	}
-	writeWorkloadMid(currentSizeWrite, result, "namespace", "job_name", args, "Job")
+	c.addWorkloadMid(currentSize, result, "namespace", "job_name", "Job")
 
 	query = `sum(max(kube_job_spec_parallelism) by (namespace,job_name) * on (namespace,job_name) group_right max(kube_job_owner) by (namespace, job_name, owner_name)) by (owner_name, namespace)`
 	result = common.MetricCollect(args, query, range5Min, "cronJobSpecParallelism", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "owner_name", "currentSize", "CronJob")
+		c.getMidMetric(result, "namespace", "owner_name", "currentSize", "CronJob")
 	}
-	writeWorkloadMid(currentSizeWrite, result, "namespace", "owner_name", args, "CronJob")
+	c.addWorkloadMid(currentSize, result, "namespace", "owner_name", "CronJob")
 
 	query = `sum(max(kube_replicaset_spec_replicas) by (namespace,replicaset) * on (namespace,replicaset) group_right max(kube_replicaset_owner) by (namespace, replicaset, owner_name)) by (owner_name, namespace)`
 	result = common.MetricCollect(args, query, range5Min, "replicaSetSpecReplicas", false)
 	if result != nil {
-		getMidMetric(result, "namespace", "owner_name", "currentSize", "Deployment")
+		c.getMidMetric(result, "namespace", "owner_name", "currentSize", "Deployment")
 	}
-	writeWorkloadMid(currentSizeWrite, result, "namespace", "owner_name", args, "Deployment")
+	c.addWorkloadMid(currentSize, result, "namespace", "owner_name", "Deployment")
 
-	currentSizeWrite.Close()
-
-	writeAttributes(args)
-	writeConfig(args)
+	if args.SkipWorkloads {
+		return c.result()
+	}
 
 	queryPrefix := ``
 	querySuffix := ``
-	if args.LabelSuffix != "" {
+	if c.labelSuffix != "" {
 		queryPrefix = `label_replace(`
 		querySuffix = `, "pod", "$1", "pod_name", "(.*)")`
 	}
 
 	//Container workloads
-	query = queryPrefix + `round(max(irate(container_cpu_usage_seconds_total{name!~"k8s_POD_.*"}[` + args.SampleRateString + `m])) by (instance,pod` + args.LabelSuffix + `,namespace,container` + args.LabelSuffix + `)*1000,1)` + querySuffix
-	getWorkload("cpu_mCores_workload", "CPU Utilization in mCores", query, "max", args)
-	getWorkload("cpu_mCores_workload", "Prometheus CPU Utilization in mCores", query, "avg", args)
+	query = queryPrefix + `round(max(irate(container_cpu_usage_seconds_total{name!~"k8s_POD_.*"}[` + args.RateWindow + `])) by (instance,pod` + c.labelSuffix + `,namespace,container` + c.labelSuffix + `)*1000,1)` + querySuffix
+	c.getWorkload("cpu_mCores_workload", "CPU Utilization in mCores", query, "max")
+	c.getWorkload("cpu_mCores_workload", "Prometheus CPU Utilization in mCores", query, "avg")
 
-	query = queryPrefix + `max(container_memory_usage_bytes{name!~"k8s_POD_.*"}) by (instance,pod` + args.LabelSuffix + `,namespace,container` + args.LabelSuffix + `)` + querySuffix
-	getWorkload("mem_workload", "Raw Mem Utilization", query, "max", args)
-	getWorkload("mem_workload", "Prometheus Raw Mem Utilization", query, "avg", args)
+	query = queryPrefix + `max(container_memory_usage_bytes{name!~"k8s_POD_.*"}) by (instance,pod` + c.labelSuffix + `,namespace,container` + c.labelSuffix + `)` + querySuffix
+	c.getWorkload("mem_workload", "Raw Mem Utilization", query, "max")
+	c.getWorkload("mem_workload", "Prometheus Raw Mem Utilization", query, "avg")
 
-	query = queryPrefix + `max(container_memory_rss{name!~"k8s_POD_.*"}) by (instance,pod` + args.LabelSuffix + `,namespace,container` + args.LabelSuffix + `)` + querySuffix
-	getWorkload("rss_workload", "Actual Memory Utilization", query, "max", args)
-	getWorkload("rss_workload", "Prometheus Actual Memory Utilization", query, "avg", args)
+	query = queryPrefix + `max(container_memory_rss{name!~"k8s_POD_.*"}) by (instance,pod` + c.labelSuffix + `,namespace,container` + c.labelSuffix + `)` + querySuffix
+	c.getWorkload("rss_workload", "Actual Memory Utilization", query, "max")
+	c.getWorkload("rss_workload", "Prometheus Actual Memory Utilization", query, "avg")
 
-	query = queryPrefix + `max(container_fs_usage_bytes{name!~"k8s_POD_.*"}) by (instance,pod` + args.LabelSuffix + `,namespace,container` + args.LabelSuffix + `)` + querySuffix
-	getWorkload("disk_workload", "Raw Disk Utilization", query, "max", args)
-	getWorkload("disk_workload", "Prometheus Raw Disk Utilization", query, "avg", args)
+	query = queryPrefix + `max(container_fs_usage_bytes{name!~"k8s_POD_.*"}) by (instance,pod` + c.labelSuffix + `,namespace,container` + c.labelSuffix + `)` + querySuffix
+	c.getWorkload("disk_workload", "Raw Disk Utilization", query, "max")
+	c.getWorkload("disk_workload", "Prometheus Raw Disk Utilization", query, "avg")
 
-	if args.LabelSuffix != "" {
+	if c.labelSuffix != "" {
 		queryPrefix = `label_replace(`
 		querySuffix = `, "container_name", "$1", "container", "(.*)")`
 	}
-	query = queryPrefix + `max(irate(kube_pod_container_status_restarts_total{name!~"k8s_POD_.*"}[` + args.SampleRateString + `m])) by (instance,pod,namespace,container)` + querySuffix
-	getWorkload("restarts", "Restarts", query, "max", args)
+	query = queryPrefix + `max(irate(kube_pod_container_status_restarts_total{name!~"k8s_POD_.*"}[` + args.RateWindow + `])) by (instance,pod,namespace,container)` + querySuffix
+	c.getWorkload("restarts", "Restarts", query, "max")
 
-	if args.LabelSuffix == "" {
+	if c.labelSuffix == "" {
 		query = `kube_hpa_status_condition{status="true",condition="ScalingLimited"}`
 	} else {
 		query = `kube_hpa_status_condition{status="ScalingLimited",condition="true"}`
 	}
-	getHPAWorkload("condition_scaling_limited", "Scaling Limited", query, args)
+	c.getHPAWorkload("condition_scaling_limited", "Scaling Limited", query)
 
 	//HPA workloads
 	query = `kube_hpa_spec_max_replicas`
-	getHPAWorkload("max_replicas", "Auto Scaling - Maximum Size", query, args)
+	c.getHPAWorkload("max_replicas", "Auto Scaling - Maximum Size", query)
 
 	query = `kube_hpa_spec_min_replicas`
-	getHPAWorkload("min_replicas", "Auto Scaling - Minimum Size", query, args)
+	c.getHPAWorkload("min_replicas", "Auto Scaling - Minimum Size", query)
 
 	query = `kube_hpa_status_current_replicas`
-	getHPAWorkload("current_replicas", "Auto Scaling - Total Instances", query, args)
+	c.getHPAWorkload("current_replicas", "Auto Scaling - Total Instances", query)
+
+	return c.result()
+}
 
+//exclude removes the namespaces and the controllers, along with their containers, that have opted out with the exclude annotation on themselves, their pods or their namespace. Each of them is logged and reported in the run summary.
+func (c *Collector) exclude() {
+	for n, ns := range c.systems {
+		if c.optedOutNamespaces[n] {
+			for key := range ns.Controllers {
+				c.remove(n, ns, key)
+			}
+			delete(c.systems, n)
+			c.report(summary.Exclusion{Namespace: n, Source: "namespace"})
+			continue
+		}
+		for key, controller := range ns.Controllers {
+			if source, ok := c.optedOut[controller]; ok {
+				c.remove(n, ns, key)
+				c.report(summary.Exclusion{Namespace: n, Kind: controller.Kind, Name: controller.Name, Source: source})
+			}
+		}
+		if len(ns.Controllers) == 0 {
+			delete(c.systems, n)
+		}
+	}
+	for name, hpa := range c.hpas {
+		if c.optedOutNamespaces[hpa.Namespace] {
+			delete(c.hpas, name)
+			c.excluded[hpa.Namespace+"__"+name] = true
+		}
+	}
+}
+
+func (c *Collector) report(e summary.Exclusion) {
+	e.Cluster = *c.args.ClusterName
+	c.args.Logger.Info("Excluded the workload opted out with the exclude annotation", "annotation", c.args.ExcludeAnnotation.String(), "namespace", e.Namespace, "kind", e.Kind, "name", e.Name, "source", e.Source)
+	c.args.Summary.Exclude(e)
+}
+
+//remove removes the controller, along with its containers and the owners pointing to it, and records it as excluded.
+func (c *Collector) remove(n string, ns *namespace, key string) {
+	controller := ns.Controllers[key]
+	delete(ns.Controllers, key)
+	for owner, top := range ns.pointers {
+		if top == controller {
+			delete(ns.pointers, owner)
+		}
+	}
+	c.excluded[n+"__"+controller.Name] = true
+}
+
+//filter removes the controllers, along with their containers, and the HPAs that don't match the label selector so they are left out of every file. The labels of a controller include those of its pods and a label it doesn't have is looked up in its namespace.
+func (c *Collector) filter() {
+	s := c.args.Selector
+	if s.Empty() {
+		return
+	}
+	controllers, hpas := 0, 0
+	for name, hpa := range c.hpas {
+		var namespaceLabels map[string]string
+		if ns, ok := c.systems[hpa.Namespace]; ok {
+			namespaceLabels = ns.Labels
+		}
+		if !s.Matches(hpa.Labels, namespaceLabels) {
+			delete(c.hpas, name)
+			c.excluded[hpa.Namespace+"__"+name] = true
+			hpas++
+		}
+	}
+	for n, ns := range c.systems {
+		for key, controller := range ns.Controllers {
+			if s.Matches(controller.Labels, ns.Labels) {
+				continue
+			}
+			c.remove(n, ns, key)
+			controllers++
+		}
+		if len(ns.Controllers) == 0 {
+			delete(c.systems, n)
+		}
+	}
+	c.args.Logger.Info("Removed the workloads that don't match the label selector", "selector", s.String(), "controllers", controllers, "hpas", hpas)
+}
+
+//result returns the entities found without the lookups used while parsing.
+func (c *Collector) result() *Result {
+	namespaces := map[string]*entity.Namespace{}
+	for name, ns := range c.systems {
+		namespaces[name] = ns.Namespace
+	}
+	return &Result{Namespaces: namespaces, HPAs: c.hpas, Workloads: c.workloads}
 }
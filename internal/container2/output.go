@@ -3,37 +3,44 @@ package container2
 
 import (
 	"fmt"
-	"io"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/entity"
 	"github.com/prometheus/common/model"
 )
 
+//Write creates the config, attributes and workload files for the entities and workloads found by the Collector.
+func Write(args *common.Parameters, result *Result) {
+	writeAttributes(args, result.Namespaces)
+	writeConfig(args, result.Namespaces)
+	if len(result.HPAs) > 0 {
+		writeHPAAttributes(args, result.HPAs)
+		writeHPAConfig(args, result.HPAs)
+	}
+	common.WriteWorkloads(args, result.Workloads)
+}
+
 //writeConfig will create the config.csv file that is will be sent Densify by the Forwarder.
-func writeConfig(args *common.Parameters) {
+func writeConfig(args *common.Parameters, namespaces map[string]*entity.Namespace) {
 	//Create the config file and open it for writing.
-	configWrite, err := os.Create("./data/container/config.csv")
+	configWrite, err := common.CreateFile(args, "container", "config", "cluster,namespace,entity_name,entity_type,container,HW Total Memory,OS Name,HW Manufacturer")
 	if err != nil {
-		args.ErrorLogger.Println("entity=" + entityKind + " message=" + err.Error())
-		fmt.Println("entity=" + entityKind + " message=" + err.Error())
+		args.Logger.Error(err.Error(), "entity", entityKind)
 		return
 	}
-
-	//Write out the header.
-	fmt.Fprintln(configWrite, "cluster,namespace,entity_name,entity_type,container,HW Total Memory,OS Name,HW Manufacturer")
+	defer configWrite.Close()
 
 	//Loop through the systems and write out the config data for each system.
-	for kn := range systems {
-		for kt, vt := range systems[kn].midLevels {
-			for kc, vc := range systems[kn].midLevels[kt].containers {
+	for kn := range namespaces {
+		for kt, vt := range namespaces[kn].Controllers {
+			for kc, vc := range namespaces[kn].Controllers[kt].Containers {
 				//If memory is not set then use first write that will leave it blank otherwise use the second that sets the value.
-				if vc.memory == -1 || vc.memory == 0 {
-					fmt.Fprintf(configWrite, "%s,%s,%s,%s,%s,,Linux,CONTAINERS\n", *args.ClusterName, kn, vt.name, vt.kind, strings.Replace(kc, ":", ".", -1))
+				if vc.Memory == -1 || vc.Memory == 0 {
+					fmt.Fprintf(configWrite, "%s,%s,%s,%s,%s,,Linux,CONTAINERS\n", *args.ClusterName, kn, vt.Name, vt.Kind, strings.Replace(kc, ":", ".", -1))
 				} else {
-					fmt.Fprintf(configWrite, "%s,%s,%s,%s,%s,%d,Linux,CONTAINERS\n", *args.ClusterName, kn, vt.name, vt.kind, strings.Replace(kc, ":", ".", -1), vc.memory)
+					fmt.Fprintf(configWrite, "%s,%s,%s,%s,%s,%d,Linux,CONTAINERS\n", *args.ClusterName, kn, vt.Name, vt.Kind, strings.Replace(kc, ":", ".", -1), vc.Memory)
 				}
 			}
 		}
@@ -41,53 +48,47 @@ func writeConfig(args *common.Parameters) {
 }
 
 //writeConfig will create the config.csv file that is will be sent Densify by the Forwarder.
-func writeHPAConfig(args *common.Parameters, systems map[string]map[string]string) {
+func writeHPAConfig(args *common.Parameters, hpas map[string]*entity.HPA) {
 	//Create the config file and open it for writing.
-	configWrite, err := os.Create("./data/hpa/hpa_extra_config.csv")
+	configWrite, err := common.CreateFile(args, "hpa", "hpa_extra_config", "cluster,namespace,entity_name,entity_type,container,HPA Name,OS Name,HW Manufacturer")
 	if err != nil {
-		args.ErrorLogger.Println("entity=" + entityKind + " message=" + err.Error())
-		fmt.Println("entity=" + entityKind + " message=" + err.Error())
+		args.Logger.Error(err.Error(), "entity", entityKind)
 		return
 	}
-
-	//Write out the header.
-	fmt.Fprintln(configWrite, "cluster,namespace,entity_name,entity_type,container,HPA Name,OS Name,HW Manufacturer")
+	defer configWrite.Close()
 
 	//Loop through the systems and write out the config data for each system.
-	for i := range systems {
+	for i, hpa := range hpas {
 		//Write out the different fields. For fiels that are numeric we don't want to write -1 if it wasn't set so we write a blank if that is the value otherwise we write the number out.
-		fmt.Fprintf(configWrite, "%s,%s,,,,%s,Linux,HPA", *args.ClusterName, systems[i]["namespace"], i)
+		fmt.Fprintf(configWrite, "%s,%s,,,,%s,Linux,HPA", *args.ClusterName, hpa.Namespace, i)
 		fmt.Fprintf(configWrite, "\n")
 	}
 }
 
 //writeAttributes will create the attributes.csv file that is will be sent Densify by the Forwarder.
-func writeAttributes(args *common.Parameters) {
+func writeAttributes(args *common.Parameters, namespaces map[string]*entity.Namespace) {
 	//Create the attributes file and open it for writing
-	attributeWrite, err := os.Create("./data/container/attributes.csv")
+	attributeWrite, err := common.CreateFile(args, "container", "attributes", args.LabelColumns.Header("cluster,namespace,entity_name,entity_type,container,Virtual Technology,Virtual Domain,Virtual Datacenter,Virtual Cluster,Container Labels,Pod Labels,Existing CPU Limit,Existing CPU Request,Existing Memory Limit,Existing Memory Request,Container Name,Current Nodes,Power State,Created By Kind,Created By Name,Current Size,Create Time,Container Restarts,Namespace Labels,Namespace CPU Request,Namespace CPU Limit,Namespace Memory Request,Namespace Memory Limit"))
 	if err != nil {
-		args.ErrorLogger.Println("entity=" + entityKind + " message=" + err.Error())
-		fmt.Println("entity=" + entityKind + " message=" + err.Error())
+		args.Logger.Error(err.Error(), "entity", entityKind)
 		return
 	}
-
-	//Write out the header.
-	fmt.Fprintln(attributeWrite, "cluster,namespace,entity_name,entity_type,container,Virtual Technology,Virtual Domain,Virtual Datacenter,Virtual Cluster,Container Labels,Pod Labels,Existing CPU Limit,Existing CPU Request,Existing Memory Limit,Existing Memory Request,Container Name,Current Nodes,Power State,Created By Kind,Created By Name,Current Size,Create Time,Container Restarts,Namespace Labels,Namespace CPU Request,Namespace CPU Limit,Namespace Memory Request,Namespace Memory Limit")
+	defer attributeWrite.Close()
 
 	//Loop through the systems and write out the attributes data for each system.
-	for kn, vn := range systems {
-		for kt, vt := range systems[kn].midLevels {
-			for kc, vc := range systems[kn].midLevels[kt].containers {
+	for kn, vn := range namespaces {
+		for kt, vt := range namespaces[kn].Controllers {
+			for kc, vc := range namespaces[kn].Controllers[kt].Containers {
 				var cstate = "Running"
 				//convert the powerState from number to string 1 is Terminated and 0 is running.
-				if vc.powerState == 1 {
+				if vc.PowerState == 1 {
 					cstate = "Terminated"
 				}
-				//for wt, vt := range systems[kn].pods
+				//for wt, vt := range namespaces[kn].pods
 				//Write out the different fields. For fiels that are numeric we don't want to write -1 if it wasn't set so we write a blank if that is the value otherwise we write the number out.
-				fmt.Fprintf(attributeWrite, "%s,%s,%s,%s,%s,Containers,%s,%s,%s,", *args.ClusterName, kn, strings.Replace(vt.name, ";", ".", -1), vt.kind, strings.Replace(kc, ":", ".", -1), *args.ClusterName, kn, vt.name)
-				for key, value := range systems[kn].midLevels[kt].containers[kc].labelMap {
-					if len(key) >= 250 {
+				fmt.Fprintf(attributeWrite, "%s,%s,%s,%s,%s,Containers,%s,%s,%s,", *args.ClusterName, kn, strings.Replace(vt.Name, ";", ".", -1), vt.Kind, strings.Replace(kc, ":", ".", -1), *args.ClusterName, kn, vt.Name)
+				for key, value := range namespaces[kn].Controllers[kt].Containers[kc].Labels {
+					if len(key) >= 250 || !args.LabelFilter.Keep("container", key) {
 						continue
 					}
 					value = strings.Replace(value, ",", " ", -1)
@@ -101,8 +102,8 @@ func writeAttributes(args *common.Parameters) {
 				}
 				fmt.Fprintf(attributeWrite, ",")
 
-				for key, value := range vt.labelMap {
-					if len(key) >= 250 {
+				for key, value := range vt.Labels {
+					if len(key) >= 250 || !args.LabelFilter.Keep("pod", key) {
 						continue
 					}
 					value = strings.Replace(value, ",", " ", -1)
@@ -114,45 +115,45 @@ func writeAttributes(args *common.Parameters) {
 					}
 				}
 
-				if vc.cpuLimit == -1 {
+				if vc.CPULimit == -1 {
 					fmt.Fprintf(attributeWrite, ",")
 				} else {
-					fmt.Fprintf(attributeWrite, ",%d", vc.cpuLimit)
+					fmt.Fprintf(attributeWrite, ",%d", vc.CPULimit)
 				}
-				if vc.cpuRequest == -1 {
+				if vc.CPURequest == -1 {
 					fmt.Fprintf(attributeWrite, ",")
 				} else {
-					fmt.Fprintf(attributeWrite, ",%d", vc.cpuRequest)
+					fmt.Fprintf(attributeWrite, ",%d", vc.CPURequest)
 				}
-				if vc.memLimit == -1 {
+				if vc.MemLimit == -1 {
 					fmt.Fprintf(attributeWrite, ",")
 				} else {
-					fmt.Fprintf(attributeWrite, ",%d", vc.memLimit)
+					fmt.Fprintf(attributeWrite, ",%d", vc.MemLimit)
 				}
-				if vc.memRequest == -1 {
+				if vc.MemRequest == -1 {
 					fmt.Fprintf(attributeWrite, ",")
 				} else {
-					fmt.Fprintf(attributeWrite, ",%d", vc.memRequest)
+					fmt.Fprintf(attributeWrite, ",%d", vc.MemRequest)
 				}
-				fmt.Fprintf(attributeWrite, ",%s,%s,%s,%s,%s", kc, strings.Replace(vt.labelMap["node"], ";", "|", -1), cstate, vt.kind, vt.name)
-				if vt.currentSize == -1 {
+				fmt.Fprintf(attributeWrite, ",%s,%s,%s,%s,%s", kc, strings.Replace(vt.Labels["node"], ";", "|", -1), cstate, vt.Kind, vt.Name)
+				if vt.CurrentSize == -1 {
 					fmt.Fprintf(attributeWrite, ",")
 				} else {
-					fmt.Fprintf(attributeWrite, ",%d", vt.currentSize)
+					fmt.Fprintf(attributeWrite, ",%d", vt.CurrentSize)
 				}
-				if vt.creationTime == -1 {
+				if vt.CreationTime == -1 {
 					fmt.Fprintf(attributeWrite, ",")
 				} else {
 					//Formatting the date into the expexted format. Note the reason for that date is a Go specific way of declaring a format you must use that exact date and time.
-					fmt.Fprintf(attributeWrite, ",%s", time.Unix(int64(vt.creationTime), 0).Format("2006-01-02 15:04:05.000"))
+					fmt.Fprintf(attributeWrite, ",%s", time.Unix(int64(vt.CreationTime), 0).Format("2006-01-02 15:04:05.000"))
 				}
-				if vc.restarts == -1 {
+				if vc.Restarts == -1 {
 					fmt.Fprintf(attributeWrite, ",,")
 				} else {
-					fmt.Fprintf(attributeWrite, ",%d,", vc.restarts)
+					fmt.Fprintf(attributeWrite, ",%d,", vc.Restarts)
 				}
-				for key, value := range vn.labelMap {
-					if len(key) >= 250 {
+				for key, value := range vn.Labels {
+					if len(key) >= 250 || !args.LabelFilter.Keep("namespace", key) {
 						continue
 					}
 					value = strings.Replace(value, ",", " ", -1)
@@ -164,49 +165,49 @@ func writeAttributes(args *common.Parameters) {
 					}
 				}
 				// TODO: Not sure but order of these writes is different. Neet to check file format.
-				if vn.cpuRequest == -1 {
+				if vn.CPURequest == -1 {
 					fmt.Fprintf(attributeWrite, ",")
 				} else {
-					fmt.Fprintf(attributeWrite, ",%d", vn.cpuRequest)
+					fmt.Fprintf(attributeWrite, ",%d", vn.CPURequest)
 				}
-				if vn.cpuLimit == -1 {
+				if vn.CPULimit == -1 {
 					fmt.Fprintf(attributeWrite, ",")
 				} else {
-					fmt.Fprintf(attributeWrite, ",%d", vn.cpuLimit)
+					fmt.Fprintf(attributeWrite, ",%d", vn.CPULimit)
 				}
-				if vn.memRequest == -1 {
+				if vn.MemRequest == -1 {
 					fmt.Fprintf(attributeWrite, ",")
 				} else {
-					fmt.Fprintf(attributeWrite, ",%d", vn.memRequest)
+					fmt.Fprintf(attributeWrite, ",%d", vn.MemRequest)
 				}
-				if vn.memLimit == -1 {
+				if vn.MemLimit == -1 {
 					fmt.Fprintf(attributeWrite, ",")
 				} else {
-					fmt.Fprintf(attributeWrite, ",%d", vn.memLimit)
+					fmt.Fprintf(attributeWrite, ",%d", vn.MemLimit)
 				}
-				fmt.Fprintf(attributeWrite, "\n")
+				fmt.Fprintf(attributeWrite, "%s\n", args.LabelColumns.Row(map[string]map[string]string{"container": vc.Labels, "pod": vt.Labels, "namespace": vn.Labels}))
 			}
 		}
 	}
 }
 
 //writeAttributes will create the attributes.csv file that is will be sent Densify by the Forwarder.
-func writeHPAAttributes(args *common.Parameters, systems map[string]map[string]string) {
+func writeHPAAttributes(args *common.Parameters, hpas map[string]*entity.HPA) {
 	//Create the attributes file and open it for writing
-	attributeWrite, err := os.Create("./data/hpa/hpa_extra_attributes.csv")
+	attributeWrite, err := common.CreateFile(args, "hpa", "hpa_extra_attributes", "cluster,namespace,entity_name,entity_type,container,HPA Name,Labels")
 	if err != nil {
-		args.ErrorLogger.Println("entity=" + entityKind + " message=" + err.Error())
-		fmt.Println("entity=" + entityKind + " message=" + err.Error())
+		args.Logger.Error(err.Error(), "entity", entityKind)
 		return
 	}
-
-	//Write out the header.
-	fmt.Fprintln(attributeWrite, "cluster,namespace,entity_name,entity_type,container,HPA Name,Labels")
+	defer attributeWrite.Close()
 	//Loop through the systems and write out the attributes data for each system.
-	for i := range systems {
+	for i, hpa := range hpas {
 		//Write out the different fields. For fiels that are numeric we don't want to write -1 if it wasn't set so we write a blank if that is the value otherwise we write the number out.
-		fmt.Fprintf(attributeWrite, "%s,%s,,,,%s,", *args.ClusterName, systems[i]["namespace"], i)
-		for key, value := range systems[i] {
+		fmt.Fprintf(attributeWrite, "%s,%s,,,,%s,", *args.ClusterName, hpa.Namespace, i)
+		for key, value := range hpa.Labels {
+			if !args.LabelFilter.Keep("hpa", key) {
+				continue
+			}
 			value = strings.Replace(value, ",", " ", -1)
 			if len(value)+3+len(key) < 256 {
 				fmt.Fprintf(attributeWrite, key+" : "+value+"|")
@@ -219,8 +220,8 @@ func writeHPAAttributes(args *common.Parameters, systems map[string]map[string]s
 	}
 }
 
-//writeWorkload will write out the workload data specific to metric provided to the file that was passed in.
-func writeWorkload(file io.Writer, result model.Value, namespace, pod, container model.LabelName, args *common.Parameters, kind string) {
+//addWorkload adds the series of the result to the window, for the containers of the controllers found.
+func (c *Collector) addWorkload(window *entity.WorkloadWindow, result model.Value, namespace, pod, container model.LabelName, kind string) {
 	var tempKind bool
 	if result == nil {
 		return
@@ -229,7 +230,7 @@ func writeWorkload(file io.Writer, result model.Value, namespace, pod, container
 		tempKind = true
 	}
 
-	//Loop through the results for the workload and validate that contains the required labels and that the entity exists in the systems data structure once validated will write out the workload for the system.
+	//Loop through the results for the workload and validate that contains the required labels and that the entity exists in the systems data structure once validated will add the workload for the system.
 	for i := 0; i < result.(model.Matrix).Len(); i++ {
 		if tempKind {
 			kind = string(result.(model.Matrix)[i].Metric["owner_kind"])
@@ -238,58 +239,53 @@ func writeWorkload(file io.Writer, result model.Value, namespace, pod, container
 		if !ok {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)]; !ok {
+		if _, ok := c.systems[string(namespaceValue)]; !ok {
 			continue
 		}
 		podValue, ok := result.(model.Matrix)[i].Metric[pod]
 		if !ok {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)].midLevels[kind+"__"+string(podValue)]; !ok {
+		controller, ok := c.systems[string(namespaceValue)].Controllers[kind+"__"+string(podValue)]
+		if !ok {
 			continue
 		}
 		containerValue, ok := result.(model.Matrix)[i].Metric[container]
 		if !ok {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)].midLevels[kind+"__"+string(podValue)].containers[string(containerValue)]; !ok {
+		if _, ok := controller.Containers[string(containerValue)]; !ok {
 			continue
 		}
-		//Loop through the different values over the interval and write out each one to the workload file.
-		for j := 0; j < len(result.(model.Matrix)[i].Values); j++ {
-			fmt.Fprintf(file, "%s,%s,%s,%s,%s,%s,%f\n", *args.ClusterName, namespaceValue, systems[string(namespaceValue)].midLevels[kind+"__"+string(podValue)].name, systems[string(namespaceValue)].midLevels[kind+"__"+string(podValue)].kind, strings.Replace(string(containerValue), ":", ".", -1), time.Unix(0, int64(result.(model.Matrix)[i].Values[j].Timestamp)*1000000).Format("2006-01-02 15:04:05.000"), result.(model.Matrix)[i].Values[j].Value)
-		}
+		common.AddSeries(window, result.(model.Matrix)[i].Values, string(namespaceValue), controller.Name, controller.Kind, strings.Replace(string(containerValue), ":", ".", -1))
 	}
 }
 
-//writeWorkload will write out the workload data specific to metric provided to the file that was passed in.
-func writeWorkloadMid(file io.Writer, result model.Value, namespace, mid model.LabelName, args *common.Parameters, prefix string) {
-	if result == nil {
+//addWorkloadMid adds the series of the result to the window for each container of the controllers the owners in the result point to. Nothing is added if the window is nil.
+func (c *Collector) addWorkloadMid(window *entity.WorkloadWindow, result model.Value, namespace, mid model.LabelName, prefix string) {
+	if result == nil || window == nil {
 		return
 	}
 
-	//Loop through the results for the workload and validate that contains the required labels and that the entity exists in the systems data structure once validated will write out the workload for the system.
+	//Loop through the results for the workload and validate that contains the required labels and that the entity exists in the systems data structure once validated will add the workload for the system.
 	for i := 0; i < result.(model.Matrix).Len(); i++ {
 		namespaceValue, ok := result.(model.Matrix)[i].Metric[namespace]
 		if !ok {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)]; !ok {
+		if _, ok := c.systems[string(namespaceValue)]; !ok {
 			continue
 		}
 		midValue, ok := result.(model.Matrix)[i].Metric[mid]
 		if !ok {
 			continue
 		}
-		if _, ok := systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)]; !ok { //NOT PASSING THIS STATMENT
+		controller, ok := c.systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)]
+		if !ok {
 			continue
 		}
-		for kc := range systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)].containers {
-			//Loop through the different values over the interval and write out each one to the workload file.
-			for j := 0; j < len(result.(model.Matrix)[i].Values); j++ {
-				fmt.Fprintf(file, "%s,%s,%s,%s,%s,%s,%f\n", *args.ClusterName, namespaceValue, systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)].name, systems[string(namespaceValue)].pointers[prefix+"__"+string(midValue)].kind, strings.Replace(string(kc), ":", ".", -1), time.Unix(0, int64(result.(model.Matrix)[i].Values[j].Timestamp)*1000000).Format("2006-01-02 15:04:05.000"), result.(model.Matrix)[i].Values[j].Value)
-			}
-
+		for kc := range controller.Containers {
+			common.AddSeries(window, result.(model.Matrix)[i].Values, string(namespaceValue), controller.Name, controller.Kind, strings.Replace(kc, ":", ".", -1))
 		}
 	}
 }
@@ -0,0 +1,205 @@
+//Package logging writes the log of the data collection as structured records, one per line, in logfmt or JSON so they can be searched and parsed by log tools as well as read in the log file sent to Densify support.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Level is the severity of a log record.
+type Level int
+
+//Levels from the least to the most severe. Records below the level of the logger are dropped.
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	}
+	return "error"
+}
+
+//ParseLevel returns the level for debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+	return InfoLevel, fmt.Errorf("invalid log level %q, it must be debug, info, warn or error", s)
+}
+
+//Formats of the log records.
+const (
+	//Logfmt writes the fields as key=value pairs, e.g. time=2020-01-02T15:04:05Z level=info entity=node message="Collecting node data".
+	Logfmt = "logfmt"
+	//JSON writes each record as a JSON object.
+	JSON = "json"
+)
+
+//Logger writes log records with the fields added by With. Loggers returned by With share the writer of the logger they came from and are safe to use from several goroutines.
+type Logger struct {
+	out    io.Writer
+	mu     *sync.Mutex
+	json   bool
+	level  Level
+	fields []interface{}
+	hook   Hook
+}
+
+//Hook is called with every record written, after the level check, along with the fields of the record.
+type Hook func(level Level, msg string, fields []interface{})
+
+//New returns a logger writing records of at least level to w in the format, logfmt or json.
+func New(w io.Writer, format string, level Level) (*Logger, error) {
+	if format != Logfmt && format != JSON {
+		return nil, fmt.Errorf("invalid log format %q, it must be logfmt or json", format)
+	}
+	return &Logger{out: w, mu: &sync.Mutex{}, json: format == JSON, level: level}, nil
+}
+
+//With returns a logger that adds the key value pairs to every record, e.g. With("entity", "node").
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	c := *l
+	c.fields = append(append([]interface{}{}, l.fields...), keyvals...)
+	return &c
+}
+
+//WithHook returns a logger that also passes the records written to hook, e.g. to keep the warnings of a run.
+func (l *Logger) WithHook(hook Hook) *Logger {
+	c := *l
+	c.hook = hook
+	return &c
+}
+
+//Enabled returns true if records of the level are written, so expensive messages are only built when needed.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+//Debug writes a debug record with the message and key value pairs.
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(DebugLevel, msg, keyvals)
+}
+
+//Info writes an info record with the message and key value pairs.
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(InfoLevel, msg, keyvals)
+}
+
+//Warn writes a warning record with the message and key value pairs.
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(WarnLevel, msg, keyvals)
+}
+
+//Error writes an error record with the message and key value pairs.
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(ErrorLevel, msg, keyvals)
+}
+
+//log writes the record in the format of the logger. The time, level and caller come first, then the fields of the logger and the record, with the message last.
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	caller := "unknown"
+	if _, file, line, ok := runtime.Caller(2); ok {
+		caller = filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+
+	fields := append(append([]interface{}{}, l.fields...), keyvals...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "")
+	}
+	if l.hook != nil {
+		l.hook(level, msg, fields)
+	}
+	record := []interface{}{"time", time.Now().UTC().Format(time.RFC3339), "level", level.String(), "caller", caller}
+	record = append(append(record, fields...), "message", msg)
+
+	var b strings.Builder
+	if l.json {
+		writeJSON(&b, record)
+	} else {
+		writeLogfmt(&b, record)
+	}
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.out, b.String())
+}
+
+//writeJSON writes the key value pairs as a JSON object.
+func writeJSON(b *strings.Builder, record []interface{}) {
+	b.WriteByte('{')
+	for i := 0; i < len(record); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(record[i]))
+		b.Write(key)
+		b.WriteByte(':')
+		value, err := json.Marshal(jsonValue(record[i+1]))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(record[i+1]))
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+}
+
+//jsonValue returns the value to marshal, using the string form of durations and errors rather than their internal representation.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Duration:
+		return v.String()
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+//writeLogfmt writes the key value pairs as key=value, quoting values that contain spaces, quotes, equals signs or control characters.
+func writeLogfmt(b *strings.Builder, record []interface{}) {
+	for i := 0; i < len(record); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(fmt.Sprint(record[i]))
+		b.WriteByte('=')
+		value := fmt.Sprint(record[i+1])
+		if value == "" || strings.IndexFunc(value, needsQuote) >= 0 {
+			value = strconv.Quote(value)
+		}
+		b.WriteString(value)
+	}
+}
+
+func needsQuote(r rune) bool {
+	return r <= ' ' || r == '=' || r == '"' || r == 0x7f
+}
@@ -0,0 +1,320 @@
+//Package namespace collects the namespaces as entities of their own, with their LimitRanges, ResourceQuotas and the totals of their containers, and formats them into csv files to send to Densify.
+package namespace
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/entity"
+	"github.com/prometheus/common/model"
+)
+
+//Hard-coded string for log file warnings
+var entityKind = "namespace"
+
+//containerSelector selects the series of the containers from cAdvisor, leaving out the pause containers and the totals of the pods.
+const containerSelector = `{image!="",name!~"k8s_POD_.*",container!="POD"}`
+
+//Collector collects the namespace data for a single run. Create a new one with NewCollector for every run as it holds the namespaces found.
+type Collector struct {
+	args       *common.Parameters
+	namespaces map[string]*entity.NamespaceSettings
+	workloads  []*common.Workload
+}
+
+//NewCollector returns a Collector that queries Prometheus using the parameters provided. Only the namespaces of the namespace filters are queried.
+func NewCollector(args *common.Parameters) *Collector {
+	return &Collector{args: args.WithMatcher(args.NamespaceMatcher), namespaces: map[string]*entity.NamespaceSettings{}}
+}
+
+//getNamespaceMetricString is used to parse the label based results from Prometheus related to the namespaces and store them in the namespaces data structure.
+func (c *Collector) getNamespaceMetricString(result model.Value) {
+	//Validate there is data in the results.
+	if result == nil {
+		return
+	}
+	for i := 0; i < result.(model.Matrix).Len(); i++ {
+		namespaceValue, ok := result.(model.Matrix)[i].Metric["namespace"]
+		if !ok {
+			continue
+		}
+		if _, ok := c.namespaces[string(namespaceValue)]; !ok {
+			continue
+		}
+		for key, value := range result.(model.Matrix)[i].Metric {
+			common.AddToLabelMap(string(key), string(value), c.namespaces[string(namespaceValue)].Labels)
+		}
+	}
+}
+
+//getNamespaceMetric stores the last value of each of the series of the result in the namespace with the setter for the series.
+func (c *Collector) getNamespaceMetric(result model.Value, set func(ns *entity.NamespaceSettings, metric model.Metric, value float64)) {
+	//Validate there is data in the results.
+	if result == nil {
+		return
+	}
+	for i := 0; i < result.(model.Matrix).Len(); i++ {
+		namespaceValue, ok := result.(model.Matrix)[i].Metric["namespace"]
+		if !ok {
+			continue
+		}
+		ns, ok := c.namespaces[string(namespaceValue)]
+		if !ok || len(result.(model.Matrix)[i].Values) == 0 {
+			continue
+		}
+		set(ns, result.(model.Matrix)[i].Metric, float64(result.(model.Matrix)[i].Values[len(result.(model.Matrix)[i].Values)-1].Value))
+	}
+}
+
+//setLimitRange stores a constraint of the LimitRanges for the containers of the namespace.
+func setLimitRange(ns *entity.NamespaceSettings, metric model.Metric, value float64) {
+	var cpu, mem *int
+	switch metric["constraint"] {
+	case "min":
+		cpu, mem = &ns.LimitRangeCPUMin, &ns.LimitRangeMemMin
+	case "max":
+		cpu, mem = &ns.LimitRangeCPUMax, &ns.LimitRangeMemMax
+	case "default":
+		cpu, mem = &ns.LimitRangeCPUDefault, &ns.LimitRangeMemDefault
+	case "defaultRequest":
+		cpu, mem = &ns.LimitRangeCPUDefaultRequest, &ns.LimitRangeMemDefaultRequest
+	default:
+		return
+	}
+	switch metric["resource"] {
+	case "cpu":
+		*cpu = int(value * 1000)
+	case "memory":
+		*mem = int(value / 1024 / 1024)
+	}
+}
+
+//setQuota stores a hard limit of the ResourceQuotas of the namespace. The quotas of cpu and memory are those of their requests.
+//A quota can be set under both names of a resource, such as requests.cpu and cpu, in which case the lowest applies.
+func setQuota(ns *entity.NamespaceSettings, metric model.Metric, value float64) {
+	switch metric["resource"] {
+	case "requests.cpu", "cpu":
+		setLowest(&ns.QuotaCPURequest, int(value*1000))
+	case "limits.cpu":
+		setLowest(&ns.QuotaCPULimit, int(value*1000))
+	case "requests.memory", "memory":
+		setLowest(&ns.QuotaMemRequest, int(value/1024/1024))
+	case "limits.memory":
+		setLowest(&ns.QuotaMemLimit, int(value/1024/1024))
+	case "pods", "count/pods":
+		setLowest(&ns.QuotaPods, int(value))
+	}
+}
+
+//setLowest stores the value in the field when the field isn't set yet (-1) or holds a higher value.
+func setLowest(field *int, value int) {
+	if *field == -1 || value < *field {
+		*field = value
+	}
+}
+
+//Write creates the config, attributes and workload files for the namespaces and workloads found by the Collector.
+func Write(args *common.Parameters, namespaces map[string]*entity.NamespaceSettings, workloads []*common.Workload) {
+	writeAttributes(args, namespaces)
+	writeConfig(args, namespaces)
+	common.WriteWorkloads(args, workloads)
+}
+
+//writeConfig will create the config.csv file that is will be sent Densify by the Forwarder.
+func writeConfig(args *common.Parameters, namespaces map[string]*entity.NamespaceSettings) {
+
+	//Create the config file and open it for writing.
+	configWrite, err := common.CreateFile(args, entityKind, "config", "cluster,namespace")
+	if err != nil {
+		args.Logger.Error(err.Error(), "entity", entityKind)
+		return
+	}
+	for name := range namespaces {
+		fmt.Fprintf(configWrite, "%s,%s\n", *args.ClusterName, name)
+	}
+	configWrite.Close()
+}
+
+//writeAttributes will create the attributes.csv file that is will be sent Densify by the Forwarder.
+func writeAttributes(args *common.Parameters, namespaces map[string]*entity.NamespaceSettings) {
+
+	//Create the attributes file and open it for writing
+	attributeWrite, err := common.CreateFile(args, entityKind, "attributes", args.LabelColumns.Header("cluster,namespace,Virtual Technology,Virtual Domain,Existing CPU Limit,Existing CPU Request,Existing Memory Limit,Existing Memory Request,LimitRange CPU Min,LimitRange CPU Max,LimitRange CPU Default,LimitRange CPU Default Request,LimitRange Memory Min,LimitRange Memory Max,LimitRange Memory Default,LimitRange Memory Default Request,Quota CPU Request,Quota CPU Limit,Quota Memory Request,Quota Memory Limit,Quota Pods,Namespace Labels"))
+	if err != nil {
+		args.Logger.Error(err.Error(), "entity", entityKind)
+		return
+	}
+
+	for name, ns := range namespaces {
+		fmt.Fprintf(attributeWrite, "%s,%s,Namespaces,%s", *args.ClusterName, name, *args.ClusterName)
+
+		//Write out the numeric fields, leaving them blank if they weren't set rather than writing -1.
+		for _, value := range []int{ns.CPULimit, ns.CPURequest, ns.MemLimit, ns.MemRequest,
+			ns.LimitRangeCPUMin, ns.LimitRangeCPUMax, ns.LimitRangeCPUDefault, ns.LimitRangeCPUDefaultRequest,
+			ns.LimitRangeMemMin, ns.LimitRangeMemMax, ns.LimitRangeMemDefault, ns.LimitRangeMemDefaultRequest,
+			ns.QuotaCPURequest, ns.QuotaCPULimit, ns.QuotaMemRequest, ns.QuotaMemLimit, ns.QuotaPods} {
+			if value == -1 {
+				fmt.Fprintf(attributeWrite, ",")
+			} else {
+				fmt.Fprintf(attributeWrite, ",%d", value)
+			}
+		}
+		fmt.Fprintf(attributeWrite, ",")
+
+		for key, value := range ns.Labels {
+			if len(key) >= 250 || !args.LabelFilter.Keep("namespace", key) {
+				continue
+			}
+			value = strings.Replace(value, ",", " ", -1)
+			if len(value)+3+len(key) < 256 {
+				fmt.Fprintf(attributeWrite, key+" : "+value+"|")
+			} else {
+				templength := 256 - 3 - len(key)
+				fmt.Fprintf(attributeWrite, key+" : "+value[:templength]+"|")
+			}
+		}
+		fmt.Fprintf(attributeWrite, "%s\n", args.LabelColumns.Row(map[string]map[string]string{"namespace": ns.Labels}))
+	}
+
+	attributeWrite.Close()
+}
+
+//exclude removes the namespaces that have the exclude annotation and returns the matcher leaving them out of the queries that follow.
+func (c *Collector) exclude(result model.Value) string {
+	if result == nil || c.args.ExcludeAnnotation.Empty() {
+		return ""
+	}
+	var excluded []string
+	for i := 0; i < result.(model.Matrix).Len(); i++ {
+		metric := result.(model.Matrix)[i].Metric
+		annotations := map[string]string{}
+		for key, value := range metric {
+			annotations[string(key)] = string(value)
+		}
+		name := string(metric["namespace"])
+		if _, ok := c.namespaces[name]; !ok || !c.args.ExcludeAnnotation.Matches(annotations) {
+			continue
+		}
+		delete(c.namespaces, name)
+		excluded = append(excluded, name)
+		c.args.Logger.Info("Excluded the namespace opted out with the exclude annotation", "entity", entityKind, "annotation", c.args.ExcludeAnnotation.String(), "namespace", name)
+	}
+	matcher, _ := common.NamespaceMatcher(nil, excluded)
+	return matcher
+}
+
+//Collect gathers the namespaces and their workloads, unless they are skipped, and returns them to be written by Write. It returns nil if the namespaces couldn't be collected.
+func (c *Collector) Collect() (map[string]*entity.NamespaceSettings, []*common.Workload) {
+	args := c.args.PlanFor(entityKind, entityKind+"/config.csv,"+entityKind+"/attributes.csv")
+	//Setup variables used in the code.
+	var historyInterval time.Duration
+	historyInterval = 0
+	var query string
+	var result model.Value
+
+	//Start and end time + the prometheus address used for querying
+	range5Min := common.TimeRange(args, historyInterval)
+
+	//Query and store the namespaces and their labels
+	query = `kube_namespace_labels`
+	result = common.MetricCollect(args, query, range5Min, "namespaces", true)
+	if result == nil {
+		return nil, nil
+	}
+	for i := 0; i < result.(model.Matrix).Len(); i++ {
+		name, ok := result.(model.Matrix)[i].Metric["namespace"]
+		if !ok {
+			continue
+		}
+		c.namespaces[string(name)] = &entity.NamespaceSettings{
+			Name:     string(name),
+			Labels:   map[string]string{},
+			CPULimit: -1, CPURequest: -1, MemLimit: -1, MemRequest: -1,
+			LimitRangeCPUMin: -1, LimitRangeCPUMax: -1, LimitRangeCPUDefault: -1, LimitRangeCPUDefaultRequest: -1,
+			LimitRangeMemMin: -1, LimitRangeMemMax: -1, LimitRangeMemDefault: -1, LimitRangeMemDefaultRequest: -1,
+			QuotaCPURequest: -1, QuotaCPULimit: -1, QuotaMemRequest: -1, QuotaMemLimit: -1, QuotaPods: -1}
+	}
+	c.getNamespaceMetricString(result)
+
+	query = `kube_namespace_annotations`
+	result = common.MetricCollect(args, query, range5Min, "namespaceAnnotations", false)
+	if result != nil {
+		c.getNamespaceMetricString(result)
+		args = args.WithMatcher(c.exclude(result))
+	}
+
+	//The constraints of the LimitRanges, where a namespace has several of them the highest value of each constraint is used.
+	query = `max(kube_limitrange{type="Container"}) by (namespace,resource,constraint)`
+	result = common.MetricCollect(args, query, range5Min, "limitRange", false)
+	c.getNamespaceMetric(result, setLimitRange)
+
+	//The hard limits of the ResourceQuotas, where a namespace has several of them the lowest limit applies.
+	query = `min(kube_resourcequota{type="hard"}) by (namespace,resource)`
+	result = common.MetricCollect(args, query, range5Min, "resourceQuota", false)
+	c.getNamespaceMetric(result, setQuota)
+
+	query = `sum(kube_pod_container_resource_limits_cpu_cores*1000 * on (namespace,pod,container) group_left kube_pod_container_status_running) by (namespace)`
+	result = common.MetricCollect(args, query, range5Min, "cpuLimit", false)
+	c.getNamespaceMetric(result, func(ns *entity.NamespaceSettings, _ model.Metric, value float64) { ns.CPULimit = int(value) })
+
+	query = `sum(kube_pod_container_resource_requests_cpu_cores*1000 * on (namespace,pod,container) group_left kube_pod_container_status_running) by (namespace)`
+	result = common.MetricCollect(args, query, range5Min, "cpuRequest", false)
+	c.getNamespaceMetric(result, func(ns *entity.NamespaceSettings, _ model.Metric, value float64) { ns.CPURequest = int(value) })
+
+	query = `sum(kube_pod_container_resource_limits_memory_bytes/1024/1024 * on (namespace,pod,container) group_left kube_pod_container_status_running) by (namespace)`
+	result = common.MetricCollect(args, query, range5Min, "memLimit", false)
+	c.getNamespaceMetric(result, func(ns *entity.NamespaceSettings, _ model.Metric, value float64) { ns.MemLimit = int(value) })
+
+	query = `sum(kube_pod_container_resource_requests_memory_bytes/1024/1024 * on (namespace,pod,container) group_left kube_pod_container_status_running) by (namespace)`
+	result = common.MetricCollect(args, query, range5Min, "memRequest", false)
+	c.getNamespaceMetric(result, func(ns *entity.NamespaceSettings, _ model.Metric, value float64) { ns.MemRequest = int(value) })
+
+	if args.SkipWorkloads {
+		return c.namespaces, c.workloads
+	}
+
+	//Query and store prometheus CPU usage
+	query = `round(sum(irate(container_cpu_usage_seconds_total` + containerSelector + `[` + args.RateWindow + `])) by (namespace)*1000,1)`
+	c.workloads = append(c.workloads, common.GetWorkload("cpu_utilization", "CPU Utilization in mCores", query, "namespace", args, entityKind))
+
+	//Query and store prometheus memory usage
+	query = `sum(container_memory_usage_bytes` + containerSelector + `) by (namespace)`
+	c.workloads = append(c.workloads, common.GetWorkload("memory_raw_bytes", "Raw Mem Utilization", query, "namespace", args, entityKind))
+
+	//Query and store prometheus memory rss
+	query = `sum(container_memory_rss` + containerSelector + `) by (namespace)`
+	c.workloads = append(c.workloads, common.GetWorkload("memory_actual_workload", "Actual Memory Utilization", query, "namespace", args, entityKind))
+
+	//Query and store prometheus CPU requests
+	query = `sum((kube_pod_container_resource_requests_cpu_cores) * on (namespace,pod,container) group_left kube_pod_container_status_running) by (namespace)`
+	c.workloads = append(c.workloads, common.GetWorkload("cpu_requests", "CPU Reservation in Cores", query, "namespace", args, entityKind))
+
+	//Query and store prometheus CPU limits
+	query = `sum((kube_pod_container_resource_limits_cpu_cores) * on (namespace,pod,container) group_left kube_pod_container_status_running) by (namespace)`
+	c.workloads = append(c.workloads, common.GetWorkload("cpu_limits", "CPU Limit in Cores", query, "namespace", args, entityKind))
+
+	//Query and store prometheus Memory requests
+	query = `sum((kube_pod_container_resource_requests_memory_bytes/1024/1024) * on (namespace,pod,container) group_left kube_pod_container_status_running) by (namespace)`
+	c.workloads = append(c.workloads, common.GetWorkload("memory_requests", "Memory Reservation in MB", query, "namespace", args, entityKind))
+
+	//Query and store prometheus Memory limits
+	query = `sum((kube_pod_container_resource_limits_memory_bytes/1024/1024) * on (namespace,pod,container) group_left kube_pod_container_status_running) by (namespace)`
+	c.workloads = append(c.workloads, common.GetWorkload("memory_limits", "Memory Limit in MB", query, "namespace", args, entityKind))
+
+	//Query and store the utilization of the quotas, the highest of the ResourceQuotas of the namespace.
+	query = `max(kube_resourcequota{type="used",resource=~"requests.cpu|cpu"} / ignoring (type) kube_resourcequota{type="hard",resource=~"requests.cpu|cpu"}) by (namespace) * 100`
+	c.workloads = append(c.workloads, common.GetWorkload("cpu_request_quota_percent", "CPU Request Quota Utilization Percent", query, "namespace", args, entityKind))
+
+	query = `max(kube_resourcequota{type="used",resource="limits.cpu"} / ignoring (type) kube_resourcequota{type="hard",resource="limits.cpu"}) by (namespace) * 100`
+	c.workloads = append(c.workloads, common.GetWorkload("cpu_limit_quota_percent", "CPU Limit Quota Utilization Percent", query, "namespace", args, entityKind))
+
+	query = `max(kube_resourcequota{type="used",resource=~"requests.memory|memory"} / ignoring (type) kube_resourcequota{type="hard",resource=~"requests.memory|memory"}) by (namespace) * 100`
+	c.workloads = append(c.workloads, common.GetWorkload("memory_request_quota_percent", "Memory Request Quota Utilization Percent", query, "namespace", args, entityKind))
+
+	query = `max(kube_resourcequota{type="used",resource="limits.memory"} / ignoring (type) kube_resourcequota{type="hard",resource="limits.memory"}) by (namespace) * 100`
+	c.workloads = append(c.workloads, common.GetWorkload("memory_limit_quota_percent", "Memory Limit Quota Utilization Percent", query, "namespace", args, entityKind))
+
+	return c.namespaces, c.workloads
+}
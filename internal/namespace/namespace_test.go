@@ -0,0 +1,50 @@
+package namespace
+
+import (
+	"testing"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/testutil"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/entity"
+	"github.com/prometheus/common/model"
+)
+
+func TestSetQuota(t *testing.T) {
+	//Each namespace sets the cpu and memory quotas under both names, in either order, so the lowest has to win whichever series comes last.
+	tests := []struct {
+		name     string
+		series   model.Matrix
+		cpu, mem int
+	}{
+		{"short name lower", model.Matrix{
+			testutil.Series([]string{"namespace", "ns1", "resource", "requests.cpu"}, 4),
+			testutil.Series([]string{"namespace", "ns1", "resource", "cpu"}, 2),
+			testutil.Series([]string{"namespace", "ns1", "resource", "requests.memory"}, 8*1024*1024*1024),
+			testutil.Series([]string{"namespace", "ns1", "resource", "memory"}, 4*1024*1024*1024),
+		}, 2000, 4096},
+		{"requests lower", model.Matrix{
+			testutil.Series([]string{"namespace", "ns1", "resource", "requests.cpu"}, 2),
+			testutil.Series([]string{"namespace", "ns1", "resource", "cpu"}, 4),
+			testutil.Series([]string{"namespace", "ns1", "resource", "requests.memory"}, 4*1024*1024*1024),
+			testutil.Series([]string{"namespace", "ns1", "resource", "memory"}, 8*1024*1024*1024),
+		}, 2000, 4096},
+		{"zero quota", model.Matrix{
+			testutil.Series([]string{"namespace", "ns1", "resource", "cpu"}, 0),
+			testutil.Series([]string{"namespace", "ns1", "resource", "requests.cpu"}, 2),
+		}, 0, -1},
+	}
+	for _, test := range tests {
+		ns := &entity.NamespaceSettings{Name: "ns1", QuotaCPURequest: -1, QuotaCPULimit: -1, QuotaMemRequest: -1, QuotaMemLimit: -1, QuotaPods: -1}
+		c := &Collector{namespaces: map[string]*entity.NamespaceSettings{"ns1": ns}}
+		c.getNamespaceMetric(append(test.series,
+			testutil.Series([]string{"namespace", "ns1", "resource", "limits.cpu"}, 8),
+			testutil.Series([]string{"namespace", "ns1", "resource", "pods"}, 20),
+			testutil.Series([]string{"namespace", "ns2", "resource", "cpu"}, 1)), setQuota)
+
+		if ns.QuotaCPURequest != test.cpu || ns.QuotaMemRequest != test.mem {
+			t.Errorf("%s: cpu request quota %d and memory request quota %d, want %d and %d", test.name, ns.QuotaCPURequest, ns.QuotaMemRequest, test.cpu, test.mem)
+		}
+		if ns.QuotaCPULimit != 8000 || ns.QuotaMemLimit != -1 || ns.QuotaPods != 20 {
+			t.Errorf("%s: cpu limit quota %d, memory limit quota %d and pods quota %d, want 8000, -1 and 20", test.name, ns.QuotaCPULimit, ns.QuotaMemLimit, ns.QuotaPods)
+		}
+	}
+}
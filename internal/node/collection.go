@@ -12,7 +12,7 @@ import (
 )
 
 //Gets node metrics from prometheus (and checks to see if they are valid)
-func getNodeMetric(result model.Value, node model.LabelName, metric string) {
+func (c *Collector) getNodeMetric(result model.Value, node model.LabelName, metric string) {
 
 	if result == nil {
 		return
@@ -23,7 +23,7 @@ func getNodeMetric(result model.Value, node model.LabelName, metric string) {
 		if !ok {
 			continue
 		}
-		if _, ok := nodes[string(nodeValue)]; !ok {
+		if _, ok := c.nodes[string(nodeValue)]; !ok {
 			continue
 		}
 		//validates that the value of the entity is set and if not will default to 0
@@ -37,62 +37,62 @@ func getNodeMetric(result model.Value, node model.LabelName, metric string) {
 			capacityType := result.(model.Matrix)[i].Metric["resource"]
 			switch capacityType {
 			case "cpu":
-				nodes[string(nodeValue)].cpuCapacity = int(value)
+				c.nodes[string(nodeValue)].CPUCapacity = int(value)
 			case "memory":
-				nodes[string(nodeValue)].memCapacity = int(value)
+				c.nodes[string(nodeValue)].MemCapacity = int(value)
 			case "pods":
-				nodes[string(nodeValue)].podsCapacity = int(value)
+				c.nodes[string(nodeValue)].PodsCapacity = int(value)
 			case "ephemeral_storage":
-				nodes[string(nodeValue)].ephemeralStorageCapacity = int(value)
+				c.nodes[string(nodeValue)].EphemeralStorageCapacity = int(value)
 			case "hugepages_2Mi":
-				nodes[string(nodeValue)].hugepages2MiCapacity = int(value)
+				c.nodes[string(nodeValue)].HugePages2MiCapacity = int(value)
 			}
 		} else if metric == "allocatable" {
 			capacityType := result.(model.Matrix)[i].Metric["resource"]
 			switch capacityType {
 			case "cpu":
-				nodes[string(nodeValue)].cpuAllocatable = int(value)
+				c.nodes[string(nodeValue)].CPUAllocatable = int(value)
 			case "memory":
-				nodes[string(nodeValue)].memAllocatable = int(value)
+				c.nodes[string(nodeValue)].MemAllocatable = int(value)
 			case "pods":
-				nodes[string(nodeValue)].podsAllocatable = int(value)
+				c.nodes[string(nodeValue)].PodsAllocatable = int(value)
 			case "ephemeral_storage":
-				nodes[string(nodeValue)].ephemeralStorageAllocatable = int(value)
+				c.nodes[string(nodeValue)].EphemeralStorageAllocatable = int(value)
 			case "hugepages_2Mi":
-				nodes[string(nodeValue)].hugepages2MiAllocatable = int(value)
+				c.nodes[string(nodeValue)].HugePages2MiAllocatable = int(value)
 			}
 		} else {
 
 			switch metric {
 			case "capacity_cpu":
-				nodes[string(nodeValue)].cpuCapacity = int(value)
+				c.nodes[string(nodeValue)].CPUCapacity = int(value)
 			case "capacity_mem":
-				nodes[string(nodeValue)].memCapacity = int(value)
+				c.nodes[string(nodeValue)].MemCapacity = int(value)
 			case "capacity_pod":
-				nodes[string(nodeValue)].podsCapacity = int(value)
+				c.nodes[string(nodeValue)].PodsCapacity = int(value)
 			case "allocatable_cpu":
-				nodes[string(nodeValue)].cpuAllocatable = int(value)
+				c.nodes[string(nodeValue)].CPUAllocatable = int(value)
 			case "allocatable_mem":
-				nodes[string(nodeValue)].memAllocatable = int(value)
+				c.nodes[string(nodeValue)].MemAllocatable = int(value)
 			case "allocatable_pod":
-				nodes[string(nodeValue)].podsAllocatable = int(value)
+				c.nodes[string(nodeValue)].PodsAllocatable = int(value)
 			case "netSpeedBytes":
-				nodes[string(nodeValue)].netSpeedBytes = int(value)
+				c.nodes[string(nodeValue)].NetSpeedBytes = int(value)
 			case "cpuLimit":
-				nodes[string(nodeValue)].cpuLimit = int(value)
+				c.nodes[string(nodeValue)].CPULimit = int(value)
 			case "cpuRequest":
-				nodes[string(nodeValue)].cpuRequest = int(value)
+				c.nodes[string(nodeValue)].CPURequest = int(value)
 			case "memLimit":
-				nodes[string(nodeValue)].memLimit = int(value)
+				c.nodes[string(nodeValue)].MemLimit = int(value)
 			case "memRequest":
-				nodes[string(nodeValue)].memRequest = int(value)
+				c.nodes[string(nodeValue)].MemRequest = int(value)
 			}
 		}
 	}
 }
 
 //getNodeMetricString is used to parse the label based results from Prometheus related to Container Entities and store them in the systems data structure.
-func getNodeMetricString(result model.Value, node model.LabelName) {
+func (c *Collector) getNodeMetricString(result model.Value, node model.LabelName) {
 	//Validate there is data in the results.
 	if result == nil {
 		return
@@ -103,11 +103,11 @@ func getNodeMetricString(result model.Value, node model.LabelName) {
 		if !ok {
 			continue
 		}
-		if _, ok := nodes[string(nodeValue)]; !ok {
+		if _, ok := c.nodes[string(nodeValue)]; !ok {
 			continue
 		}
 		for key, value := range result.(model.Matrix)[i].Metric {
-			common.AddToLabelMap(string(key), string(value), nodes[string(nodeValue)].labelMap)
+			common.AddToLabelMap(string(key), string(value), c.nodes[string(nodeValue)].Labels)
 		}
 	}
 }
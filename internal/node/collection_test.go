@@ -0,0 +1,58 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/testutil"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/entity"
+	"github.com/prometheus/common/model"
+)
+
+//newNodeCollector returns a collector holding the node n1 with none of its capacities found.
+func newNodeCollector() *Collector {
+	c := NewCollector(&common.Parameters{})
+	c.nodes["n1"] = &entity.Node{Name: "n1", Labels: map[string]string{}, CPUCapacity: -1, MemCapacity: -1, PodsCapacity: -1}
+	return c
+}
+
+func TestGetNodeMetric(t *testing.T) {
+	c := newNodeCollector()
+	c.getNodeMetric(model.Matrix{
+		testutil.Series([]string{"node", "n1", "resource", "cpu"}, 2, 4),
+		testutil.Series([]string{"node", "n1", "resource", "memory"}, 8192),
+		testutil.Series([]string{"node", "n1", "resource", "gpu"}, 1),
+		testutil.Series([]string{"node", "n9", "resource", "cpu"}, 16),
+	}, "node", "capacity")
+	c.getNodeMetric(model.Matrix{testutil.Series([]string{"node", "n1", "resource", "pods"}, 110)}, "node", "allocatable")
+	c.getNodeMetric(model.Matrix{testutil.Series([]string{"instance", "n1"}, 1250000)}, "instance", "netSpeedBytes")
+	c.getNodeMetric(model.Matrix{testutil.Series([]string{"node", "n1"})}, "node", "cpuRequest")
+	c.getNodeMetric(nil, "node", "cpuLimit")
+
+	n := c.nodes["n1"]
+	if n.CPUCapacity != 4 || n.MemCapacity != 8192 || n.PodsAllocatable != 110 || n.NetSpeedBytes != 1250000 {
+		t.Errorf("node = cpu %d, memory %d, pods allocatable %d, net speed %d, want 4, 8192, 110 and 1250000", n.CPUCapacity, n.MemCapacity, n.PodsAllocatable, n.NetSpeedBytes)
+	}
+	if n.PodsCapacity != -1 {
+		t.Errorf("pods capacity = %d, want it unset", n.PodsCapacity)
+	}
+	if n.CPURequest != 0 {
+		t.Errorf("cpu request = %d, want 0 for a series without samples", n.CPURequest)
+	}
+	if len(c.nodes) != 1 {
+		t.Errorf("nodes = %v, want only n1", c.nodes)
+	}
+}
+
+func TestGetNodeMetricString(t *testing.T) {
+	c := newNodeCollector()
+	c.getNodeMetricString(model.Matrix{
+		testutil.Series([]string{"node", "n1", "label_kubernetes_io_os", "linux"}),
+		testutil.Series([]string{"node", "n1", "label_kubernetes_io_os", "linux", "kernel_version", "5.15"}),
+		testutil.Series([]string{"node", "n9", "label_kubernetes_io_os", "windows"}),
+	}, "node")
+	labels := c.nodes["n1"].Labels
+	if labels["label_kubernetes_io_os"] != "linux" || labels["kernel_version"] != "5.15" {
+		t.Errorf("labels = %v, want the os once and the kernel version", labels)
+	}
+}
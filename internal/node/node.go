@@ -2,33 +2,31 @@
 package node
 
 import (
-	"fmt"
 	"time"
 
 	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/entity"
 	"github.com/prometheus/common/model"
 )
 
-//A node structure. Used for storing attributes and config details.
-type node struct {
-
-	//Labels & general information about each node
-	labelMap map[string]string
+//Hard-coded string for log file warnings
+var entityKind = "node"
 
-	//Value fields
-	netSpeedBytes, cpuCapacity, memCapacity, ephemeralStorageCapacity, podsCapacity, hugepages2MiCapacity int
-	cpuAllocatable, memAllocatable, ephemeralStorageAllocatable, podsAllocatable, hugepages2MiAllocatable int
-	cpuLimit, cpuRequest, memLimit, memRequest                                                            int
+//Collector collects the node data for a single run. Create a new one with NewCollector for every run as it holds the nodes found.
+type Collector struct {
+	args      *common.Parameters
+	nodes     map[string]*entity.Node
+	workloads []*common.Workload
 }
 
-//Map that labels and values will be stored in
-var nodes = map[string]*node{}
-
-//Hard-coded string for log file warnings
-var entityKind = "node"
+//NewCollector returns a Collector that queries Prometheus using the parameters provided.
+func NewCollector(args *common.Parameters) *Collector {
+	return &Collector{args: args, nodes: map[string]*entity.Node{}}
+}
 
-//Metrics a global func for collecting node level metrics in prometheus
-func Metrics(args *common.Parameters) {
+//Collect gathers the nodes and their workloads, unless they are skipped, and returns them to be written by Write. It returns nil if the nodes couldn't be collected.
+func (c *Collector) Collect() (map[string]*entity.Node, []*common.Workload) {
+	args := c.args.PlanFor(entityKind, entityKind+"/config.csv,"+entityKind+"/attributes.csv")
 	//Setup variables used in the code.
 	var historyInterval time.Duration
 	historyInterval = 0
@@ -43,40 +41,42 @@ func Metrics(args *common.Parameters) {
 	query = "max(kube_node_labels) by (instance, node)"
 	result = common.MetricCollect(args, query, range5Min, "nodes", true)
 	if result == nil {
-		return
+		return nil, nil
 	}
 	var rsltIndex = result.(model.Matrix)
 	for i := 0; i < rsltIndex.Len(); i++ {
-		nodes[string(rsltIndex[i].Metric["node"])] =
-			&node{
-				labelMap: map[string]string{},
+		c.nodes[string(rsltIndex[i].Metric["node"])] =
+			&entity.Node{
+				Name:   string(rsltIndex[i].Metric["node"]),
+				Labels: map[string]string{},
 
 				//Network speed attribute (set to -1 by default to make error checking more easy)
-				netSpeedBytes: -1,
+				NetSpeedBytes: -1,
 
 				//Capacity and allocatable fields (set to -1 by default to make error checking more easy)
-				cpuCapacity: -1, memCapacity: -1, ephemeralStorageCapacity: -1, podsCapacity: -1, hugepages2MiCapacity: -1,
-				cpuAllocatable: -1, memAllocatable: -1, ephemeralStorageAllocatable: -1, podsAllocatable: -1, hugepages2MiAllocatable: -1,
+				CPUCapacity: -1, MemCapacity: -1, EphemeralStorageCapacity: -1, PodsCapacity: -1, HugePages2MiCapacity: -1,
+				CPUAllocatable: -1, MemAllocatable: -1, EphemeralStorageAllocatable: -1, PodsAllocatable: -1, HugePages2MiAllocatable: -1,
 
-				cpuLimit: -1, cpuRequest: -1, memLimit: -1, memRequest: -1}
+				CPULimit: -1, CPURequest: -1, MemLimit: -1, MemRequest: -1}
 	}
 
 	//Additonal config/attribute queries
 	query = `kube_node_labels`
 	result = common.MetricCollect(args, query, range5Min, "nodeLabels", false)
-	getNodeMetricString(result, "node")
+	c.getNodeMetricString(result, "node")
 
 	//Additonal config/attribute queries
 	query = `kube_node_info`
 	result = common.MetricCollect(args, query, range5Min, "nodeInfo", false)
-	getNodeMetricString(result, "node")
+	c.getNodeMetricString(result, "node")
 
 	//Gets the network speed in bytes as an attribute/config value for each node
 	query = `label_replace(node_network_speed_bytes, "pod_ip", "$1", "instance", "(.*):.*")`
-	result = common.MetricCollect(args, query, range5Min, "networkSpeedBytes", false)
-	getNodeMetric(result, "node", "netSpeedBytes")
+	result, detected := common.DetectCollect(args, query, range5Min, "networkSpeedBytes")
+	c.getNodeMetric(result, "node", "netSpeedBytes")
 
-	if result.(model.Matrix).Len() == 0 {
+	//A dry run without schema detection plans the queries for Node Exporter being installed.
+	if rslt, ok := result.(model.Matrix); detected && (!ok || rslt.Len() == 0) {
 		haveNodeExport = false
 	}
 
@@ -93,31 +93,31 @@ func Metrics(args *common.Parameters) {
 	  individual queries. If you see missing fields in the config/attribute files,
 	  that is why.
 	*/
-	if result.(model.Matrix).Len() == 0 {
+	if rslt, ok := result.(model.Matrix); !ok || rslt.Len() == 0 {
 		//capacity_cpu_cores query
 		query = `kube_node_status_capacity_cpu_cores`
 		result = common.MetricCollect(args, query, range5Min, "statusCapacityCpuCores", false)
 		if result != nil {
-			getNodeMetric(result, "node", "capacity_cpu")
+			c.getNodeMetric(result, "node", "capacity_cpu")
 		}
 
 		//capacity_memory_bytes query
 		query = `kube_node_status_capacity_memory_bytes`
 		result = common.MetricCollect(args, query, range5Min, "statusCapacityMemoryBytes", false)
 		if result != nil {
-			getNodeMetric(result, "node", "capacity_mem")
+			c.getNodeMetric(result, "node", "capacity_mem")
 		}
 
 		//capacity_pods query
 		query = `kube_node_status_capacity_pods`
 		result = common.MetricCollect(args, query, range5Min, "statusCapacityPods", false)
 		if result != nil {
-			getNodeMetric(result, "node", "capacity_pod")
+			c.getNodeMetric(result, "node", "capacity_pod")
 		}
 
 	} else {
 		if result != nil {
-			getNodeMetric(result, "node", "capacity")
+			c.getNodeMetric(result, "node", "capacity")
 		}
 	}
 
@@ -134,64 +134,63 @@ func Metrics(args *common.Parameters) {
 	  individual queries. If you see missing fields in the config/attribute files,
 	  that is why.
 	*/
-	if result.(model.Matrix).Len() == 0 {
+	if rslt, ok := result.(model.Matrix); !ok || rslt.Len() == 0 {
 		query = `kube_node_status_allocatable_cpu_cores`
 		result = common.MetricCollect(args, query, range5Min, "statusAllocatableCpuCores", false)
 		if result != nil {
-			getNodeMetric(result, "node", "allocatable_cpu")
+			c.getNodeMetric(result, "node", "allocatable_cpu")
 		}
 
 		query = `kube_node_status_allocatable_memory_bytes`
 		result = common.MetricCollect(args, query, range5Min, "statusAllocatableMemoryBytes", false)
 		if result != nil {
-			getNodeMetric(result, "node", "allocatable_mem")
+			c.getNodeMetric(result, "node", "allocatable_mem")
 		}
 
 		query = `kube_node_status_allocatable_pods`
 		result = common.MetricCollect(args, query, range5Min, "statusAllocatablePods", false)
 		if result != nil {
-			getNodeMetric(result, "node", "allocatable_pod")
+			c.getNodeMetric(result, "node", "allocatable_pod")
 		}
 
 	} else {
 		if result != nil {
-			getNodeMetric(result, "node", "allocatable")
+			c.getNodeMetric(result, "node", "allocatable")
 		}
 	}
 
-	query = `sum(kube_pod_container_resource_limits_cpu_cores * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)*1000`
+	query = args.PodQuery(`sum(kube_pod_container_resource_limits_cpu_cores * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)*1000`)
 	result = common.MetricCollect(args, query, range5Min, "cpuLimit", false)
 	if result != nil {
-		getNodeMetric(result, "node", "cpuLimit")
+		c.getNodeMetric(result, "node", "cpuLimit")
 	}
 
-	query = `sum(kube_pod_container_resource_requests_cpu_cores * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)*1000`
+	query = args.PodQuery(`sum(kube_pod_container_resource_requests_cpu_cores * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)*1000`)
 	result = common.MetricCollect(args, query, range5Min, "cpuRequest", false)
 	if result != nil {
-		getNodeMetric(result, "node", "cpuRequest")
+		c.getNodeMetric(result, "node", "cpuRequest")
 	}
 
-	query = `sum(kube_pod_container_resource_limits_memory_bytes * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)/1024/1024`
+	query = args.PodQuery(`sum(kube_pod_container_resource_limits_memory_bytes * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)/1024/1024`)
 	result = common.MetricCollect(args, query, range5Min, "memLimit", false)
 	if result != nil {
-		getNodeMetric(result, "node", "memLimit")
+		c.getNodeMetric(result, "node", "memLimit")
 	}
 
-	query = `sum(kube_pod_container_resource_requests_memory_bytes * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)/1024/1024`
+	query = args.PodQuery(`sum(kube_pod_container_resource_requests_memory_bytes * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)/1024/1024`)
 	result = common.MetricCollect(args, query, range5Min, "memRequest", false)
 	if result != nil {
-		getNodeMetric(result, "node", "memRequest")
+		c.getNodeMetric(result, "node", "memRequest")
 	}
 
-	//Writes the config and attribute files
-	writeConfig(args)
-	writeAttributes(args)
+	if args.SkipWorkloads {
+		return c.nodes, c.workloads
+	}
 
 	//Checks to see if Node Exporter is installed. Based off if anything is returned from network speed bytes
 	if haveNodeExport == false {
-		args.ErrorLogger.Println("entity=" + entityKind + " message=It appears you do not have Node Exporter installed.")
-		fmt.Println("entity=" + entityKind + " message=It appears you do not have Node Exporter installed.")
-		return
+		args.Logger.Error("It appears you do not have Node Exporter installed.", "entity", entityKind)
+		return c.nodes, c.workloads
 	}
 
 	var metricfield model.LabelName
@@ -202,10 +201,10 @@ func Metrics(args *common.Parameters) {
 	metricfield = "instance"
 
 	//Check to see which disk queries to use if instance is IP address that need to link to pod to get name or if instance = node name.
-	query = `max(max(label_replace(sum(irate(node_cpu_seconds_total{mode!="idle"}[` + args.SampleRateString + `m])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100, "pod_ip", "$1", "instance", "(.*):.*")) by (pod_ip) * on (pod_ip) group_right kube_pod_info{pod=~".*node-exporter.*"}) by (node)`
-	result = common.MetricCollect(args, query, range5Min, "testNodeWorkload", false)
+	query = `max(max(label_replace(sum(irate(node_cpu_seconds_total{mode!="idle"}[` + args.RateWindow + `])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100, "pod_ip", "$1", "instance", "(.*):.*")) by (pod_ip) * on (pod_ip) group_right kube_pod_info{pod=~".*node-exporter.*"}) by (node)`
+	result, _ = common.DetectCollect(args, query, range5Min, "testNodeWorkload")
 
-	if result.(model.Matrix).Len() != 0 {
+	if rslt, ok := result.(model.Matrix); ok && rslt.Len() != 0 {
 		queryPrefix = `max(max(label_replace(`
 		queryPrefixSum = `max(sum(label_replace(`
 		querySuffix = `, "pod_ip", "$1", "instance", "(.*):.*")) by (pod_ip) * on (pod_ip) group_right kube_pod_info{pod=~".*node-exporter.*"}) by (node)`
@@ -213,65 +212,66 @@ func Metrics(args *common.Parameters) {
 		metricfield = "node"
 	}
 	//Query and store prometheus total cpu uptime in seconds
-	query = queryPrefix + `sum(irate(node_cpu_seconds_total{mode!="idle"}[` + args.SampleRateString + `m])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100` + querySuffix
-	common.GetWorkload("cpu_utilization", "CPU Utilization", query, metricfield, args, entityKind)
+	query = queryPrefix + `sum(irate(node_cpu_seconds_total{mode!="idle"}[` + args.RateWindow + `])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100` + querySuffix
+	c.workloads = append(c.workloads, common.GetWorkload("cpu_utilization", "CPU Utilization", query, metricfield, args, entityKind))
 
 	//Query and store prometheus node memory total in bytes
 	query = queryPrefix + `node_memory_MemTotal_bytes - node_memory_MemFree_bytes` + querySuffix
-	common.GetWorkload("memory_raw_bytes", "Raw Mem Utilization", query, metricfield, args, entityKind)
+	c.workloads = append(c.workloads, common.GetWorkload("memory_raw_bytes", "Raw Mem Utilization", query, metricfield, args, entityKind))
 
 	//Query and store prometheus node memory total free in bytes
 	query = queryPrefix + `node_memory_MemTotal_bytes - (node_memory_MemFree_bytes + node_memory_Cached_bytes + node_memory_Buffers_bytes)` + querySuffix
-	common.GetWorkload("memory_actual_workload", "Actual Memory Utilization", query, metricfield, args, entityKind)
+	c.workloads = append(c.workloads, common.GetWorkload("memory_actual_workload", "Actual Memory Utilization", query, metricfield, args, entityKind))
 
 	//Query and store prometheus node disk write in bytes
-	query = queryPrefixSum + `irate(node_disk_written_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_write_bytes", "Raw Disk Write Utilization", query, metricfield, args, entityKind)
+	query = queryPrefixSum + `irate(node_disk_written_bytes_total{device!~"dm-.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("disk_write_bytes", "Raw Disk Write Utilization", query, metricfield, args, entityKind))
 
 	//Query and store prometheus node disk read in bytes
-	query = queryPrefixSum + `irate(node_disk_read_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_read_bytes", "Raw Disk Read Utilization", query, metricfield, args, entityKind)
+	query = queryPrefixSum + `irate(node_disk_read_bytes_total{device!~"dm-.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("disk_read_bytes", "Raw Disk Read Utilization", query, metricfield, args, entityKind))
 
 	//Query and store prometheus total disk read uptime as a percentage
-	query = queryPrefixSum + `irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_read_ops", "Disk Read Operations", query, metricfield, args, entityKind)
+	query = queryPrefixSum + `irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[` + args.RateWindow + `]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("disk_read_ops", "Disk Read Operations", query, metricfield, args, entityKind))
 
 	//Query and store prometheus total disk write uptime as a percentage
-	query = queryPrefixSum + `irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_write_ops", "Disk Write Operations", query, metricfield, args, entityKind)
+	query = queryPrefixSum + `irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[` + args.RateWindow + `]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("disk_write_ops", "Disk Write Operations", query, metricfield, args, entityKind))
 
 	//Total disk values
 	//Query and store prometheus node disk read in bytes
-	query = queryPrefixSum + `irate(node_disk_read_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) + irate(node_disk_written_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_total_bytes", "Raw Disk Utilization", query, metricfield, args, entityKind)
+	query = queryPrefixSum + `irate(node_disk_read_bytes_total{device!~"dm-.*"}[` + args.RateWindow + `]) + irate(node_disk_written_bytes_total{device!~"dm-.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("disk_total_bytes", "Raw Disk Utilization", query, metricfield, args, entityKind))
 
 	//Query and store prometheus total disk read uptime as a percentage
-	query = queryPrefixSum + `(irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) + irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_total_ops", "Disk Operations", query, metricfield, args, entityKind)
+	query = queryPrefixSum + `(irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[` + args.RateWindow + `]) + irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[` + args.RateWindow + `])) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("disk_total_ops", "Disk Operations", query, metricfield, args, entityKind))
 
 	//Query and store prometheus node recieved network data in bytes
-	query = queryPrefixSum + `irate(node_network_receive_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_received_bytes", "Raw Net Received Utilization", query, metricfield, args, entityKind)
+	query = queryPrefixSum + `irate(node_network_receive_bytes_total{device!~"veth.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("net_received_bytes", "Raw Net Received Utilization", query, metricfield, args, entityKind))
 
 	//Query and store prometheus recieved network data in packets
-	query = queryPrefixSum + `irate(node_network_receive_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_received_packets", "Network Packets Received", query, metricfield, args, entityKind)
+	query = queryPrefixSum + `irate(node_network_receive_packets_total{device!~"veth.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("net_received_packets", "Network Packets Received", query, metricfield, args, entityKind))
 
 	//Query and store prometheus total transmitted network data in bytes
-	query = queryPrefixSum + `irate(node_network_transmit_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_sent_bytes", "Raw Net Sent Utilization", query, metricfield, args, entityKind)
+	query = queryPrefixSum + `irate(node_network_transmit_bytes_total{device!~"veth.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("net_sent_bytes", "Raw Net Sent Utilization", query, metricfield, args, entityKind))
 
 	//Query and store prometheus total transmitted network data in packets
-	query = queryPrefixSum + `irate(node_network_transmit_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_sent_packets", "Network Packets Sent", query, metricfield, args, entityKind)
+	query = queryPrefixSum + `irate(node_network_transmit_packets_total{device!~"veth.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("net_sent_packets", "Network Packets Sent", query, metricfield, args, entityKind))
 
 	//Total values network
 	//Query and store prometheus total network data in bytes
-	query = queryPrefixSum + `irate(node_network_transmit_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m]) + irate(node_network_receive_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_total_bytes", "Raw Net Utilization", query, metricfield, args, entityKind)
+	query = queryPrefixSum + `irate(node_network_transmit_bytes_total{device!~"veth.*"}[` + args.RateWindow + `]) + irate(node_network_receive_bytes_total{device!~"veth.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("net_total_bytes", "Raw Net Utilization", query, metricfield, args, entityKind))
 
 	//Query and store prometheus total network data in packets
-	query = queryPrefixSum + `irate(node_network_transmit_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m]) + irate(node_network_receive_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_total_packets", "Network Packets", query, metricfield, args, entityKind)
+	query = queryPrefixSum + `irate(node_network_transmit_packets_total{device!~"veth.*"}[` + args.RateWindow + `]) + irate(node_network_receive_packets_total{device!~"veth.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("net_total_packets", "Network Packets", query, metricfield, args, entityKind))
 
+	return c.nodes, c.workloads
 }
@@ -7,63 +7,67 @@ package node
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/entity"
 )
 
+//Write creates the config, attributes and workload files for the nodes and workloads found by the Collector.
+func Write(args *common.Parameters, nodes map[string]*entity.Node, workloads []*common.Workload) {
+	writeConfig(args, nodes)
+	writeAttributes(args, nodes)
+	common.WriteWorkloads(args, workloads)
+}
+
 //writeConfig will create the config.csv file that is will be sent Densify by the Forwarder.
-func writeConfig(args *common.Parameters) {
+func writeConfig(args *common.Parameters, nodes map[string]*entity.Node) {
 
 	//Create the config file and open it for writing.
-	configWrite, err := os.Create("./data/node/config.csv")
+	configWrite, err := common.CreateFile(args, "node", "config", "cluster,node,HW Model,OS Name,HW Total CPUs,HW Total Physical CPUs,HW Cores Per CPU,HW Threads Per Core,HW Total Memory,BM Max Network IO Bps")
 	if err != nil {
-		args.ErrorLogger.Println("entity=" + entityKind + " message=" + err.Error())
-		fmt.Println("entity=" + entityKind + " message=" + err.Error())
+		args.Logger.Error(err.Error(), "entity", entityKind)
 		return
 	}
-
-	//Write out the header.
-	fmt.Fprintln(configWrite, "cluster,node,HW Model,OS Name,HW Total CPUs,HW Total Physical CPUs,HW Cores Per CPU,HW Threads Per Core,HW Total Memory,BM Max Network IO Bps")
+	defer configWrite.Close()
 
 	//Loop through the nodes and write out the config data for each system.
 	for kn := range nodes {
 		var os, instance string
-		if _, ok := nodes[kn].labelMap["label_kubernetes_io_os"]; ok {
+		if _, ok := nodes[kn].Labels["label_kubernetes_io_os"]; ok {
 			os = "label_kubernetes_io_os"
 		} else {
 			os = "label_beta_kubernetes_io_os"
 		}
 
-		if value, ok := nodes[kn].labelMap["label_node_kubernetes_io_instance_type"]; ok {
+		if value, ok := nodes[kn].Labels["label_node_kubernetes_io_instance_type"]; ok {
 			instance = value
-		} else if value, ok := nodes[kn].labelMap["label_beta_kubernetes_io_instance_type"]; ok {
+		} else if value, ok := nodes[kn].Labels["label_beta_kubernetes_io_instance_type"]; ok {
 			instance = value
 		} else {
 			instance = ""
 		}
 
-		fmt.Fprintf(configWrite, "%s,%s,%s,%s", *args.ClusterName, kn, instance, nodes[kn].labelMap[os])
+		fmt.Fprintf(configWrite, "%s,%s,%s,%s", *args.ClusterName, kn, instance, nodes[kn].Labels[os])
 
-		if nodes[kn].cpuCapacity == -1 {
+		if nodes[kn].CPUCapacity == -1 {
 			fmt.Fprintf(configWrite, ",,")
 		} else {
-			fmt.Fprintf(configWrite, ",%d,%d", nodes[kn].cpuCapacity, nodes[kn].cpuCapacity)
+			fmt.Fprintf(configWrite, ",%d,%d", nodes[kn].CPUCapacity, nodes[kn].CPUCapacity)
 		}
 
 		fmt.Fprintf(configWrite, ",1,1")
 
-		if nodes[kn].memCapacity == -1 {
+		if nodes[kn].MemCapacity == -1 {
 			fmt.Fprintf(configWrite, ",")
 		} else {
-			fmt.Fprintf(configWrite, ",%d", nodes[kn].memCapacity/1024/1024)
+			fmt.Fprintf(configWrite, ",%d", nodes[kn].MemCapacity/1024/1024)
 		}
 
-		if nodes[kn].netSpeedBytes == -1 {
+		if nodes[kn].NetSpeedBytes == -1 {
 			fmt.Fprintf(configWrite, ",")
 		} else {
-			fmt.Fprintf(configWrite, ",%d", nodes[kn].netSpeedBytes)
+			fmt.Fprintf(configWrite, ",%d", nodes[kn].NetSpeedBytes)
 		}
 
 		fmt.Fprintf(configWrite, "\n")
@@ -71,140 +75,137 @@ func writeConfig(args *common.Parameters) {
 }
 
 //writeAttributes will create the attributes.csv file that is will be sent Densify by the Forwarder.
-func writeAttributes(args *common.Parameters) {
+func writeAttributes(args *common.Parameters, nodes map[string]*entity.Node) {
 
 	//Create the attributes file and open it for writing
-	attributeWrite, err := os.Create("./data/node/attributes.csv")
+	attributeWrite, err := common.CreateFile(args, "node", "attributes", args.LabelColumns.Header("cluster,node,Virtual Technology,Virtual Domain,Virtual Datacenter,Virtual Cluster,OS Architecture,Network Speed,Existing CPU Limit,Existing CPU Request,Existing Memory Limit,Existing Memory Request,Capacity Pods,Capacity CPU,Capacity Memory,Capacity Ephemeral Storage,Capacity Huge Pages,Allocatable Pods,Allocatable CPU,Allocatable Memory,Allocatable Ephemeral Storage,Allocatable Huge Pages,Node Labels"))
 	if err != nil {
-		args.ErrorLogger.Println("entity=" + entityKind + " message=" + err.Error())
-		fmt.Println("entity=" + entityKind + " message=" + err.Error())
+		args.Logger.Error(err.Error(), "entity", entityKind)
 		return
 	}
-
-	//Write out the header.
-	fmt.Fprintln(attributeWrite, "cluster,node,Virtual Technology,Virtual Domain,Virtual Datacenter,Virtual Cluster,OS Architecture,Network Speed,Existing CPU Limit,Existing CPU Request,Existing Memory Limit,Existing Memory Request,Capacity Pods,Capacity CPU,Capacity Memory,Capacity Ephemeral Storage,Capacity Huge Pages,Allocatable Pods,Allocatable CPU,Allocatable Memory,Allocatable Ephemeral Storage,Allocatable Huge Pages,Node Labels")
+	defer attributeWrite.Close()
 
 	//Loop through the nodes and write out the attributes data for each system.
 	for kn := range nodes {
 
 		var beta, region, zone string
-		if _, ok := nodes[kn].labelMap["label_kubernetes_io_arch"]; ok {
+		if _, ok := nodes[kn].Labels["label_kubernetes_io_arch"]; ok {
 			beta = ""
 		} else {
 			beta = "beta_"
 		}
 
-		if value, ok := nodes[kn].labelMap["label_topology_kubernetes_io_region"]; ok {
+		if value, ok := nodes[kn].Labels["label_topology_kubernetes_io_region"]; ok {
 			region = value
-		} else if value, ok := nodes[kn].labelMap["label_failure_domain_beta_kubernetes_io_region"]; ok {
+		} else if value, ok := nodes[kn].Labels["label_failure_domain_beta_kubernetes_io_region"]; ok {
 			region = value
 		} else {
 			region = ""
 		}
 
-		if value, ok := nodes[kn].labelMap["label_topology_kubernetes_io_zone"]; ok {
+		if value, ok := nodes[kn].Labels["label_topology_kubernetes_io_zone"]; ok {
 			zone = value
-		} else if value, ok := nodes[kn].labelMap["label_failure_domain_beta_kubernetes_io_zone"]; ok {
+		} else if value, ok := nodes[kn].Labels["label_failure_domain_beta_kubernetes_io_zone"]; ok {
 			zone = value
 		} else {
 			zone = ""
 		}
 
 		//Write out the different fields. For fiels that are numeric we don't want to write -1 if it wasn't set so we write a blank if that is the value otherwise we write the number out.
-		fmt.Fprintf(attributeWrite, "%s,%s,Nodes,%s,%s,%s,%s", *args.ClusterName, kn, *args.ClusterName, region, zone, nodes[kn].labelMap["label_"+beta+"kubernetes_io_arch"])
+		fmt.Fprintf(attributeWrite, "%s,%s,Nodes,%s,%s,%s,%s", *args.ClusterName, kn, *args.ClusterName, region, zone, nodes[kn].Labels["label_"+beta+"kubernetes_io_arch"])
 
-		if nodes[kn].netSpeedBytes == -1 {
+		if nodes[kn].NetSpeedBytes == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].netSpeedBytes)
+			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].NetSpeedBytes)
 		}
 
-		if nodes[kn].cpuLimit == -1 {
+		if nodes[kn].CPULimit == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].cpuLimit)
+			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].CPULimit)
 		}
 
-		if nodes[kn].cpuRequest == -1 {
+		if nodes[kn].CPURequest == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].cpuRequest)
+			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].CPURequest)
 		}
 
-		if nodes[kn].memLimit == -1 {
+		if nodes[kn].MemLimit == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].memLimit)
+			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].MemLimit)
 		}
 
-		if nodes[kn].memRequest == -1 {
+		if nodes[kn].MemRequest == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].memRequest)
+			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].MemRequest)
 		}
 
-		if nodes[kn].podsCapacity == -1 {
+		if nodes[kn].PodsCapacity == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].podsCapacity)
+			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].PodsCapacity)
 		}
 
-		if nodes[kn].cpuCapacity == -1 {
+		if nodes[kn].CPUCapacity == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].cpuCapacity)
+			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].CPUCapacity)
 		}
 
-		if nodes[kn].memCapacity == -1 {
+		if nodes[kn].MemCapacity == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].memCapacity)
+			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].MemCapacity)
 		}
 
-		if nodes[kn].ephemeralStorageCapacity == -1 {
+		if nodes[kn].EphemeralStorageCapacity == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].ephemeralStorageCapacity)
+			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].EphemeralStorageCapacity)
 		}
 
-		if nodes[kn].hugepages2MiCapacity == -1 {
+		if nodes[kn].HugePages2MiCapacity == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].hugepages2MiCapacity)
+			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].HugePages2MiCapacity)
 		}
 
-		if nodes[kn].podsAllocatable == -1 {
+		if nodes[kn].PodsAllocatable == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].podsAllocatable)
+			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].PodsAllocatable)
 		}
 
-		if nodes[kn].cpuAllocatable == -1 {
+		if nodes[kn].CPUAllocatable == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].cpuAllocatable)
+			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].CPUAllocatable)
 		}
 
-		if nodes[kn].memAllocatable == -1 {
+		if nodes[kn].MemAllocatable == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].memAllocatable)
+			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].MemAllocatable)
 		}
 
-		if nodes[kn].ephemeralStorageAllocatable == -1 {
+		if nodes[kn].EphemeralStorageAllocatable == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].ephemeralStorageAllocatable)
+			fmt.Fprintf(attributeWrite, ",%d", nodes[kn].EphemeralStorageAllocatable)
 		}
 
-		if nodes[kn].hugepages2MiAllocatable == -1 {
+		if nodes[kn].HugePages2MiAllocatable == -1 {
 			fmt.Fprintf(attributeWrite, ",,")
 		} else {
-			fmt.Fprintf(attributeWrite, ",%d,", nodes[kn].hugepages2MiAllocatable)
+			fmt.Fprintf(attributeWrite, ",%d,", nodes[kn].HugePages2MiAllocatable)
 		}
 
-		for key, value := range nodes[kn].labelMap {
-			if len(key) >= 250 {
+		for key, value := range nodes[kn].Labels {
+			if len(key) >= 250 || !args.LabelFilter.Keep("node", key) {
 				continue
 			}
 			value = strings.Replace(value, ",", " ", -1)
@@ -215,7 +216,7 @@ func writeAttributes(args *common.Parameters) {
 				fmt.Fprintf(attributeWrite, key+" : "+value[:templength]+"|")
 			}
 		}
-		fmt.Fprintf(attributeWrite, "\n")
+		fmt.Fprintf(attributeWrite, "%s\n", args.LabelColumns.Row(map[string]map[string]string{"node": nodes[kn].Labels}))
 
 	}
 }
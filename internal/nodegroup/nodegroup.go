@@ -3,27 +3,31 @@ package nodegroup
 
 import (
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/entity"
 	"github.com/prometheus/common/model"
 )
 
-type nodeGroupStruct struct {
-	nodes                                                                             string
-	cpuLimit, cpuRequest, cpuCapacity, memLimit, memRequest, memCapacity, currentSize int
-	labelMap                                                                          map[string]string
-}
-
-var nodeGroups = map[string]*nodeGroupStruct{}
-
 //Hard-coded string for log file warnings
 var entityKind = "node_group"
 
+//Collector collects the node group data for a single run. Create a new one with NewCollector for every run as it holds the node groups found.
+type Collector struct {
+	args       *common.Parameters
+	nodeGroups map[string]*entity.NodeGroup
+	workloads  []*common.Workload
+}
+
+//NewCollector returns a Collector that queries Prometheus using the parameters provided.
+func NewCollector(args *common.Parameters) *Collector {
+	return &Collector{args: args, nodeGroups: map[string]*entity.NodeGroup{}}
+}
+
 //getNodeMetricString is used to parse the label based results from Prometheus related to Container Entities and store them in the systems data structure.
-func getNodeMetricString(result model.Value, nodeGroup model.LabelName) {
+func (c *Collector) getNodeMetricString(result model.Value, nodeGroup model.LabelName) {
 	//Validate there is data in the results.
 	if result == nil {
 		return
@@ -34,17 +38,17 @@ func getNodeMetricString(result model.Value, nodeGroup model.LabelName) {
 		if !ok {
 			continue
 		}
-		if _, ok := nodeGroups[string(nodeGroupValue)]; !ok {
+		if _, ok := c.nodeGroups[string(nodeGroupValue)]; !ok {
 			continue
 		}
 		for key, value := range result.(model.Matrix)[i].Metric {
-			common.AddToLabelMap(string(key), string(value), nodeGroups[string(nodeGroupValue)].labelMap)
+			common.AddToLabelMap(string(key), string(value), c.nodeGroups[string(nodeGroupValue)].Labels)
 		}
 	}
 }
 
 //Gets node metrics from prometheus (and checks to see if they are valid)
-func getNodeGroupMetric(result model.Value, nodeGroupLabel model.LabelName, metric string) {
+func (c *Collector) getNodeGroupMetric(result model.Value, nodeGroupLabel model.LabelName, metric string) {
 
 	//Loop through the different entities in the results.
 	for i := 0; i < result.(model.Matrix).Len(); i++ {
@@ -52,7 +56,7 @@ func getNodeGroupMetric(result model.Value, nodeGroupLabel model.LabelName, metr
 		if !ok {
 			continue
 		}
-		if _, ok := nodeGroups[string(nodeGroup)]; !ok {
+		if _, ok := c.nodeGroups[string(nodeGroup)]; !ok {
 			continue
 		}
 		//validates that the value of the entity is set and if not will default to 0
@@ -63,111 +67,110 @@ func getNodeGroupMetric(result model.Value, nodeGroupLabel model.LabelName, metr
 
 		switch metric {
 		case "cpuLimit":
-			nodeGroups[string(nodeGroup)].cpuLimit = int(value)
+			c.nodeGroups[string(nodeGroup)].CPULimit = int(value)
 		case "cpuRequest":
-			nodeGroups[string(nodeGroup)].cpuRequest = int(value)
+			c.nodeGroups[string(nodeGroup)].CPURequest = int(value)
 		case "cpuCapacity":
-			nodeGroups[string(nodeGroup)].cpuCapacity = int(value)
+			c.nodeGroups[string(nodeGroup)].CPUCapacity = int(value)
 		case "memLimit":
-			nodeGroups[string(nodeGroup)].memLimit = int(value)
+			c.nodeGroups[string(nodeGroup)].MemLimit = int(value)
 		case "memRequest":
-			nodeGroups[string(nodeGroup)].memRequest = int(value)
+			c.nodeGroups[string(nodeGroup)].MemRequest = int(value)
 		case "memCapacity":
-			nodeGroups[string(nodeGroup)].memCapacity = int(value)
+			c.nodeGroups[string(nodeGroup)].MemCapacity = int(value)
 		}
 
 	}
 }
 
+//Write creates the config, attributes and workload files for the node groups and workloads found by the Collector.
+func Write(args *common.Parameters, nodeGroups map[string]*entity.NodeGroup, workloads []*common.Workload) {
+	writeAttributes(args, nodeGroups)
+	writeConfig(args, nodeGroups)
+	common.WriteWorkloads(args, workloads)
+}
+
 //writeNodeGroupConfig will create the config.csv file that is will be sent Densify by the Forwarder.
-func writeConfig(args *common.Parameters) {
+func writeConfig(args *common.Parameters, nodeGroups map[string]*entity.NodeGroup) {
 
 	//Create the config file and open it for writing.
-	configWrite, err := os.Create("./data/node_group/config.csv")
+	configWrite, err := common.CreateFile(args, entityKind, "config", "cluster,node_group,HW Total CPUs,HW Total Physical CPUs,HW Cores Per CPU,HW Threads Per Core,HW Total Memory,HW Model,OS Name")
 	if err != nil {
-		args.ErrorLogger.Println("entity=node_group message=" + err.Error())
-		fmt.Println("entity=node_group message=" + err.Error())
+		args.Logger.Error(err.Error(), "entity", "node_group")
 		return
 	}
 
-	//Write out the header.
-	fmt.Fprintln(configWrite, "cluster,node_group,HW Total CPUs,HW Total Physical CPUs,HW Cores Per CPU,HW Threads Per Core,HW Total Memory,HW Model,OS Name")
-
 	for nodeGroupName, nodeGroup := range nodeGroups {
 		var os, instance string
-		if _, ok := nodeGroup.labelMap["label_kubernetes_io_os"]; ok {
+		if _, ok := nodeGroup.Labels["label_kubernetes_io_os"]; ok {
 			os = "label_kubernetes_io_os"
 		} else {
 			os = "label_beta_kubernetes_io_os"
 		}
 
-		if value, ok := nodeGroup.labelMap["label_node_kubernetes_io_instance_type"]; ok {
+		if value, ok := nodeGroup.Labels["label_node_kubernetes_io_instance_type"]; ok {
 			instance = value
-		} else if value, ok := nodeGroup.labelMap["label_beta_kubernetes_io_instance_type"]; ok {
+		} else if value, ok := nodeGroup.Labels["label_beta_kubernetes_io_instance_type"]; ok {
 			instance = value
 		} else {
 			instance = ""
 		}
 
-		fmt.Fprintf(configWrite, "%s,%s,%s,%s,", *args.ClusterName, nodeGroupName, instance, nodeGroup.labelMap[os])
+		fmt.Fprintf(configWrite, "%s,%s,%s,%s,", *args.ClusterName, nodeGroupName, instance, nodeGroup.Labels[os])
 
-		if nodeGroup.cpuCapacity == -1 {
+		if nodeGroup.CPUCapacity == -1 {
 			fmt.Fprintf(configWrite, ",,1,1,")
 		} else {
-			fmt.Fprintf(configWrite, "%d,%d,1,1,", nodeGroup.cpuCapacity, nodeGroup.cpuCapacity)
+			fmt.Fprintf(configWrite, "%d,%d,1,1,", nodeGroup.CPUCapacity, nodeGroup.CPUCapacity)
 		}
-		if nodeGroup.memCapacity == -1 {
+		if nodeGroup.MemCapacity == -1 {
 			fmt.Fprintf(configWrite, "\n")
 		} else {
-			fmt.Fprintf(configWrite, "%d\n", nodeGroup.memCapacity)
+			fmt.Fprintf(configWrite, "%d\n", nodeGroup.MemCapacity)
 		}
 	}
 	configWrite.Close()
 }
 
 //writeNodeGroupAttributes will create the attributes.csv file that is will be sent Densify by the Forwarder.
-func writeAttributes(args *common.Parameters) {
+func writeAttributes(args *common.Parameters, nodeGroups map[string]*entity.NodeGroup) {
 
 	//Create the attributes file and open it for writing
-	attributeWrite, err := os.Create("./data/node_group/attributes.csv")
+	attributeWrite, err := common.CreateFile(args, entityKind, "attributes", args.LabelColumns.Header("cluster,node_group,Virtual Technology,Virtual Domain,Existing CPU Limit,Existing CPU Request,Existing Memory Limit,Existing Memory Request,Current Size,Current Nodes,Node Labels"))
 	if err != nil {
-		args.ErrorLogger.Println("entity=node_group message=" + err.Error())
-		fmt.Println("entity=node_group message=" + err.Error())
+		args.Logger.Error(err.Error(), "entity", "node_group")
 		return
 	}
 
-	//Write out the header.
-	fmt.Fprintln(attributeWrite, "cluster,node_group,Virtual Technology,Virtual Domain,Existing CPU Limit,Existing CPU Request,Existing Memory Limit,Existing Memory Request,Current Size,Current Nodes,Node Labels")
-
 	for nodeGroupName, nodeGroup := range nodeGroups {
 		//Write out the different fields. For fiels that are numeric we don't want to write -1 if it wasn't set so we write a blank if that is the value otherwise we write the number out.
 		fmt.Fprintf(attributeWrite, "%s,%s,NodeGroup,%s,", *args.ClusterName, nodeGroupName, *args.ClusterName)
 
-		if nodeGroup.cpuLimit == -1 {
+		if nodeGroup.CPULimit == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, "%d,", nodeGroup.cpuLimit)
+			fmt.Fprintf(attributeWrite, "%d,", nodeGroup.CPULimit)
 		}
 
-		if nodeGroup.cpuRequest == -1 {
+		if nodeGroup.CPURequest == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, "%d,", nodeGroup.cpuRequest)
+			fmt.Fprintf(attributeWrite, "%d,", nodeGroup.CPURequest)
 		}
 
-		if nodeGroup.memLimit == -1 {
+		if nodeGroup.MemLimit == -1 {
 			fmt.Fprintf(attributeWrite, ",")
 		} else {
-			fmt.Fprintf(attributeWrite, "%d,", nodeGroup.memLimit)
+			fmt.Fprintf(attributeWrite, "%d,", nodeGroup.MemLimit)
 		}
 
-		if nodeGroup.memRequest == -1 {
-			fmt.Fprintf(attributeWrite, "%d,%s,", nodeGroup.currentSize, nodeGroup.nodes[:len(nodeGroup.nodes)-1])
+		if nodeGroup.MemRequest == -1 {
+			fmt.Fprintf(attributeWrite, "%d,%s,", nodeGroup.CurrentSize, strings.Join(nodeGroup.Nodes, ";"))
 		} else {
-			fmt.Fprintf(attributeWrite, "%d,%d,%s,", nodeGroup.memRequest, nodeGroup.currentSize, nodeGroup.nodes[:len(nodeGroup.nodes)-1])
+			fmt.Fprintf(attributeWrite, "%d,%d,%s,", nodeGroup.MemRequest, nodeGroup.CurrentSize, strings.Join(nodeGroup.Nodes, ";"))
 		}
-		for key, value := range nodeGroup.labelMap {
-			if len(key) >= 250 {
+		for key, value := range nodeGroup.Labels {
+			if len(key) >= 250 || !args.LabelFilter.Keep("node", key) {
 				continue
 			}
 			value = strings.Replace(value, ",", " ", -1)
@@ -178,14 +181,15 @@ func writeAttributes(args *common.Parameters) {
 				fmt.Fprintf(attributeWrite, key+" : "+value[:templength]+"|")
 			}
 		}
-		fmt.Fprintf(attributeWrite, "\n")
+		fmt.Fprintf(attributeWrite, "%s\n", args.LabelColumns.Row(map[string]map[string]string{"node": nodeGroup.Labels}))
 	}
 
 	attributeWrite.Close()
 }
 
-//Metrics a global func for collecting node level metrics in prometheus
-func Metrics(args *common.Parameters) {
+//Collect gathers the node groups and their workloads, unless they are skipped, and returns them to be written by Write. It returns nil if no node groups were found.
+func (c *Collector) Collect() (map[string]*entity.NodeGroup, []*common.Workload) {
+	args := c.args.PlanFor(entityKind, entityKind+"/config.csv,"+entityKind+"/attributes.csv")
 	//Setup variables used in the code.
 	var historyInterval time.Duration
 	historyInterval = 0
@@ -199,9 +203,13 @@ func Metrics(args *common.Parameters) {
 	var nodeGroupLabel model.LabelName
 
 	query = `avg(kube_node_labels) by (label_cloud_google_com_gke_nodepool,label_eks_amazonaws_com_nodegroup, label_agentpool, label_pool_name)`
-	result = common.MetricCollect(args, query, range5Min, "nodeGroupingLabelLookup", false)
+	result, detected := common.DetectCollect(args, query, range5Min, "nodeGroupingLabelLookup")
+	if !detected {
+		args.Logger.Info("The node group queries depend on the node group label found in Prometheus, use schema detection to plan them", "entity", entityKind)
+		return nil, nil
+	}
 	if result == nil {
-		return
+		return nil, nil
 	}
 
 	for i := range result.(model.Matrix) {
@@ -211,92 +219,93 @@ func Metrics(args *common.Parameters) {
 	}
 
 	if nodeGroupLabel == "" {
-		return
+		return nil, nil
 	}
 
 	query = `kube_node_labels{` + string(nodeGroupLabel) + `=~".+"}`
 	result = common.MetricCollect(args, query, range5Min, "groupedNodes", false)
 	if result == nil {
-		return
+		return nil, nil
 	}
 	for i := range result.(model.Matrix) {
 		nodeGroup := string(result.(model.Matrix)[i].Metric[model.LabelName(nodeGroupLabel)])
 		node := string(result.(model.Matrix)[i].Metric[`node`])
-		if _, ok := nodeGroups[nodeGroup]; !ok {
-			nodeGroups[nodeGroup] = &nodeGroupStruct{cpuLimit: -1, cpuRequest: -1, cpuCapacity: -1, memLimit: -1, memRequest: -1, memCapacity: -1, labelMap: map[string]string{}}
+		if _, ok := c.nodeGroups[nodeGroup]; !ok {
+			c.nodeGroups[nodeGroup] = &entity.NodeGroup{Name: nodeGroup, CPULimit: -1, CPURequest: -1, CPUCapacity: -1, MemLimit: -1, MemRequest: -1, MemCapacity: -1, Labels: map[string]string{}}
 		}
-		nodeGroups[nodeGroup].nodes = nodeGroups[nodeGroup].nodes + node + ";"
-		nodeGroups[nodeGroup].currentSize++
+		c.nodeGroups[nodeGroup].Nodes = append(c.nodeGroups[nodeGroup].Nodes, node)
+		c.nodeGroups[nodeGroup].CurrentSize++
 	}
 
-	getNodeMetricString(result, nodeGroupLabel)
+	c.getNodeMetricString(result, nodeGroupLabel)
 
 	var nodeGroupSuffix = ` * on (node) group_right kube_node_labels{` + string(nodeGroupLabel) + `=~".+"}) by (` + string(nodeGroupLabel) + `)`
 
-	query = `avg(sum(kube_pod_container_resource_limits_cpu_cores*1000 * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)` + nodeGroupSuffix
+	query = args.PodQuery(`avg(sum(kube_pod_container_resource_limits_cpu_cores*1000 * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)` + nodeGroupSuffix)
 	result = common.MetricCollect(args, query, range5Min, "cpuLimit", false)
 	if result != nil {
-		getNodeGroupMetric(result, nodeGroupLabel, "cpuLimit")
+		c.getNodeGroupMetric(result, nodeGroupLabel, "cpuLimit")
 	}
 
-	query = `avg(sum(kube_pod_container_resource_requests_cpu_cores*1000 * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)` + nodeGroupSuffix
+	query = args.PodQuery(`avg(sum(kube_pod_container_resource_requests_cpu_cores*1000 * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)` + nodeGroupSuffix)
 	result = common.MetricCollect(args, query, range5Min, "cpuRequest", false)
 	if result != nil {
-		getNodeGroupMetric(result, nodeGroupLabel, "cpuRequest")
+		c.getNodeGroupMetric(result, nodeGroupLabel, "cpuRequest")
 	}
 
-	query = `avg(sum(kube_pod_container_resource_limits_memory_bytes/1024/1024 * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)` + nodeGroupSuffix
+	query = args.PodQuery(`avg(sum(kube_pod_container_resource_limits_memory_bytes/1024/1024 * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)` + nodeGroupSuffix)
 	result = common.MetricCollect(args, query, range5Min, "memLimit", false)
 	if result != nil {
-		getNodeGroupMetric(result, nodeGroupLabel, "memLimit")
+		c.getNodeGroupMetric(result, nodeGroupLabel, "memLimit")
 	}
 
-	query = `avg(sum(kube_pod_container_resource_requests_memory_bytes/1024/1024 * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)` + nodeGroupSuffix
+	query = args.PodQuery(`avg(sum(kube_pod_container_resource_requests_memory_bytes/1024/1024 * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)` + nodeGroupSuffix)
 	result = common.MetricCollect(args, query, range5Min, "memRequest", false)
 	if result != nil {
-		getNodeGroupMetric(result, nodeGroupLabel, "memRequest")
+		c.getNodeGroupMetric(result, nodeGroupLabel, "memRequest")
 	}
 
 	query = `avg(kube_node_status_capacity_cpu_cores` + nodeGroupSuffix
 	result = common.MetricCollect(args, query, range5Min, "cpuCapacity", false)
 	if result != nil {
-		getNodeGroupMetric(result, nodeGroupLabel, "cpuCapacity")
+		c.getNodeGroupMetric(result, nodeGroupLabel, "cpuCapacity")
 	}
 
 	query = `avg(kube_node_status_capacity_memory_bytes/1024/1024` + nodeGroupSuffix
 	result = common.MetricCollect(args, query, range5Min, "memCapacity", false)
 	if result != nil {
-		getNodeGroupMetric(result, nodeGroupLabel, "memCapacity")
+		c.getNodeGroupMetric(result, nodeGroupLabel, "memCapacity")
 	}
 
-	writeAttributes(args)
-	writeConfig(args)
+	if args.SkipWorkloads {
+		return c.nodeGroups, c.workloads
+	}
 
 	//Query and store prometheus CPU requests
-	query = `avg(sum((kube_pod_container_resource_requests_cpu_cores) * on (namespace,pod,container) group_left kube_pod_container_status_running)  by (node)` + nodeGroupSuffix
-	common.GetWorkload("cpu_requests", "CPU Reservation in Cores", query, nodeGroupLabel, args, entityKind)
+	query = args.PodQuery(`avg(sum((kube_pod_container_resource_requests_cpu_cores) * on (namespace,pod,container) group_left kube_pod_container_status_running)  by (node)` + nodeGroupSuffix)
+	c.workloads = append(c.workloads, common.GetWorkload("cpu_requests", "CPU Reservation in Cores", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus CPU requests
-	query = `avg(sum((kube_pod_container_resource_requests_cpu_cores) * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node) / sum(kube_node_status_allocatable_cpu_cores) by (node)` + nodeGroupSuffix + ` * 100`
-	common.GetWorkload("cpu_reservation_percent", "CPU Reservation Percent", query, nodeGroupLabel, args, entityKind)
+	query = args.PodQuery(`avg(sum((kube_pod_container_resource_requests_cpu_cores) * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node) / sum(kube_node_status_allocatable_cpu_cores) by (node)` + nodeGroupSuffix + ` * 100`)
+	c.workloads = append(c.workloads, common.GetWorkload("cpu_reservation_percent", "CPU Reservation Percent", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus Memory requests
-	query = `avg(sum((kube_pod_container_resource_requests_memory_bytes/1024/1024) * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)` + nodeGroupSuffix
-	common.GetWorkload("memory_requests", "Memory Reservation in MB", query, nodeGroupLabel, args, entityKind)
+	query = args.PodQuery(`avg(sum((kube_pod_container_resource_requests_memory_bytes/1024/1024) * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node)` + nodeGroupSuffix)
+	c.workloads = append(c.workloads, common.GetWorkload("memory_requests", "Memory Reservation in MB", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus Memory requests
-	query = `avg(sum((kube_pod_container_resource_requests_memory_bytes/1024/1024) * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node) / sum(kube_node_status_allocatable_memory_bytes/1024/1024) by (node)` + nodeGroupSuffix + ` * 100`
-	common.GetWorkload("memory_reservation_percent", "Memory Reservation Percent", query, nodeGroupLabel, args, entityKind)
+	query = args.PodQuery(`avg(sum((kube_pod_container_resource_requests_memory_bytes/1024/1024) * on (namespace,pod,container) group_left kube_pod_container_status_running) by (node) / sum(kube_node_status_allocatable_memory_bytes/1024/1024) by (node)` + nodeGroupSuffix + ` * 100`)
+	c.workloads = append(c.workloads, common.GetWorkload("memory_reservation_percent", "Memory Reservation Percent", query, nodeGroupLabel, args, entityKind))
 
 	//Check to see which disk queries to use if instance is IP address that need to link to pod to get name or if instance = node name.
-	query = `max(max(label_replace(sum(irate(node_cpu_seconds_total{mode!="idle"}[` + args.SampleRateString + `m])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100, "pod_ip", "$1", "instance", "(.*):.*")) by (pod_ip) * on (pod_ip) group_right kube_pod_info{pod=~".*node-exporter.*"}) by (node)`
-	result = common.MetricCollect(args, query, range5Min, "testNodeWorkload", false)
+	query = `max(max(label_replace(sum(irate(node_cpu_seconds_total{mode!="idle"}[` + args.RateWindow + `])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100, "pod_ip", "$1", "instance", "(.*):.*")) by (pod_ip) * on (pod_ip) group_right kube_pod_info{pod=~".*node-exporter.*"}) by (node)`
+	result, _ = common.DetectCollect(args, query, range5Min, "testNodeWorkload")
 
 	queryPrefix := `avg(label_replace(`
 	queryPrefixSum := `avg(label_replace(sum(`
 	querySuffix := `, "node", "$1", "instance", "(.*):*")` + nodeGroupSuffix
 	querySuffixSum := `) by (instance), "node", "$1", "instance", "(.*):*")` + nodeGroupSuffix
-	if result.(model.Matrix).Len() != 0 {
+	if rslt, ok := result.(model.Matrix); ok && rslt.Len() != 0 {
 		queryPrefix = `avg(max(label_replace(`
 		queryPrefixSum = `avg(sum(label_replace(`
 		querySuffix = `, "pod_ip", "$1", "instance", "(.*):.*")) by (pod_ip) * on (pod_ip) group_right kube_pod_info{pod=~".*node-exporter.*"}` + nodeGroupSuffix
@@ -304,67 +313,69 @@ func Metrics(args *common.Parameters) {
 	}
 
 	query = `sum(kube_node_labels{` + string(nodeGroupLabel) + `=~".+"}) by (` + string(nodeGroupLabel) + `)`
-	common.GetWorkload("current_size", "Auto Scaling - In Service Instances", query, nodeGroupLabel, args, entityKind)
+	c.workloads = append(c.workloads, common.GetWorkload("current_size", "Auto Scaling - In Service Instances", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus total cpu uptime in seconds
-	query = queryPrefix + `sum(irate(node_cpu_seconds_total{mode!="idle"}[` + args.SampleRateString + `m])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100` + querySuffix
-	common.GetWorkload("cpu_utilization", "CPU Utilization", query, nodeGroupLabel, args, entityKind)
+	query = queryPrefix + `sum(irate(node_cpu_seconds_total{mode!="idle"}[` + args.RateWindow + `])) by (instance) / on (instance) group_left count(node_cpu_seconds_total{mode="idle"}) by (instance) *100` + querySuffix
+	c.workloads = append(c.workloads, common.GetWorkload("cpu_utilization", "CPU Utilization", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus node memory total in bytes
 	query = queryPrefix + `node_memory_MemTotal_bytes - node_memory_MemFree_bytes` + querySuffix
-	common.GetWorkload("memory_raw_bytes", "Raw Mem Utilization", query, nodeGroupLabel, args, entityKind)
+	c.workloads = append(c.workloads, common.GetWorkload("memory_raw_bytes", "Raw Mem Utilization", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus node memory total free in bytes
 	query = queryPrefix + `node_memory_MemTotal_bytes - (node_memory_MemFree_bytes + node_memory_Cached_bytes + node_memory_Buffers_bytes)` + querySuffix
-	common.GetWorkload("memory_actual_workload", "Actual Memory Utilization", query, nodeGroupLabel, args, entityKind)
+	c.workloads = append(c.workloads, common.GetWorkload("memory_actual_workload", "Actual Memory Utilization", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus node disk write in bytes
-	query = queryPrefixSum + `irate(node_disk_written_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_write_bytes", "Raw Disk Write Utilization", query, nodeGroupLabel, args, entityKind)
+	query = queryPrefixSum + `irate(node_disk_written_bytes_total{device!~"dm-.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("disk_write_bytes", "Raw Disk Write Utilization", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus node disk read in bytes
-	query = queryPrefixSum + `irate(node_disk_read_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_read_bytes", "Raw Disk Read Utilization", query, nodeGroupLabel, args, entityKind)
+	query = queryPrefixSum + `irate(node_disk_read_bytes_total{device!~"dm-.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("disk_read_bytes", "Raw Disk Read Utilization", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus total disk read uptime as a percentage
-	query = queryPrefixSum + `irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_read_ops", "Disk Read Operations", query, nodeGroupLabel, args, entityKind)
+	query = queryPrefixSum + `irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[` + args.RateWindow + `]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("disk_read_ops", "Disk Read Operations", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus total disk write uptime as a percentage
-	query = queryPrefixSum + `irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_write_ops", "Disk Write Operations", query, nodeGroupLabel, args, entityKind)
+	query = queryPrefixSum + `irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[` + args.RateWindow + `]) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("disk_write_ops", "Disk Write Operations", query, nodeGroupLabel, args, entityKind))
 
 	//Total disk values
 	//Query and store prometheus node disk read in bytes
-	query = queryPrefixSum + `irate(node_disk_read_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) + irate(node_disk_written_bytes_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_total_bytes", "Raw Disk Utilization", query, nodeGroupLabel, args, entityKind)
+	query = queryPrefixSum + `irate(node_disk_read_bytes_total{device!~"dm-.*"}[` + args.RateWindow + `]) + irate(node_disk_written_bytes_total{device!~"dm-.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("disk_total_bytes", "Raw Disk Utilization", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus total disk read uptime as a percentage
-	query = queryPrefixSum + `(irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m]) + irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("disk_total_ops", "Disk Operations", query, nodeGroupLabel, args, entityKind)
+	query = queryPrefixSum + `(irate(node_disk_read_time_seconds_total{device!~"dm-.*"}[` + args.RateWindow + `]) + irate(node_disk_write_time_seconds_total{device!~"dm-.*"}[` + args.RateWindow + `])) / irate(node_disk_io_time_seconds_total{device!~"dm-.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("disk_total_ops", "Disk Operations", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus node recieved network data in bytes
-	query = queryPrefixSum + `irate(node_network_receive_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_received_bytes", "Raw Net Received Utilization", query, nodeGroupLabel, args, entityKind)
+	query = queryPrefixSum + `irate(node_network_receive_bytes_total{device!~"veth.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("net_received_bytes", "Raw Net Received Utilization", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus recieved network data in packets
-	query = queryPrefixSum + `irate(node_network_receive_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_received_packets", "Network Packets Received", query, nodeGroupLabel, args, entityKind)
+	query = queryPrefixSum + `irate(node_network_receive_packets_total{device!~"veth.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("net_received_packets", "Network Packets Received", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus total transmitted network data in bytes
-	query = queryPrefixSum + `irate(node_network_transmit_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_sent_bytes", "Raw Net Sent Utilization", query, nodeGroupLabel, args, entityKind)
+	query = queryPrefixSum + `irate(node_network_transmit_bytes_total{device!~"veth.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("net_sent_bytes", "Raw Net Sent Utilization", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus total transmitted network data in packets
-	query = queryPrefixSum + `irate(node_network_transmit_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_sent_packets", "Network Packets Sent", query, nodeGroupLabel, args, entityKind)
+	query = queryPrefixSum + `irate(node_network_transmit_packets_total{device!~"veth.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("net_sent_packets", "Network Packets Sent", query, nodeGroupLabel, args, entityKind))
 
 	//Total values network
 	//Query and store prometheus total network data in bytes
-	query = queryPrefixSum + `irate(node_network_transmit_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m]) + irate(node_network_receive_bytes_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_total_bytes", "Raw Net Utilization", query, nodeGroupLabel, args, entityKind)
+	query = queryPrefixSum + `irate(node_network_transmit_bytes_total{device!~"veth.*"}[` + args.RateWindow + `]) + irate(node_network_receive_bytes_total{device!~"veth.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("net_total_bytes", "Raw Net Utilization", query, nodeGroupLabel, args, entityKind))
 
 	//Query and store prometheus total network data in packets
-	query = queryPrefixSum + `irate(node_network_transmit_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m]) + irate(node_network_receive_packets_total{device!~"veth.*"}[` + args.SampleRateString + `m])` + querySuffixSum
-	common.GetWorkload("net_total_packets", "Network Packets", query, nodeGroupLabel, args, entityKind)
+	query = queryPrefixSum + `irate(node_network_transmit_packets_total{device!~"veth.*"}[` + args.RateWindow + `]) + irate(node_network_receive_packets_total{device!~"veth.*"}[` + args.RateWindow + `])` + querySuffixSum
+	c.workloads = append(c.workloads, common.GetWorkload("net_total_packets", "Network Packets", query, nodeGroupLabel, args, entityKind))
+
+	return c.nodeGroups, c.workloads
 }
@@ -0,0 +1,47 @@
+package nodegroup
+
+import (
+	"testing"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/testutil"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/entity"
+	"github.com/prometheus/common/model"
+)
+
+//newPoolCollector returns a collector holding the node group pool1 with none of its requests and limits found.
+func newPoolCollector() *Collector {
+	c := NewCollector(&common.Parameters{})
+	c.nodeGroups["pool1"] = &entity.NodeGroup{Name: "pool1", Labels: map[string]string{}, CPULimit: -1, MemLimit: -1}
+	return c
+}
+
+func TestGetNodeGroupMetric(t *testing.T) {
+	c := newPoolCollector()
+	c.getNodeGroupMetric(model.Matrix{
+		testutil.Series([]string{"label_agentpool", "pool1"}, 1000, 1500),
+		testutil.Series([]string{"label_agentpool", "pool9"}, 2000),
+		testutil.Series([]string{"node", "n1"}, 3000),
+	}, "label_agentpool", "cpuRequest")
+	c.getNodeGroupMetric(model.Matrix{testutil.Series([]string{"label_agentpool", "pool1"}, 4096)}, "label_agentpool", "memCapacity")
+
+	ng := c.nodeGroups["pool1"]
+	if ng.CPURequest != 1500 || ng.MemCapacity != 4096 || ng.CPULimit != -1 {
+		t.Errorf("node group = cpu request %d, memory capacity %d, cpu limit %d, want 1500, 4096 and -1", ng.CPURequest, ng.MemCapacity, ng.CPULimit)
+	}
+	if len(c.nodeGroups) != 1 {
+		t.Errorf("node groups = %v, want only pool1", c.nodeGroups)
+	}
+}
+
+func TestGetNodeMetricString(t *testing.T) {
+	c := newPoolCollector()
+	c.getNodeMetricString(model.Matrix{
+		testutil.Series([]string{"label_agentpool", "pool1", "node", "n1"}),
+		testutil.Series([]string{"label_agentpool", "pool1", "node", "n2"}),
+		testutil.Series([]string{"label_agentpool", "pool9", "node", "n3"}),
+	}, "label_agentpool")
+	if got := c.nodeGroups["pool1"].Labels["node"]; got != "n1;n2" {
+		t.Errorf("node label = %q, want the nodes of the group", got)
+	}
+}
@@ -0,0 +1,78 @@
+//Package plan records the queries a dry run would send to Prometheus, with the window and step of each and the file it feeds, so the PromQL built by the collectors can be checked without reading the source or running the collection.
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+//Query is a query the collection would run.
+type Query struct {
+	Cluster string `json:"cluster"`
+	//Entity is the kind of entity the query collects, e.g. container or node.
+	Entity string    `json:"entity"`
+	Metric string    `json:"metric"`
+	Query  string    `json:"query"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Step   string    `json:"step"`
+	//File is the csv file the query feeds, relative to the output directory.
+	File string `json:"file"`
+	//Detect is set for the queries whose results decide which form of the later queries is used, e.g. whether cAdvisor uses the pod_name label. They are only run when schema detection is enabled.
+	Detect bool `json:"detect,omitempty"`
+}
+
+//Plan is the list of queries of a dry run in the order they would run. The methods are safe to call on a nil Plan, which records nothing.
+type Plan struct {
+	//Detect is set when the schema detection queries are run against Prometheus, otherwise the plan uses the current schema.
+	Detect  bool    `json:"detect"`
+	Queries []Query `json:"queries"`
+
+	mu sync.Mutex
+}
+
+//New returns an empty plan, running the schema detection queries if detect is set.
+func New(detect bool) *Plan {
+	return &Plan{Detect: detect, Queries: []Query{}}
+}
+
+//Add records a query.
+func (p *Plan) Add(q Query) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Queries = append(p.Queries, q)
+}
+
+//Print writes the queries as text, one block per query with the query on its own line so it can be copied into the Prometheus UI.
+func (p *Plan) Print(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, q := range p.Queries {
+		detect := ""
+		if q.Detect {
+			detect = " (schema detection)"
+		}
+		fmt.Fprintf(w, "%d. cluster=%s entity=%s metric=%s file=%s%s\n", i+1, q.Cluster, q.Entity, q.Metric, q.File, detect)
+		fmt.Fprintf(w, "   %s to %s step %s\n", q.Start.Format(time.RFC3339), q.End.Format(time.RFC3339), q.Step)
+		fmt.Fprintf(w, "   %s\n\n", q.Query)
+	}
+	fmt.Fprintf(w, "%d queries\n", len(p.Queries))
+}
+
+//Write writes the plan as JSON to path.
+func (p *Plan) Write(path string) error {
+	p.mu.Lock()
+	data, err := json.MarshalIndent(p, "", "  ")
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,187 @@
+//Package scheduler works out when the collections run in daemon mode from a cron style schedule.
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//maxSearch bounds the search for the next run so a schedule that never matches (e.g. 30 February) doesn't loop forever.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+//field is the range of values allowed in one of the fields of the schedule.
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+//descriptors are the shortcuts that can be used instead of the five fields.
+var descriptors = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+//Schedule holds the minutes, hours, days of the month, months and days of the week a collection runs on. All times are in UTC to match the time used for the queries.
+//A schedule with every set runs at each multiple of it since the Unix epoch instead.
+type Schedule struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+	every                         time.Duration
+}
+
+//Parse reads a cron schedule with the five standard fields (minute, hour, day of month, month and day of week), e.g. "5 * * * *" to run at 5 minutes past every hour.
+//Each field can be *, a value, a range (1-5), a list (1,15,30) and a step (*/15 or 0-30/10). The descriptors @hourly, @daily, @midnight, @weekly and @monthly can also be used.
+func Parse(spec string) (*Schedule, error) {
+	expanded := strings.TrimSpace(spec)
+	if d, ok := descriptors[strings.ToLower(expanded)]; ok {
+		expanded = d
+	}
+	parts := strings.Fields(expanded)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields (minute hour day-of-month month day-of-week) but found %d", spec, len(parts))
+	}
+
+	s := &Schedule{spec: spec}
+	bits := []*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, part := range parts {
+		b, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %s", spec, err)
+		}
+		*bits[i] = b
+	}
+	s.domRestricted = parts[2] != "*"
+	s.dowRestricted = parts[4] != "*"
+	return s, nil
+}
+
+//parseField returns the values allowed by the field as a bit set.
+func parseField(part string, f field) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(part, ",") {
+		rng, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s", item[i+1:], f.name)
+			}
+			rng = item[:i]
+		}
+
+		start, end := f.min, f.max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q in %s", bounds[0], f.name)
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q in %s", bounds[1], f.name)
+				}
+			} else if step > 1 {
+				//A single value with a step, e.g. 5/15, runs from the value to the end of the range.
+				end = f.max
+			}
+		}
+		if start < f.min || end > f.max || start > end {
+			return 0, fmt.Errorf("%s must be between %d and %d but found %q", f.name, f.min, f.max, item)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+//Next returns the first time after t that the schedule runs at, or the zero time if it never runs.
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		every := int64(s.every / time.Second)
+		return time.Unix((t.Unix()/every+1)*every, 0).UTC()
+	}
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+//dayMatches follows cron in running on either the day of the month or the day of the week when both are restricted.
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+//Every returns the schedule that runs a collection at the start of every interval of the size given, so each run collects the interval that just ended. Sizes that aren't a whole number of minutes are rounded down to one.
+//Sizes a cron schedule can express, a number of minutes or hours that divides the hour or day or a single day, use one, e.g. 0 */6 * * * for 6h. Other sizes, e.g. 90m or 7d, can't be expressed as a step of a field as the step restarts each hour, day or month, so they run at every multiple of the size since the Unix epoch instead.
+func Every(size time.Duration) *Schedule {
+	if size < time.Minute {
+		size = time.Minute
+	}
+	size = size.Truncate(time.Minute)
+	day := 24 * time.Hour
+	spec := ""
+	switch {
+	case size == day:
+		spec = "0 0 * * *"
+	case size >= time.Hour && size < day && size%time.Hour == 0 && day%size == 0:
+		spec = "0 " + every(int(size/time.Hour)) + " * * *"
+	case size < time.Hour && time.Hour%size == 0:
+		spec = every(int(size/time.Minute)) + " * * * *"
+	}
+	if spec != "" {
+		if s, err := Parse(spec); err == nil {
+			return s
+		}
+	}
+	return &Schedule{spec: "every " + size.String(), every: size}
+}
+
+//every returns the value of a field running every n units.
+func every(n int) string {
+	if n > 1 {
+		return "*/" + strconv.Itoa(n)
+	}
+	return "*"
+}
@@ -0,0 +1,159 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvery(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		size       time.Duration
+		spec, from string
+		next       []string
+	}{
+		{time.Hour, "0 * * * *", "2026-10-19T10:20:00Z", []string{"2026-10-19T11:00:00Z", "2026-10-19T12:00:00Z"}},
+		{15 * time.Minute, "*/15 * * * *", "2026-10-19T10:50:00Z", []string{"2026-10-19T11:00:00Z", "2026-10-19T11:15:00Z"}},
+		{6 * time.Hour, "0 */6 * * *", "2026-10-19T19:00:00Z", []string{"2026-10-20T00:00:00Z", "2026-10-20T06:00:00Z"}},
+		{24 * time.Hour, "0 0 * * *", "2026-10-19T10:00:00Z", []string{"2026-10-20T00:00:00Z", "2026-10-21T00:00:00Z"}},
+		{90 * time.Minute, "every 1h30m0s", "2026-10-19T22:40:00Z", []string{"2026-10-20T00:00:00Z", "2026-10-20T01:30:00Z"}},
+		{45 * time.Minute, "every 45m0s", "2026-10-19T00:00:00Z", []string{"2026-10-19T00:45:00Z", "2026-10-19T01:30:00Z", "2026-10-19T02:15:00Z"}},
+		{5 * time.Hour, "every 5h0m0s", "2026-10-19T23:00:00Z", []string{"2026-10-20T01:00:00Z", "2026-10-20T06:00:00Z"}},
+		{7 * 24 * time.Hour, "every 168h0m0s", "2026-10-31T12:00:00Z", []string{"2026-11-05T00:00:00Z", "2026-11-12T00:00:00Z"}},
+	}
+	for _, test := range tests {
+		s := Every(test.size)
+		if s.String() != test.spec {
+			t.Errorf("Every(%s) = %q, want %q", test.size, s.String(), test.spec)
+		}
+		next := at(test.from)
+		for _, want := range test.next {
+			if next = s.Next(next); !next.Equal(at(want)) {
+				t.Errorf("Every(%s).Next = %s, want %s", test.size, next.Format(time.RFC3339), want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseField(t *testing.T) {
+	bits := func(values ...int) uint64 {
+		var b uint64
+		for _, v := range values {
+			b |= 1 << uint(v)
+		}
+		return b
+	}
+	minute, dom, dow := fields[0], fields[2], fields[4]
+	tests := []struct {
+		part string
+		f    field
+		bits uint64
+	}{
+		{"*", minute, 1<<60 - 1},
+		{"*", dom, 1<<32 - 2},
+		{"5", minute, bits(5)},
+		{"1-3", minute, bits(1, 2, 3)},
+		{"1,15,30", dom, bits(1, 15, 30)},
+		{"*/15", minute, bits(0, 15, 30, 45)},
+		{"0-30/10", minute, bits(0, 10, 20, 30)},
+		{"5/20", minute, bits(5, 25, 45)},
+		{"1-5,0", dow, bits(0, 1, 2, 3, 4, 5)},
+		{"*/2,3", dow, bits(0, 2, 3, 4, 6)},
+	}
+	for _, test := range tests {
+		b, err := parseField(test.part, test.f)
+		if err != nil {
+			t.Errorf("parseField(%q, %s): %v", test.part, test.f.name, err)
+			continue
+		}
+		if b != test.bits {
+			t.Errorf("parseField(%q, %s) = %b, want %b", test.part, test.f.name, b, test.bits)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@yearly",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"1-5/ * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 7",
+		"30-10 * * * *",
+		"-1 * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"1,,2 * * * *",
+	} {
+		if s, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) = %v, want an error", spec, s)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	at := func(s string) time.Time {
+		if s == "" {
+			return time.Time{}
+		}
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		spec, from string
+		next       []string
+	}{
+		//Descriptors, with the next run always strictly after the time given.
+		{"@hourly", "2026-10-19T11:00:00Z", []string{"2026-10-19T12:00:00Z", "2026-10-19T13:00:00Z"}},
+		{" @DAILY ", "2026-10-19T10:00:00Z", []string{"2026-10-20T00:00:00Z", "2026-10-21T00:00:00Z"}},
+		{"@midnight", "2026-10-19T10:00:00Z", []string{"2026-10-20T00:00:00Z"}},
+		{"@weekly", "2026-10-19T10:00:00Z", []string{"2026-10-25T00:00:00Z", "2026-11-01T00:00:00Z"}},
+		{"@monthly", "2026-12-19T10:00:00Z", []string{"2027-01-01T00:00:00Z", "2027-02-01T00:00:00Z"}},
+		//Seconds are dropped and other time zones are read in UTC.
+		{"*/15 * * * *", "2026-10-19T10:20:30Z", []string{"2026-10-19T10:30:00Z", "2026-10-19T10:45:00Z", "2026-10-19T11:00:00Z"}},
+		{"0 * * * *", "2026-10-19T12:20:00+02:00", []string{"2026-10-19T11:00:00Z"}},
+		{"30 9 * * 1-5", "2026-10-23T10:00:00Z", []string{"2026-10-26T09:30:00Z", "2026-10-27T09:30:00Z"}},
+		//With only one of the day fields restricted both have to match.
+		{"0 0 * * 5", "2026-10-19T00:00:00Z", []string{"2026-10-23T00:00:00Z", "2026-10-30T00:00:00Z", "2026-11-06T00:00:00Z"}},
+		{"0 0 1 * *", "2026-10-19T00:00:00Z", []string{"2026-11-01T00:00:00Z", "2026-12-01T00:00:00Z"}},
+		//With both restricted either can match, the 1st of the month or a Friday.
+		{"0 0 1 * 5", "2026-10-25T00:00:00Z", []string{"2026-10-30T00:00:00Z", "2026-11-01T00:00:00Z", "2026-11-06T00:00:00Z"}},
+		//29 February only exists in leap years.
+		{"0 0 29 2 *", "2026-03-01T00:00:00Z", []string{"2028-02-29T00:00:00Z", "2032-02-29T00:00:00Z"}},
+		//Days that never exist give the zero time once maxSearch is reached.
+		{"0 0 30 2 *", "2026-10-19T00:00:00Z", []string{""}},
+		{"0 0 31 4,6,9,11 *", "2026-10-19T00:00:00Z", []string{""}},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.spec, err)
+			continue
+		}
+		next := at(test.from)
+		for _, want := range test.next {
+			if next = s.Next(next); !next.Equal(at(want)) {
+				t.Errorf("%q.Next = %s, want %q", test.spec, next.Format(time.RFC3339), want)
+				break
+			}
+		}
+	}
+}
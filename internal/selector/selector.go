@@ -0,0 +1,204 @@
+//Package selector reads Kubernetes label selectors and matches them against the labels exported by kube-state-metrics, so the workloads collected can be chosen by their labels.
+package selector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//operators of the requirements of a selector.
+const (
+	equals       = "="
+	notEquals    = "!="
+	in           = "in"
+	notIn        = "notin"
+	exists       = "exists"
+	doesNotExist = "!"
+)
+
+var (
+	//setRequirement matches the set based requirements, e.g. tier in (web, api).
+	setRequirement = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+	//keyPattern is a label key with its optional DNS prefix, e.g. app.kubernetes.io/part-of.
+	keyPattern = regexp.MustCompile(`^([a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*/)?[A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?$`)
+	//valuePattern is a label value, which can be empty.
+	valuePattern = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?)?$`)
+	//invalidChars are the characters kube-state-metrics replaces with an underscore in the label names.
+	invalidChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+//requirement is one of the comma separated conditions of a selector.
+type requirement struct {
+	key, operator string
+	values        []string
+	//label is the name of the Prometheus label kube-state-metrics exports the key as.
+	label string
+}
+
+//Selector holds the requirements of a label selector, all of which must be met for the labels to match.
+type Selector struct {
+	spec         string
+	requirements []requirement
+}
+
+//Parse reads a label selector in the Kubernetes syntax, e.g. "app.kubernetes.io/part-of=payments,tier in (web,api),!densify.com/exclude".
+//The requirements can be key=value, key==value, key!=value, key in (values), key notin (values), key to require the label and !key to require its absence. An empty selector matches everything.
+func Parse(spec string) (*Selector, error) {
+	s := &Selector{spec: spec}
+	for _, part := range split(spec) {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		r, err := parseRequirement(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector %q: %s", spec, err)
+		}
+		s.requirements = append(s.requirements, r)
+	}
+	return s, nil
+}
+
+//ParseAnnotation reads an annotation, as key=value, e.g. densify.com/exclude=true, or as key for the annotation with any value, and returns a Selector matching the annotations exported by kube-state-metrics that have it. An empty annotation gives an empty Selector.
+func ParseAnnotation(spec string) (*Selector, error) {
+	s := &Selector{spec: spec}
+	if spec = strings.TrimSpace(spec); spec == "" {
+		return s, nil
+	}
+	r, err := parseRequirement(spec)
+	if err == nil && r.operator != equals && r.operator != exists {
+		err = fmt.Errorf("it must be key=value or key")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid annotation %q: %s", spec, err)
+	}
+	r.label = Annotation(r.key)
+	s.requirements = append(s.requirements, r)
+	return s, nil
+}
+
+//split splits the selector on the commas that aren't in the value list of an in or notin requirement.
+func split(spec string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range spec {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, spec[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, spec[start:])
+}
+
+func parseRequirement(part string) (requirement, error) {
+	var r requirement
+	switch {
+	case setRequirement.MatchString(part):
+		m := setRequirement.FindStringSubmatch(part)
+		r.key, r.operator = m[1], m[2]
+		for _, value := range strings.Split(m[3], ",") {
+			r.values = append(r.values, strings.TrimSpace(value))
+		}
+	case strings.HasPrefix(part, "!") && !strings.Contains(part, "="):
+		r.key, r.operator = strings.TrimSpace(part[1:]), doesNotExist
+	case strings.Contains(part, "!="):
+		i := strings.Index(part, "!=")
+		r.key, r.operator, r.values = strings.TrimSpace(part[:i]), notEquals, []string{strings.TrimSpace(part[i+2:])}
+	case strings.Contains(part, "="):
+		i := strings.Index(part, "=")
+		value := strings.TrimPrefix(part[i+1:], "=")
+		r.key, r.operator, r.values = strings.TrimSpace(part[:i]), equals, []string{strings.TrimSpace(value)}
+	default:
+		r.key, r.operator = part, exists
+	}
+
+	if !keyPattern.MatchString(r.key) {
+		return r, fmt.Errorf("%q isn't a valid label key", r.key)
+	}
+	for _, value := range r.values {
+		if !valuePattern.MatchString(value) {
+			return r, fmt.Errorf("%q isn't a valid value of %s", value, r.key)
+		}
+	}
+	r.label = Label(r.key)
+	return r, nil
+}
+
+//Label returns the name of the Prometheus label kube-state-metrics exports the Kubernetes label key as, e.g. label_app_kubernetes_io_part_of for app.kubernetes.io/part-of.
+func Label(key string) string {
+	return "label_" + invalidChars.ReplaceAllString(key, "_")
+}
+
+//Annotation returns the name of the Prometheus label kube-state-metrics exports the Kubernetes annotation key as, e.g. annotation_densify_com_exclude for densify.com/exclude.
+func Annotation(key string) string {
+	return "annotation_" + invalidChars.ReplaceAllString(key, "_")
+}
+
+//Empty returns true if the selector has no requirements and so matches everything.
+func (s *Selector) Empty() bool {
+	return s == nil || len(s.requirements) == 0
+}
+
+//String returns the selector as it was given.
+func (s *Selector) String() string {
+	if s == nil {
+		return ""
+	}
+	return s.spec
+}
+
+//Matches returns true if the labels meet every requirement of the selector. The label maps are keyed by the Prometheus label names and the values of a label gathered from several series are separated by semicolons, as done by common.AddToLabelMap.
+//Each label is looked up in the maps in turn and the first map that has it is used, e.g. the labels of a controller and then those of its namespace, so a workload can override the label of its namespace.
+func (s *Selector) Matches(labels ...map[string]string) bool {
+	if s.Empty() {
+		return true
+	}
+	for _, r := range s.requirements {
+		values, found := lookup(r.label, labels)
+		if !r.matches(values, found) {
+			return false
+		}
+	}
+	return true
+}
+
+func lookup(label string, labels []map[string]string) ([]string, bool) {
+	for _, m := range labels {
+		if value, ok := m[label]; ok {
+			return strings.Split(value, ";"), true
+		}
+	}
+	return nil, false
+}
+
+//matches checks the requirement against the values of the label. A label with several values meets the = and in requirements if any of them is listed and the != and notin requirements if none of them is.
+func (r requirement) matches(values []string, found bool) bool {
+	switch r.operator {
+	case exists:
+		return found
+	case doesNotExist:
+		return !found
+	case equals, in:
+		return found && anyIn(values, r.values)
+	default:
+		return !found || !anyIn(values, r.values)
+	}
+}
+
+func anyIn(values, set []string) bool {
+	for _, v := range values {
+		for _, s := range set {
+			if v == s {
+				return true
+			}
+		}
+	}
+	return false
+}
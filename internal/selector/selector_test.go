@@ -0,0 +1,155 @@
+package selector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		spec  string
+		parts []string
+	}{
+		{"", []string{""}},
+		{"app=web", []string{"app=web"}},
+		{"app=web,tier!=db", []string{"app=web", "tier!=db"}},
+		{"tier in (a,b),app=web", []string{"tier in (a,b)", "app=web"}},
+		{"app=web, tier notin (a, b, c), !canary", []string{"app=web", " tier notin (a, b, c)", " !canary"}},
+	}
+	for _, test := range tests {
+		if parts := split(test.spec); !reflect.DeepEqual(parts, test.parts) {
+			t.Errorf("split(%q) = %q, want %q", test.spec, parts, test.parts)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec         string
+		requirements []requirement
+	}{
+		{"", nil},
+		{" , ", nil},
+		{"app=web", []requirement{{"app", equals, []string{"web"}, "label_app"}}},
+		{"app==web", []requirement{{"app", equals, []string{"web"}, "label_app"}}},
+		{"app = ", []requirement{{"app", equals, []string{""}, "label_app"}}},
+		{"app.kubernetes.io/part-of!=payments", []requirement{{"app.kubernetes.io/part-of", notEquals, []string{"payments"}, "label_app_kubernetes_io_part_of"}}},
+		{"tier in (web, api),env notin (dev)", []requirement{
+			{"tier", in, []string{"web", "api"}, "label_tier"},
+			{"env", notIn, []string{"dev"}, "label_env"},
+		}},
+		{"team, !densify.com/exclude", []requirement{
+			{"team", exists, nil, "label_team"},
+			{"densify.com/exclude", doesNotExist, nil, "label_densify_com_exclude"},
+		}},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(s.requirements, test.requirements) {
+			t.Errorf("Parse(%q) = %+v, want %+v", test.spec, s.requirements, test.requirements)
+		}
+		if s.String() != test.spec || s.Empty() != (test.requirements == nil) {
+			t.Errorf("Parse(%q) is %q and empty %v", test.spec, s.String(), s.Empty())
+		}
+	}
+
+	for _, spec := range []string{"app in (web", "app in web", "-app=web", "app=web!", "app=" + strings.Repeat("a", 64), "Example.com/app=web", "app in (web,-api)", "!"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q): no error", spec)
+		}
+	}
+}
+
+func TestParseAnnotation(t *testing.T) {
+	tests := []struct {
+		spec         string
+		requirements []requirement
+	}{
+		{"", nil},
+		{"densify.com/exclude=true", []requirement{{"densify.com/exclude", equals, []string{"true"}, "annotation_densify_com_exclude"}}},
+		{" densify.com/exclude ", []requirement{{"densify.com/exclude", exists, nil, "annotation_densify_com_exclude"}}},
+	}
+	for _, test := range tests {
+		s, err := ParseAnnotation(test.spec)
+		if err != nil {
+			t.Errorf("ParseAnnotation(%q): %v", test.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(s.requirements, test.requirements) {
+			t.Errorf("ParseAnnotation(%q) = %+v, want %+v", test.spec, s.requirements, test.requirements)
+		}
+	}
+
+	for _, spec := range []string{"=true", "densify.com/exclude!=true", "!densify.com/exclude", "densify.com/exclude in (true)", "densify.com/exclude=true,team=a"} {
+		if _, err := ParseAnnotation(spec); err == nil {
+			t.Errorf("ParseAnnotation(%q): no error", spec)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	tests := []struct {
+		key, label, annotation string
+	}{
+		{"app", "label_app", "annotation_app"},
+		{"app.kubernetes.io/part-of", "label_app_kubernetes_io_part_of", "annotation_app_kubernetes_io_part_of"},
+		{"densify.com/exclude", "label_densify_com_exclude", "annotation_densify_com_exclude"},
+		{"Team_Name", "label_Team_Name", "annotation_Team_Name"},
+	}
+	for _, test := range tests {
+		if label := Label(test.key); label != test.label {
+			t.Errorf("Label(%q) = %q, want %q", test.key, label, test.label)
+		}
+		if annotation := Annotation(test.key); annotation != test.annotation {
+			t.Errorf("Annotation(%q) = %q, want %q", test.key, annotation, test.annotation)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	controller := map[string]string{"label_app": "web", "label_tier": "frontend;cache"}
+	namespace := map[string]string{"label_app": "shop", "label_team": "payments", "label_env": "prod"}
+	tests := []struct {
+		spec    string
+		matches bool
+	}{
+		{"", true},
+		{"app=web", true},
+		//The label of the controller overrides that of its namespace.
+		{"app=shop", false},
+		//Labels the controller doesn't have are taken from its namespace.
+		{"team=payments,env in (prod,staging)", true},
+		{"team!=payments", false},
+		{"env notin (dev)", true},
+		//A label with several values matches if any of them is listed.
+		{"tier=cache", true},
+		{"tier in (db,frontend)", true},
+		{"tier!=cache", false},
+		{"tier notin (db)", true},
+		{"owner", false},
+		{"!owner", true},
+		{"!team", false},
+		{"owner!=alice,owner notin (bob)", true},
+		{"owner in (alice)", false},
+		{"app=web,team=marketing", false},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matches := s.Matches(controller, namespace); matches != test.matches {
+			t.Errorf("%q matches = %v, want %v", test.spec, matches, test.matches)
+		}
+	}
+
+	var s *Selector
+	if !s.Matches(controller) || !s.Empty() || s.String() != "" {
+		t.Error("a nil selector doesn't match everything")
+	}
+}
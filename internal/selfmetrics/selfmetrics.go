@@ -0,0 +1,147 @@
+//Package selfmetrics keeps the metrics the data collection reports about itself, such as the queries run, the rows written and how long the collections take, so problems show up before the data goes missing in Densify.
+package selfmetrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+//namespace is the prefix of the metric names.
+const namespace = "densify_collector"
+
+//Metrics holds the self metrics of the process. The methods used while collecting can be called on a nil Metrics, which records nothing, so collections that don't report metrics (e.g. through the library) don't have to check.
+type Metrics struct {
+	registry      *prometheus.Registry
+	queries       prometheus.Counter
+	queryDuration prometheus.Histogram
+	queryErrors   *prometheus.CounterVec
+	csvRows       *prometheus.GaugeVec
+	lastSuccess   prometheus.Gauge
+	runDuration   prometheus.Gauge
+
+	mu        sync.Mutex
+	lastRunOK bool
+	ran       bool
+}
+
+//New returns the metrics registered in a registry of their own.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		queries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "queries_total",
+			Help:      "Number of Prometheus queries run.",
+		}),
+		queryDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "query_duration_seconds",
+			Help:      "Time taken by the Prometheus queries.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		}),
+		queryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "query_errors_total",
+			Help:      "Number of Prometheus queries that failed or returned no data for a vital metric, by metric name.",
+		}, []string{"metric"}),
+		csvRows: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "csv_rows",
+			Help:      "Number of rows written to each csv file by the last collection, excluding the header.",
+		}, []string{"file"}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time the last successful collection finished.",
+		}),
+		runDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "run_duration_seconds",
+			Help:      "Time taken by the last collection.",
+		}),
+	}
+	m.registry.MustRegister(m.queries, m.queryDuration, m.queryErrors, m.csvRows, m.lastSuccess, m.runDuration)
+	return m
+}
+
+//ObserveQuery records a query for the metric that took d. Failed is set when the query returned an error or no data for a vital metric.
+func (m *Metrics) ObserveQuery(metric string, d time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+	m.queries.Inc()
+	m.queryDuration.Observe(d.Seconds())
+	if failed {
+		m.queryErrors.WithLabelValues(metric).Inc()
+	}
+}
+
+//StartRun clears the rows counted by the previous collection.
+func (m *Metrics) StartRun() {
+	if m == nil {
+		return
+	}
+	m.csvRows.Reset()
+}
+
+//AddRows records rows written to the csv file at path.
+func (m *Metrics) AddRows(path string, rows int) {
+	if m == nil {
+		return
+	}
+	m.csvRows.WithLabelValues(path).Add(float64(rows))
+}
+
+//EndRun records the end of a collection that started at start and whether it succeeded.
+func (m *Metrics) EndRun(start time.Time, ok bool) {
+	if m == nil {
+		return
+	}
+	now := time.Now()
+	m.runDuration.Set(now.Sub(start).Seconds())
+	if ok {
+		m.lastSuccess.Set(float64(now.Unix()))
+	}
+	m.mu.Lock()
+	m.ran, m.lastRunOK = true, ok
+	m.mu.Unlock()
+}
+
+//Handler serves /healthz, /readyz and the metrics on /metrics.
+//The process is healthy as long as it answers. It is ready unless the last collection failed, so it is also ready before the first collection has run.
+func (m *Metrics) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		ran, ok := m.ran, m.lastRunOK
+		m.mu.Unlock()
+		switch {
+		case !ran:
+			w.Write([]byte("ok, no collection has run yet\n"))
+		case ok:
+			w.Write([]byte("ok\n"))
+		default:
+			http.Error(w, "the last collection failed", http.StatusServiceUnavailable)
+		}
+	})
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+//WriteTextfile writes the metrics to path in the text format read by the textfile collector of the node exporter.
+func (m *Metrics) WriteTextfile(path string) error {
+	return prometheus.WriteToTextfile(path, m.registry)
+}
+
+//Push sends the metrics to the Pushgateway at url under the job, replacing the metrics pushed by the previous collection.
+func (m *Metrics) Push(url, job string) error {
+	return push.New(url, job).Gatherer(m.registry).Push()
+}
@@ -0,0 +1,230 @@
+//Package summary builds the run_summary.json report of a collection, with what was collected, what went wrong and the exit code of the run, so a collection that didn't get all the data can be detected without reading the log.
+package summary
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+)
+
+//Statuses of a run and their exit codes.
+const (
+	Success = "success"
+	Partial = "partial"
+	Failure = "failure"
+
+	SuccessCode = 0
+	FailureCode = 1
+	PartialCode = 2
+)
+
+//FileName is the name of the summary written to the output directory.
+const FileName = "run_summary.json"
+
+//Query is a query that failed or returned no data.
+type Query struct {
+	Cluster string `json:"cluster,omitempty"`
+	Metric  string `json:"metric"`
+	Query   string `json:"query"`
+	//Vital is set for the queries the entities are built from, without which the level can't be collected.
+	Vital bool   `json:"vital"`
+	Error string `json:"error,omitempty"`
+}
+
+//Exclusion is a workload left out of the collection because it, its pods or its namespace have the exclude annotation.
+type Exclusion struct {
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace"`
+	//Kind and Name are those of the controller, empty when the whole namespace was left out.
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name,omitempty"`
+	//Source is what has the annotation: pod, controller or namespace.
+	Source string `json:"source"`
+}
+
+//Summary is the report of a run. The methods used while collecting are safe to call from several goroutines and on a nil Summary, which records nothing.
+type Summary struct {
+	Version  string    `json:"version"`
+	Status   string    `json:"status"`
+	ExitCode int       `json:"exit_code"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration string    `json:"duration"`
+	//Clusters are the clusters collected.
+	Clusters []string `json:"clusters"`
+	//Entities is the number of entities of each kind collected, e.g. containers or nodes.
+	Entities map[string]int `json:"entities"`
+	//Files is the number of rows written to each csv file, excluding the header.
+	Files         map[string]int `json:"files"`
+	FailedQueries []Query        `json:"failed_queries"`
+	EmptyQueries  []Query        `json:"empty_queries"`
+	Warnings      []string       `json:"warnings"`
+	Errors        []string       `json:"errors"`
+	//Interrupted is why the run was stopped before it finished, e.g. the run timeout was exceeded.
+	Interrupted string `json:"interrupted,omitempty"`
+	//Incomplete are the levels of each cluster (e.g. east/container) that were skipped or cut short because the run was stopped.
+	Incomplete []string `json:"incomplete"`
+	//Excluded are the workloads left out of the collection by the exclude annotation.
+	Excluded []Exclusion `json:"excluded"`
+
+	mu sync.Mutex
+}
+
+//New returns the summary of a run starting now.
+func New(version string) *Summary {
+	return &Summary{
+		Version:       version,
+		Start:         time.Now().UTC(),
+		Clusters:      []string{},
+		Entities:      map[string]int{},
+		Files:         map[string]int{},
+		FailedQueries: []Query{},
+		EmptyQueries:  []Query{},
+		Warnings:      []string{},
+		Errors:        []string{},
+		Incomplete:    []string{},
+		Excluded:      []Exclusion{},
+	}
+}
+
+//AddCluster records a cluster that was collected.
+func (s *Summary) AddCluster(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Clusters = append(s.Clusters, name)
+}
+
+//AddEntities adds count entities of the kind.
+func (s *Summary) AddEntities(kind string, count int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Entities[kind] += count
+}
+
+//AddRows adds rows written to the csv file at path.
+func (s *Summary) AddRows(path string, rows int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Files[path] += rows
+}
+
+//FailedQuery records a query that returned an error.
+func (s *Summary) FailedQuery(q Query) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.FailedQueries = append(s.FailedQueries, q)
+}
+
+//EmptyQuery records a query that returned no data.
+func (s *Summary) EmptyQuery(q Query) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.EmptyQueries = append(s.EmptyQueries, q)
+}
+
+//Warning records a warning that isn't about a query, as those are recorded separately.
+func (s *Summary) Warning(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Warnings = append(s.Warnings, msg)
+}
+
+//Error records an error that isn't about a query.
+func (s *Summary) Error(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Errors = append(s.Errors, msg)
+}
+
+//AddIncomplete records a level of a cluster that wasn't completed because the run was stopped.
+func (s *Summary) AddIncomplete(level string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Incomplete = append(s.Incomplete, level)
+}
+
+//Exclude records a workload left out by the exclude annotation.
+func (s *Summary) Exclude(e Exclusion) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Excluded = append(s.Excluded, e)
+}
+
+//Interrupt records why the run was stopped before it finished.
+func (s *Summary) Interrupt(reason string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Interrupted = reason
+}
+
+//Finish sets the status and exit code of the run. The run failed if nothing was collected. It is partial if a level or cluster couldn't be collected (ok is false), the run was interrupted, a query failed, a vital query returned no data or, unless optionalAsWarning is set, an optional query returned no data.
+func (s *Summary) Finish(ok, optionalAsWarning bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.End = time.Now().UTC()
+	s.Duration = s.End.Sub(s.Start).String()
+	sort.Strings(s.Clusters)
+
+	collected := 0
+	for _, count := range s.Entities {
+		collected += count
+	}
+	partial := !ok || s.Interrupted != "" || len(s.FailedQueries) > 0
+	for _, q := range s.EmptyQueries {
+		if q.Vital || !optionalAsWarning {
+			partial = true
+		}
+	}
+
+	switch {
+	case collected == 0:
+		s.Status, s.ExitCode = Failure, FailureCode
+	case partial:
+		s.Status, s.ExitCode = Partial, PartialCode
+	default:
+		s.Status, s.ExitCode = Success, SuccessCode
+	}
+}
+
+//Write writes the summary as JSON to path.
+func (s *Summary) Write(path string) error {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
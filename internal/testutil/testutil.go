@@ -0,0 +1,16 @@
+//Package testutil holds the helpers shared by the tests of the collectors.
+package testutil
+
+import "github.com/prometheus/common/model"
+
+//Series returns a series with the labels, given as name and value pairs, and a sample 5 minutes apart for each value.
+func Series(labels []string, values ...float64) *model.SampleStream {
+	s := &model.SampleStream{Metric: model.Metric{}}
+	for i := 0; i+1 < len(labels); i += 2 {
+		s.Metric[model.LabelName(labels[i])] = model.LabelValue(labels[i+1])
+	}
+	for i, v := range values {
+		s.Values = append(s.Values, model.SamplePair{Timestamp: model.Time(i * 300000), Value: model.SampleValue(v)})
+	}
+	return s
+}
@@ -0,0 +1,120 @@
+//Package watermark keeps the high-water mark of each workload file, the end of the last window written to it, so incremental collections only query the data since the previous collection and catch up on the collections that were missed.
+package watermark
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Marks are the high-water marks saved to the state file. The state file is either a JSON object of the marks or, when a ConfigMap name is given, a ConfigMap holding the marks in its data so it can be applied with kubectl and mounted into the next collection. Either format is read.
+//The methods can be called on nil Marks, which is how collections that aren't incremental collect the full history.
+type Marks struct {
+	path, configMap string
+	marks           map[string]time.Time
+	mu              sync.Mutex
+}
+
+//configMap is the ConfigMap the marks are saved in.
+type configMap struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+}
+
+//New returns marks saved to path, written as a ConfigMap of the name given unless it is empty, without any marks.
+func New(path, configMapName string) *Marks {
+	return &Marks{path: path, configMap: configMapName, marks: map[string]time.Time{}}
+}
+
+//Load reads the marks saved to path. There are no marks if the state file doesn't exist yet.
+func Load(path, configMapName string) (*Marks, error) {
+	m := New(path, configMapName)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	var cm configMap
+	if err := json.Unmarshal(data, &cm); err == nil && cm.Kind == "ConfigMap" {
+		values = cm.Data
+	} else if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, err
+		}
+		m.marks[key] = t
+	}
+	return m, nil
+}
+
+//Key returns the key of the mark of a workload file of the cluster. Only the characters allowed in ConfigMap keys are kept, the others are replaced with _.
+func Key(cluster, entityKind, fileName string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' || r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, cluster+"."+entityKind+"."+fileName)
+}
+
+//Get returns the mark of the key and false if the file hasn't been collected yet.
+func (m *Marks) Get(key string) (time.Time, bool) {
+	if m == nil {
+		return time.Time{}, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.marks[key]
+	return t, ok
+}
+
+//Set moves the mark of the key to t.
+func (m *Marks) Set(key string, t time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.marks[key] = t.UTC()
+}
+
+//Save writes the marks to the state file through a temporary file, so a crash while saving leaves the previous marks.
+func (m *Marks) Save() error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	values := map[string]string{}
+	for key, t := range m.marks {
+		values[key] = t.Format(time.RFC3339)
+	}
+	m.mu.Unlock()
+
+	var v interface{} = values
+	if m.configMap != "" {
+		cm := configMap{APIVersion: "v1", Kind: "ConfigMap", Data: values}
+		cm.Metadata.Name = m.configMap
+		v = cm
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(m.path+".tmp", append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(m.path+".tmp", m.path)
+}
@@ -0,0 +1,120 @@
+package watermark
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		cluster, entityKind, fileName, key string
+	}{
+		{"east", "node", "cpu_utilization.csv", "east.node.cpu_utilization.csv"},
+		{"prod-eu-1", "container", "hpa_extra_metric.csv", "prod-eu-1.container.hpa_extra_metric.csv"},
+		{"https://prom:9090/", "cluster", "mem/total.csv", "https___prom_9090_.cluster.mem_total.csv"},
+		{"cluster é 1", "node_group", "cpu.csv", "cluster___1.node_group.cpu.csv"},
+	}
+	for _, test := range tests {
+		if key := Key(test.cluster, test.entityKind, test.fileName); key != test.key {
+			t.Errorf("Key(%q, %q, %q) = %q, want %q", test.cluster, test.entityKind, test.fileName, key, test.key)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watermark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mark := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name, data string
+		ok         bool
+	}{
+		{"plain", `{"east.node.cpu.csv": "2024-03-01T12:00:00Z"}`, true},
+		{"config map", `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "marks"}, "data": {"east.node.cpu.csv": "2024-03-01T13:00:00+01:00"}}`, true},
+		{"empty config map", `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "marks"}}`, false},
+		{"bad time", `{"east.node.cpu.csv": "yesterday"}`, false},
+		{"bad json", `{"east.node.cpu.csv": `, false},
+	}
+	for _, test := range tests {
+		path := dir + "/" + strings.Replace(test.name, " ", "_", -1) + ".json"
+		if err := ioutil.WriteFile(path, []byte(test.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		m, err := Load(path, "")
+		if strings.HasPrefix(test.name, "bad") {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got, ok := m.Get("east.node.cpu.csv"); ok != test.ok || (ok && !got.Equal(mark)) {
+			t.Errorf("%s: mark = %s, %v, want %s, %v", test.name, got, ok, mark, test.ok)
+		}
+	}
+
+	m, err := Load(dir+"/missing.json", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Get("east.node.cpu.csv"); ok {
+		t.Error("mark loaded from a missing state file")
+	}
+}
+
+func TestSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watermark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mark := time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+
+	//Both formats are read back whatever format the marks are loaded with.
+	for _, configMapName := range []string{"", "marks"} {
+		path := dir + "/state" + configMapName + ".json"
+		m := New(path, configMapName)
+		m.Set("east.node.cpu.csv", mark)
+		if err := m.Save(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isConfigMap := strings.Contains(string(data), `"kind": "ConfigMap"`); isConfigMap != (configMapName != "") {
+			t.Errorf("state file for the ConfigMap name %q is a ConfigMap: %v\n%s", configMapName, isConfigMap, data)
+		}
+		if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("temporary file left after saving: %v", err)
+		}
+		loaded, err := Load(path, "other")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := loaded.Get("east.node.cpu.csv"); !ok || !got.Equal(mark) || got.Location() != time.UTC {
+			t.Errorf("loaded mark = %s, %v, want %s in UTC", got, ok, mark.UTC())
+		}
+	}
+}
+
+func TestNil(t *testing.T) {
+	var m *Marks
+	m.Set("east.node.cpu.csv", time.Now())
+	if _, ok := m.Get("east.node.cpu.csv"); ok {
+		t.Error("nil marks have a mark")
+	}
+	if err := m.Save(); err != nil {
+		t.Error(err)
+	}
+}
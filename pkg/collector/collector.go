@@ -0,0 +1,280 @@
+//Package collector is the public API for embedding the data collection in other tools. It queries Prometheus for the containers, nodes, node groups and cluster of a Kubernetes cluster and returns them as the typed entities of the entity package.
+//
+//The API follows semantic versioning, see Version. The csv files sent to Densify are written by the writer package from the Result returned by Collect.
+package collector
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/cluster"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/container2"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/logging"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/namespace"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/node"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/nodegroup"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/selector"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/entity"
+	"github.com/prometheus/common/model"
+)
+
+//Version is the semantic version of the public API made up of the collector, entity and writer packages. The major version changes when exported identifiers are removed or change in a way that breaks callers, the minor version when new ones are added.
+const Version = "2.0.0"
+
+//Level is a level of the cluster hierarchy that can be collected. The values match the entries of the include_list setting.
+type Level string
+
+//Levels that can be collected.
+const (
+	Container Level = "container"
+	Namespace Level = "namespace"
+	Node      Level = "node"
+	NodeGroup Level = "nodegroup"
+	Cluster   Level = "cluster"
+)
+
+//Options controls what is collected and how Prometheus is queried. Only URL is required.
+type Options struct {
+	//URL of the Prometheus server, e.g. http://prometheus-server.monitoring:9090.
+	URL string
+	//ClusterName is the name the entities are reported under. If it is empty the name is taken from the cluster label in Prometheus, falling back to the host of URL.
+	ClusterName string
+	//ClusterLabel is the label holding the cluster name in Prometheus. Defaults to cluster.
+	ClusterLabel string
+	//ClusterMatcher is added to every query, e.g. cluster="east", so only that cluster is collected when Prometheus holds several.
+	ClusterMatcher string
+	//NamespaceInclude and NamespaceExclude are the namespaces the containers are collected from and those they aren't, as exact names or regular expressions such as kube-.*. All namespaces are collected if both are empty.
+	NamespaceInclude, NamespaceExclude []string
+	//NamespaceFilterNodes only counts the containers of the namespaces collected in the requests and limits of the nodes, node groups and cluster.
+	NamespaceFilterNodes bool
+	//LabelSelector is a Kubernetes label selector, e.g. app.kubernetes.io/part-of=payments, the workloads must match to be collected. It is checked against the labels of their pods and controllers, falling back to those of their namespace.
+	LabelSelector string
+	//ExcludeAnnotation is an annotation, e.g. densify.com/exclude=true, or an annotation key for any value, that opts the workloads out of the collection when their pods, controllers or namespace have it. Every workload is collected if it is empty.
+	ExcludeAnnotation string
+
+	//Interval is days, hours or minutes. Defaults to hours.
+	Interval string
+	//IntervalSize is the number of intervals collected per history window. Defaults to 1.
+	IntervalSize int
+	//History is the number of history windows collected for the workloads. Defaults to 1.
+	History int
+	//Offset moves the collection back by this many intervals.
+	Offset int
+	//SampleRate is the step in minutes between the workload samples. Defaults to 5.
+	SampleRate int
+	//Step is the step between the workload samples, used instead of SampleRate when set so steps under a minute can be used.
+	Step time.Duration
+	//RateWindow is the window of the rates calculated by the queries. Defaults to the step.
+	RateWindow time.Duration
+	//CurrentTime is the end of the collection. Defaults to now, aligned to the start of the interval.
+	CurrentTime time.Time
+
+	//BearerTokenFile holds the token sent to Prometheus.
+	BearerTokenFile string
+	//CACertFile holds the certificate authority used to verify Prometheus.
+	CACertFile string
+	//OAuth2 client credentials used instead of the bearer token when OAuth2TokenURL is set.
+	OAuth2TokenURL, OAuth2ClientID, OAuth2ClientSecretFile string
+	OAuth2Scopes                                           []string
+
+	//Levels to collect. Defaults to all of them except Namespace, which is only collected when listed.
+	Levels []Level
+	//Workloads collects the workloads of the levels, the metrics over time that are written to the workload csv files. They are skipped unless it is set.
+	Workloads bool
+	//LogOutput receives the log records in logfmt. They are discarded if it is nil.
+	LogOutput io.Writer
+	//Debug adds debug messages to the log.
+	Debug bool
+}
+
+//Result holds the entities collected. Fields for levels that weren't collected are nil. The entity labels hold every label found, the label filters of the writer are only applied when the files are written.
+type Result struct {
+	//ClusterName is the name the entities were collected under.
+	ClusterName string
+	Cluster     *entity.Cluster
+	Namespaces  map[string]*entity.Namespace
+	//NamespaceSettings are the namespaces collected as entities of their own by the Namespace level.
+	NamespaceSettings map[string]*entity.NamespaceSettings
+	//HPAs are the horizontal pod autoscalers that don't scale any of the controllers found.
+	HPAs       map[string]*entity.HPA
+	Nodes      map[string]*entity.Node
+	NodeGroups map[string]*entity.NodeGroup
+	//Workloads are the workloads of the levels collected, when Options.Workloads is set.
+	Workloads []*entity.Workload
+}
+
+//Collect queries Prometheus for the levels in the options and returns the entities found.
+//Levels that can't be collected are left out of the result and reported in the error, so a partial result can be returned along with an error.
+func Collect(ctx context.Context, opts Options) (*Result, error) {
+	args, err := parameters(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	levels := map[Level]bool{}
+	for _, level := range opts.Levels {
+		levels[level] = true
+	}
+	all := len(levels) == 0
+
+	if *args.ClusterName == "" {
+		label := opts.ClusterLabel
+		if label == "" {
+			label = "cluster"
+		}
+		*args.ClusterName = common.DetectClusterName(args, label)
+	}
+	if *args.ClusterName == "" {
+		*args.ClusterName = *args.PromAddress
+	}
+
+	result := &Result{ClusterName: *args.ClusterName}
+	var failed []string
+	var workloads []*common.Workload
+	if all || levels[Container] {
+		if r := container2.NewCollector(args).Collect(); r != nil {
+			result.Namespaces, result.HPAs = r.Namespaces, r.HPAs
+			workloads = append(workloads, r.Workloads...)
+		} else {
+			failed = append(failed, string(Container))
+		}
+	}
+	if levels[Namespace] {
+		var w []*common.Workload
+		if result.NamespaceSettings, w = namespace.NewCollector(args).Collect(); result.NamespaceSettings == nil {
+			failed = append(failed, string(Namespace))
+		}
+		workloads = append(workloads, w...)
+	}
+	if all || levels[Node] {
+		var w []*common.Workload
+		if result.Nodes, w = node.NewCollector(args).Collect(); result.Nodes == nil {
+			failed = append(failed, string(Node))
+		}
+		workloads = append(workloads, w...)
+	}
+	if all || levels[NodeGroup] {
+		//Clusters without node groups return nil, which isn't a failure.
+		var w []*common.Workload
+		result.NodeGroups, w = nodegroup.NewCollector(args).Collect()
+		workloads = append(workloads, w...)
+	}
+	if all || levels[Cluster] {
+		var w []*common.Workload
+		result.Cluster, w = cluster.NewCollector(args).Collect()
+		workloads = append(workloads, w...)
+	}
+	for _, w := range workloads {
+		result.Workloads = append(result.Workloads, w.Workload)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if len(failed) > 0 {
+		return result, errors.New("unable to collect " + strings.Join(failed, ", ") + " data, see the log for details")
+	}
+	return result, nil
+}
+
+//parameters converts the options to the parameters used by the collectors, filling in the defaults.
+func parameters(ctx context.Context, opts Options) (*common.Parameters, error) {
+	if opts.URL == "" {
+		return nil, errors.New("the Prometheus URL is required")
+	}
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, errors.New("invalid Prometheus URL " + opts.URL + ": " + err.Error())
+	}
+	promURL, promAddress := strings.TrimSuffix(opts.URL, "/"), u.Hostname()
+
+	interval := opts.Interval
+	if interval == "" {
+		interval = "hours"
+	}
+	if err := common.CheckInterval(interval); err != nil {
+		return nil, err
+	}
+	intervalSize, history, offset, sampleRate := opts.IntervalSize, opts.History, opts.Offset, opts.SampleRate
+	if intervalSize <= 0 {
+		intervalSize = 1
+	}
+	if history <= 0 {
+		history = 1
+	}
+	if sampleRate <= 0 {
+		sampleRate = 5
+	}
+	step := opts.Step
+	if step <= 0 {
+		step = time.Duration(sampleRate) * time.Minute
+	}
+	rateWindow := opts.RateWindow
+	if rateWindow <= 0 {
+		rateWindow = step
+	}
+	currentTime := opts.CurrentTime
+	if currentTime.IsZero() {
+		currentTime = common.AlignTime(time.Now().UTC(), interval, common.IntervalDuration(interval, offset))
+	}
+
+	logOutput := opts.LogOutput
+	if logOutput == nil {
+		logOutput = ioutil.Discard
+	}
+	level := logging.InfoLevel
+	if opts.Debug {
+		level = logging.DebugLevel
+	}
+	logger, err := logging.New(logOutput, logging.Logfmt, level)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaceMatcher, err := common.NamespaceMatcher(opts.NamespaceInclude, opts.NamespaceExclude)
+	if err != nil {
+		return nil, err
+	}
+	labelSelector, err := selector.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	excludeAnnotation, err := selector.ParseAnnotation(opts.ExcludeAnnotation)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterName := opts.ClusterName
+	return &common.Parameters{
+		ClusterName:            &clusterName,
+		PromURL:                &promURL,
+		PromAddress:            &promAddress,
+		Interval:               &interval,
+		IntervalSize:           common.IntervalDuration(interval, intervalSize),
+		History:                &history,
+		Offset:                 common.IntervalDuration(interval, offset),
+		CurrentTime:            &currentTime,
+		Logger:                 logger,
+		SampleRate:             step,
+		RateWindow:             model.Duration(rateWindow).String(),
+		OAuthTokenPath:         opts.BearerTokenFile,
+		CaCertPath:             opts.CACertFile,
+		OAuth2TokenURL:         opts.OAuth2TokenURL,
+		OAuth2ClientID:         opts.OAuth2ClientID,
+		OAuth2ClientSecretPath: opts.OAuth2ClientSecretFile,
+		OAuth2Scopes:           opts.OAuth2Scopes,
+		ClusterMatcher:         opts.ClusterMatcher,
+		NamespaceMatcher:       namespaceMatcher,
+		NamespaceFilterNodes:   opts.NamespaceFilterNodes,
+		Selector:               labelSelector,
+		ExcludeAnnotation:      excludeAnnotation,
+		SkipWorkloads:          !opts.Workloads,
+		Context:                ctx,
+	}, nil
+}
@@ -0,0 +1,111 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParametersDefaults(t *testing.T) {
+	before := time.Now().UTC()
+	args, err := parameters(context.Background(), Options{URL: "http://prometheus.monitoring:9090/"})
+	after := time.Now().UTC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *args.PromURL != "http://prometheus.monitoring:9090" || *args.PromAddress != "prometheus.monitoring" {
+		t.Errorf("Prometheus URL %s and address %s, want the URL without the trailing slash and the host", *args.PromURL, *args.PromAddress)
+	}
+	if *args.Interval != "hours" || args.IntervalSize != time.Hour || *args.History != 1 || args.Offset != 0 {
+		t.Errorf("interval %s of %s with a history of %d and offset %s, want an hour with a history of 1", *args.Interval, args.IntervalSize, *args.History, args.Offset)
+	}
+	if args.SampleRate != 5*time.Minute || args.RateWindow != "5m" {
+		t.Errorf("step %s and rate window %s, want 5m for both", args.SampleRate, args.RateWindow)
+	}
+	if !args.CurrentTime.Equal(before.Truncate(time.Hour)) && !args.CurrentTime.Equal(after.Truncate(time.Hour)) {
+		t.Errorf("current time %s, want the start of the hour", args.CurrentTime)
+	}
+	if *args.ClusterName != "" || !args.SkipWorkloads || args.OutputDir != "" {
+		t.Errorf("cluster name %q, skip workloads %v and output directory %q, want the name detected later and no workloads or files", *args.ClusterName, args.SkipWorkloads, args.OutputDir)
+	}
+}
+
+func TestParametersStep(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       Options
+		step       time.Duration
+		rateWindow string
+	}{
+		{"sample rate", Options{SampleRate: 2}, 2 * time.Minute, "2m"},
+		{"step over sample rate", Options{SampleRate: 2, Step: 30 * time.Second}, 30 * time.Second, "30s"},
+		{"rate window", Options{Step: 30 * time.Second, RateWindow: 2 * time.Minute}, 30 * time.Second, "2m"},
+	}
+	for _, test := range tests {
+		test.opts.URL = "http://localhost:9090"
+		args, err := parameters(context.Background(), test.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if args.SampleRate != test.step || args.RateWindow != test.rateWindow {
+			t.Errorf("%s: step %s and rate window %s, want %s and %s", test.name, args.SampleRate, args.RateWindow, test.step, test.rateWindow)
+		}
+	}
+}
+
+func TestParametersOptions(t *testing.T) {
+	currentTime := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	args, err := parameters(context.Background(), Options{URL: "http://localhost:9090", ClusterName: "east", Interval: "days", IntervalSize: 2, History: 7, Offset: 1, CurrentTime: currentTime, Workloads: true, LabelSelector: "app=web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *args.ClusterName != "east" || *args.Interval != "days" || args.IntervalSize != 48*time.Hour || *args.History != 7 || args.Offset != 24*time.Hour {
+		t.Errorf("cluster %s with interval %s of %s, history %d and offset %s", *args.ClusterName, *args.Interval, args.IntervalSize, *args.History, args.Offset)
+	}
+	if !args.CurrentTime.Equal(currentTime) || args.SkipWorkloads || args.Selector.String() != "app=web" {
+		t.Errorf("current time %s, skip workloads %v and selector %s", args.CurrentTime, args.SkipWorkloads, args.Selector)
+	}
+}
+
+func TestParametersErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"no URL", Options{}},
+		{"bad URL", Options{URL: "http://[::1"}},
+		{"bad interval", Options{URL: "http://localhost:9090", Interval: "weeks"}},
+		{"bad namespace", Options{URL: "http://localhost:9090", NamespaceInclude: []string{"kube-("}}},
+		{"bad selector", Options{URL: "http://localhost:9090", LabelSelector: "app in (web"}},
+		{"bad exclude annotation", Options{URL: "http://localhost:9090", ExcludeAnnotation: "=true"}},
+	}
+	for _, test := range tests {
+		if _, err := parameters(context.Background(), test.opts); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
+
+func TestCollectClusterNameFallback(t *testing.T) {
+	//Prometheus has neither an external label nor series with the cluster label, so the name falls back to the host of the URL.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resultType := "vector"
+		if strings.HasSuffix(r.URL.Path, "/query_range") {
+			resultType = "matrix"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"` + resultType + `","result":[]}}`))
+	}))
+	defer server.Close()
+
+	result, _ := Collect(context.Background(), Options{URL: server.URL, Levels: []Level{Cluster}})
+	if result == nil || result.ClusterName != "127.0.0.1" {
+		t.Fatalf("result %+v, want the cluster named after the host 127.0.0.1", result)
+	}
+	if result.Cluster == nil || result.Nodes != nil || result.Namespaces != nil || result.Workloads != nil {
+		t.Errorf("result %+v, want only the cluster level without workloads", result)
+	}
+}
@@ -0,0 +1,103 @@
+//Package entity holds the entities gathered by the collectors that are written out to the csv files sent to Densify.
+package entity
+
+import "time"
+
+//Numeric fields are set to -1 when the value wasn't found in Prometheus so the writers can leave them blank.
+
+//Namespace holds the settings of a namespace and the controllers running in it.
+type Namespace struct {
+	Name                                       string
+	Controllers                                map[string]*Controller
+	CPULimit, CPURequest, MemLimit, MemRequest int
+	Labels                                     map[string]string
+}
+
+//NamespaceSettings holds the settings of a namespace collected at the namespace level, along with the totals of its running containers.
+//The LimitRange fields are the constraints of the containers (min, max, default and defaultRequest) and the quota fields the lowest hard limit of the ResourceQuotas of the namespace. CPU values are in mCores and memory values in MB.
+type NamespaceSettings struct {
+	Name   string
+	Labels map[string]string
+
+	CPULimit, CPURequest, MemLimit, MemRequest                                            int
+	LimitRangeCPUMin, LimitRangeCPUMax, LimitRangeCPUDefault, LimitRangeCPUDefaultRequest int
+	LimitRangeMemMin, LimitRangeMemMax, LimitRangeMemDefault, LimitRangeMemDefaultRequest int
+	QuotaCPURequest, QuotaCPULimit, QuotaMemRequest, QuotaMemLimit, QuotaPods             int
+}
+
+//Controller is the highest owner of a set of containers, e.g. a Deployment, CronJob or StatefulSet or a Pod that has no owner.
+//Controllers are keyed by kind and name (e.g. Deployment__web) within their namespace.
+type Controller struct {
+	Name, Kind            string
+	Containers            map[string]*Container
+	CurrentSize, Restarts int
+	CreationTime          int64
+	Labels                map[string]string
+}
+
+//Container holds the settings of a container of a controller.
+type Container struct {
+	Name                                                                     string
+	Memory, CPULimit, CPURequest, MemLimit, MemRequest, Restarts, PowerState int
+	Labels                                                                   map[string]string
+}
+
+//HPA is a horizontal pod autoscaler that doesn't scale any of the controllers found.
+type HPA struct {
+	Name, Namespace string
+	Labels          map[string]string
+}
+
+//Node holds the settings of a node.
+type Node struct {
+	Name   string
+	Labels map[string]string
+
+	NetSpeedBytes, CPUCapacity, MemCapacity, EphemeralStorageCapacity, PodsCapacity, HugePages2MiCapacity int
+	CPUAllocatable, MemAllocatable, EphemeralStorageAllocatable, PodsAllocatable, HugePages2MiAllocatable int
+	CPULimit, CPURequest, MemLimit, MemRequest                                                            int
+}
+
+//NodeGroup holds the settings of a group of nodes, e.g. an EKS node group, GKE node pool or AKS agent pool.
+type NodeGroup struct {
+	Name                                                                              string
+	Nodes                                                                             []string
+	CPULimit, CPURequest, CPUCapacity, MemLimit, MemRequest, MemCapacity, CurrentSize int
+	Labels                                                                            map[string]string
+}
+
+//Cluster holds the totals for the cluster.
+type Cluster struct {
+	Name                                       string
+	CPULimit, CPURequest, MemLimit, MemRequest int
+}
+
+//Workload holds a workload metric collected by history window, written out to a workload csv file of the kind of entity, e.g. container/cpu_mCores_workload.csv.
+type Workload struct {
+	//Kind is the kind of entity the file is written for and Name the name of the file.
+	Kind, Name string
+	//Columns name the values that identify the series, e.g. namespace, entity_name, entity_type and container, and Metric names their values.
+	Columns []string
+	Metric  string
+	//Windows are the history windows collected, the newest first.
+	Windows []*WorkloadWindow
+}
+
+//WorkloadWindow holds the series of a history window. Collected is false if a query of the window failed, so the series may be incomplete.
+type WorkloadWindow struct {
+	Start, End time.Time
+	Collected  bool
+	Series     []*Series
+}
+
+//Series holds the samples of an entity, identified by the values of the columns of the workload.
+type Series struct {
+	Keys    []string
+	Samples []Sample
+}
+
+//Sample is a value of a series at a point in time.
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
@@ -0,0 +1,90 @@
+//Package writer writes the entities returned by collector.Collect to the config and attributes csv files that are sent to Densify.
+package writer
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/cluster"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/common"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/container2"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/logging"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/namespace"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/node"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/internal/nodegroup"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/collector"
+)
+
+//Options controls where the csv files are written.
+type Options struct {
+	//Dir is the directory the files are written to, with a sub directory per entity type (e.g. container, node).
+	Dir string
+	//LogOutput receives the records about files that couldn't be written, in logfmt. They are discarded if it is nil.
+	LogOutput io.Writer
+	//LabelColumns maps labels to columns of their own in the attributes, as label=column optionally prefixed with the kind of entity (container, pod, namespace or node) the label is looked up in, e.g. label_team=Business Unit or namespace:label_owner=Application Owner.
+	LabelColumns []string
+	//LabelAllow and LabelDeny choose the labels and annotations written to the labels attributes, as regular expressions optionally prefixed with the kind of entity, e.g. pod:label_team or annotation_.*. The labels added by Prometheus, e.g. instance and job, are dropped unless LabelKeepInternal is set. They don't apply to the LabelColumns.
+	LabelAllow, LabelDeny []string
+	LabelKeepInternal     bool
+}
+
+//Write creates the config and attributes files for the entities in the result, and the workload files for its workloads. Levels that are nil in the result are skipped.
+//Existing files in the directory are overwritten.
+func Write(result *collector.Result, opts Options) error {
+	if result == nil {
+		return errors.New("no result to write")
+	}
+	if opts.Dir == "" {
+		return errors.New("the output directory is required")
+	}
+	if err := os.MkdirAll(opts.Dir, 0777); err != nil {
+		return err
+	}
+
+	logOutput := opts.LogOutput
+	if logOutput == nil {
+		logOutput = ioutil.Discard
+	}
+	logger, err := logging.New(logOutput, logging.Logfmt, logging.InfoLevel)
+	if err != nil {
+		return err
+	}
+	labelColumns, err := common.NewLabelColumns(opts.LabelColumns)
+	if err != nil {
+		return err
+	}
+	labelFilter, err := common.NewLabelFilter(opts.LabelAllow, opts.LabelDeny, opts.LabelKeepInternal)
+	if err != nil {
+		return err
+	}
+	clusterName := result.ClusterName
+	args := &common.Parameters{
+		ClusterName:  &clusterName,
+		Logger:       logger,
+		OutputDir:    opts.Dir,
+		LabelColumns: labelColumns,
+		LabelFilter:  labelFilter,
+	}
+
+	if result.Namespaces != nil {
+		container2.Write(args, &container2.Result{Namespaces: result.Namespaces, HPAs: result.HPAs})
+	}
+	if result.NamespaceSettings != nil {
+		namespace.Write(args, result.NamespaceSettings, nil)
+	}
+	if result.Nodes != nil {
+		node.Write(args, result.Nodes, nil)
+	}
+	if result.NodeGroups != nil {
+		nodegroup.Write(args, result.NodeGroups, nil)
+	}
+	if result.Cluster != nil {
+		cluster.Write(args, result.Cluster, nil)
+	}
+	for _, workload := range result.Workloads {
+		common.WriteWorkload(args, common.WrapWorkload(workload))
+	}
+	return nil
+}
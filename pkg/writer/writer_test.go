@@ -0,0 +1,118 @@
+package writer_test
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/collector"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/entity"
+	"github.com/densify-dev/Container-Optimization-Data-Forwarder/pkg/writer"
+)
+
+//testResult returns a result with the deployment web of the namespace ns1 running the container app, the node n1, the cluster east and a workload of the node.
+func testResult() *collector.Result {
+	app := &entity.Container{Name: "app", Memory: 512, CPULimit: 500, CPURequest: 250, MemLimit: 1024, MemRequest: 512, Labels: map[string]string{"label_team": "payments"}}
+	web := &entity.Controller{Name: "web", Kind: "Deployment", Containers: map[string]*entity.Container{"app": app}, CurrentSize: 2, CreationTime: -1, Labels: map[string]string{"label_team": "payments", "instance": "10.0.0.1:8080"}}
+	return &collector.Result{
+		ClusterName: "east",
+		Namespaces: map[string]*entity.Namespace{
+			"ns1": {Name: "ns1", Controllers: map[string]*entity.Controller{"Deployment__web": web}, CPULimit: -1, CPURequest: -1, MemLimit: -1, MemRequest: -1, Labels: map[string]string{}},
+		},
+		Nodes: map[string]*entity.Node{
+			"n1": {Name: "n1", Labels: map[string]string{}, NetSpeedBytes: -1, CPUCapacity: 4, MemCapacity: 16384, EphemeralStorageCapacity: -1, PodsCapacity: 110, HugePages2MiCapacity: -1,
+				CPUAllocatable: -1, MemAllocatable: -1, EphemeralStorageAllocatable: -1, PodsAllocatable: -1, HugePages2MiAllocatable: -1, CPULimit: -1, CPURequest: -1, MemLimit: -1, MemRequest: -1},
+		},
+		Cluster: &entity.Cluster{Name: "east", CPULimit: 1000, CPURequest: 500, MemLimit: -1, MemRequest: -1},
+		Workloads: []*entity.Workload{{
+			Kind: "node", Name: "cpu_utilization", Columns: []string{"node"}, Metric: "CPU Utilization",
+			Windows: []*entity.WorkloadWindow{{Collected: true, Series: []*entity.Series{{Keys: []string{"n1"}, Samples: []entity.Sample{{Time: time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local), Value: 12.5}}}}}},
+		}},
+	}
+}
+
+//readLines returns the lines of the file in the directory.
+func readLines(t *testing.T, dir, file string) []string {
+	b, err := ioutil.ReadFile(dir + "/" + file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := writer.Options{Dir: dir, LabelColumns: []string{"label_team=Business Unit"}, LabelDeny: []string{"container:label_team"}}
+	if err := writer.Write(testResult(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	config := readLines(t, dir, "container/config.csv")
+	if want := []string{"cluster,namespace,entity_name,entity_type,container,HW Total Memory,OS Name,HW Manufacturer", "east,ns1,web,Deployment,app,512,Linux,CONTAINERS"}; strings.Join(config, "\n") != strings.Join(want, "\n") {
+		t.Errorf("container config = %q, want %q", config, want)
+	}
+
+	attributes := readLines(t, dir, "container/attributes.csv")
+	if len(attributes) != 2 {
+		t.Fatalf("container attributes = %q, want a header and a row", attributes)
+	}
+	if !strings.HasPrefix(attributes[0], "cluster,namespace,entity_name,entity_type,container,Virtual Technology,") || !strings.HasSuffix(attributes[0], ",Business Unit") {
+		t.Errorf("container attributes header = %q, want the label column last", attributes[0])
+	}
+	//The container label is denied and the instance label of the pod is internal, so only the pod label is written, while the label column still sees the label.
+	if want := "east,ns1,web,Deployment,app,Containers,east,ns1,web,,label_team : payments|,500,250,1024,512,app,"; !strings.HasPrefix(attributes[1], want) {
+		t.Errorf("container attributes row = %q, want it to start with %q", attributes[1], want)
+	}
+	if !strings.HasSuffix(attributes[1], ",payments") {
+		t.Errorf("container attributes row = %q, want the Business Unit payments", attributes[1])
+	}
+
+	if nodes := readLines(t, dir, "node/config.csv"); len(nodes) != 2 || !strings.HasPrefix(nodes[1], "east,n1,") {
+		t.Errorf("node config = %q, want the node n1", nodes)
+	}
+	if cluster := readLines(t, dir, "cluster/config.csv"); strings.Join(cluster, "\n") != "cluster\neast" {
+		t.Errorf("cluster config = %q", cluster)
+	}
+	if cluster := readLines(t, dir, "cluster/attributes.csv"); len(cluster) != 2 || !strings.HasPrefix(cluster[1], "east,") {
+		t.Errorf("cluster attributes = %q", cluster)
+	}
+	if workload := readLines(t, dir, "node/cpu_utilization.csv"); strings.Join(workload, "\n") != "cluster,node,Datetime,CPU Utilization\neast,n1,2024-03-01 12:00:00.000,12.500000" {
+		t.Errorf("node workload = %q", workload)
+	}
+
+	//Levels that weren't collected aren't written.
+	if _, err := os.Stat(dir + "/node_group"); !os.IsNotExist(err) {
+		t.Errorf("node group directory written for a result without node groups: %v", err)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name   string
+		result *collector.Result
+		opts   writer.Options
+	}{
+		{"no result", nil, writer.Options{Dir: dir}},
+		{"no directory", testResult(), writer.Options{}},
+		{"bad label column", testResult(), writer.Options{Dir: dir, LabelColumns: []string{"label_team"}}},
+		{"bad deny list", testResult(), writer.Options{Dir: dir, LabelDeny: []string{"label_("}}},
+	}
+	for _, test := range tests {
+		if err := writer.Write(test.result, test.opts); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}